@@ -1,7 +1,11 @@
 package cli
 
 import (
+	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 
 	docker "github.com/fsouza/go-dockerclient"
 	"github.com/vigasin/ofelia/core"
@@ -13,11 +17,16 @@ import (
 )
 
 const (
-	logFormat     = "%{color}%{shortfile} ▶ %{level}%{color:reset} %{message}"
-	jobExec       = "job-exec"
-	jobRun        = "job-run"
-	jobServiceRun = "job-service-run"
-	jobLocal      = "job-local"
+	logFormat       = "%{color}%{shortfile} ▶ %{level}%{color:reset} %{message}"
+	jobExec         = "job-exec"
+	jobRun          = "job-run"
+	jobServiceRun   = "job-service-run"
+	jobLocal        = "job-local"
+	jobHTTP         = "job-http"
+	jobCompose      = "job-compose"
+	jobContainer    = "job-container"
+	jobPrune        = "job-prune"
+	jobVolumeBackup = "job-volume-backup"
 )
 
 var IsDockerEnv bool
@@ -25,40 +34,68 @@ var IsDockerEnv bool
 // Config contains the configuration
 type Config struct {
 	Global struct {
-		middlewares.SlackConfig `mapstructure:",squash"`
-		middlewares.SaveConfig  `mapstructure:",squash"`
-		middlewares.MailConfig  `mapstructure:",squash"`
+		middlewares.SlackConfig   `mapstructure:",squash"`
+		middlewares.SaveConfig    `mapstructure:",squash"`
+		middlewares.MailConfig    `mapstructure:",squash"`
+		middlewares.WebhookConfig `mapstructure:",squash"`
+		middlewares.NotifyConfig  `mapstructure:",squash"`
+		middlewares.PingConfig    `mapstructure:",squash"`
+		middlewares.SyslogConfig  `mapstructure:",squash"`
+		middlewares.GELFConfig    `mapstructure:",squash"`
+
+		StorePath          string `gcfg:"store-path" mapstructure:"store-path"`
+		StoreMaxOutput     int    `gcfg:"store-max-output" mapstructure:"store-max-output" default:"1048576"`
+		StoreMaxAge        string `gcfg:"store-max-age" mapstructure:"store-max-age"`
+		StoreMaxExecutions int    `gcfg:"store-max-executions" mapstructure:"store-max-executions"`
+
+		LogOutputLines   bool `gcfg:"log-output-lines" mapstructure:"log-output-lines"`
+		LogOutputMaxSize int  `gcfg:"log-output-max-size" mapstructure:"log-output-max-size"`
+		LogOutputLive    bool `gcfg:"log-output-live" mapstructure:"log-output-live"`
+
+		RedactPatterns []string `gcfg:"redact-patterns" mapstructure:"redact-patterns"`
 	}
-	ExecJobs    map[string]*ExecJobConfig    `gcfg:"job-exec" mapstructure:"job-exec,squash"`
-	RunJobs     map[string]*RunJobConfig     `gcfg:"job-run" mapstructure:"job-run,squash"`
-	ServiceJobs map[string]*RunServiceConfig `gcfg:"job-service-run" mapstructure:"job-service-run,squash"`
-	LocalJobs   map[string]*LocalJobConfig   `gcfg:"job-local" mapstructure:"job-local,squash"`
+	ExecJobs         map[string]*ExecJobConfig         `gcfg:"job-exec" mapstructure:"job-exec,squash"`
+	RunJobs          map[string]*RunJobConfig          `gcfg:"job-run" mapstructure:"job-run,squash"`
+	ServiceJobs      map[string]*RunServiceConfig      `gcfg:"job-service-run" mapstructure:"job-service-run,squash"`
+	LocalJobs        map[string]*LocalJobConfig        `gcfg:"job-local" mapstructure:"job-local,squash"`
+	HTTPJobs         map[string]*HTTPJobConfig         `gcfg:"job-http" mapstructure:"job-http,squash"`
+	ComposeJobs      map[string]*ComposeJobConfig      `gcfg:"job-compose" mapstructure:"job-compose,squash"`
+	ContainerJobs    map[string]*ContainerJobConfig    `gcfg:"job-container" mapstructure:"job-container,squash"`
+	PruneJobs        map[string]*PruneJobConfig        `gcfg:"job-prune" mapstructure:"job-prune,squash"`
+	VolumeBackupJobs map[string]*VolumeBackupJobConfig `gcfg:"job-volume-backup" mapstructure:"job-volume-backup,squash"`
+
+	logger core.Logger
 }
 
-// BuildFromDockerLabels builds a scheduler using the config from a docker labels
-func BuildFromDockerLabels() (*core.Scheduler, error) {
-	c := &Config{}
+// BuildFromDockerLabels builds a scheduler using the config from a docker
+// labels, logging to the given logger or to stdout if nil
+func BuildFromDockerLabels(logger core.Logger) (*core.Scheduler, error) {
+	c := &Config{logger: logger}
+	if err := c.readDockerLabels(); err != nil {
+		return nil, err
+	}
+
+	return c.build()
+}
 
+func (c *Config) readDockerLabels() error {
 	d, err := c.buildDockerClient()
 	if err != nil {
-		return nil, err
+		return err
 	}
 
 	labels, err := getLabels(d)
 	if err != nil {
-		return nil, err
-	}
-
-	if err := c.buildFromDockerLabels(labels); err != nil {
-		return nil, err
+		return err
 	}
 
-	return c.build()
+	return c.buildFromDockerLabels(labels)
 }
 
-// BuildFromFile builds a scheduler using the config from a file
-func BuildFromFile(filename string) (*core.Scheduler, error) {
-	c := &Config{}
+// BuildFromFile builds a scheduler using the config from a file, logging to
+// the given logger or to stdout if nil
+func BuildFromFile(filename string, logger core.Logger) (*core.Scheduler, error) {
+	c := &Config{logger: logger}
 	if err := gcfg.ReadFileInto(c, filename); err != nil {
 		return nil, err
 	}
@@ -66,9 +103,10 @@ func BuildFromFile(filename string) (*core.Scheduler, error) {
 	return c.build()
 }
 
-// BuildFromString builds a scheduler using the config from a string
-func BuildFromString(config string) (*core.Scheduler, error) {
-	c := &Config{}
+// BuildFromString builds a scheduler using the config from a string, logging
+// to the given logger or to stdout if nil
+func BuildFromString(config string, logger core.Logger) (*core.Scheduler, error) {
+	c := &Config{logger: logger}
 	if err := gcfg.ReadStringInto(c, config); err != nil {
 		return nil, err
 	}
@@ -85,13 +123,29 @@ func (c *Config) build() (*core.Scheduler, error) {
 	}
 
 	sh := core.NewScheduler(c.buildLogger())
+	sh.LogOutputLines = c.Global.LogOutputLines
+	sh.LogOutputMaxSize = c.Global.LogOutputMaxSize
+	sh.LogOutputLive = c.Global.LogOutputLive
+	c.setNotifyStateFile(&c.Global.NotifyConfig)
 	c.buildSchedulerMiddlewares(sh)
 
+	if sh.Store, err = c.buildStore(); err != nil {
+		return nil, err
+	}
+
 	for name, j := range c.ExecJobs {
 		defaults.SetDefaults(j)
+		if j.Container != "" && len(j.ContainerSelector) != 0 {
+			return nil, fmt.Errorf("job %q can't set both container and container-selector", name)
+		}
+
+		if err := j.ParseExitCodes(); err != nil {
+			return nil, fmt.Errorf("%s of job %q", err, name)
+		}
 
 		j.Client = d
 		j.Name = name
+		c.inheritGlobal(&j.SlackConfig, &j.MailConfig, &j.WebhookConfig, &j.PingConfig, &j.NotifyConfig, &j.SyslogConfig, &j.GELFConfig)
 		j.buildMiddlewares()
 		sh.AddJob(j)
 	}
@@ -101,6 +155,7 @@ func (c *Config) build() (*core.Scheduler, error) {
 
 		j.Client = d
 		j.Name = name
+		c.inheritGlobal(&j.SlackConfig, &j.MailConfig, &j.WebhookConfig, &j.PingConfig, &j.NotifyConfig, &j.SyslogConfig, &j.GELFConfig)
 		j.buildMiddlewares()
 		sh.AddJob(j)
 	}
@@ -109,6 +164,7 @@ func (c *Config) build() (*core.Scheduler, error) {
 		defaults.SetDefaults(j)
 
 		j.Name = name
+		c.inheritGlobal(&j.SlackConfig, &j.MailConfig, &j.WebhookConfig, &j.PingConfig, &j.NotifyConfig, &j.SyslogConfig, &j.GELFConfig)
 		j.buildMiddlewares()
 		sh.AddJob(j)
 	}
@@ -117,13 +173,138 @@ func (c *Config) build() (*core.Scheduler, error) {
 		defaults.SetDefaults(j)
 		j.Name = name
 		j.Client = d
+		c.inheritGlobal(&j.SlackConfig, &j.MailConfig, &j.WebhookConfig, &j.PingConfig, &j.NotifyConfig, &j.SyslogConfig, &j.GELFConfig)
+		j.buildMiddlewares()
+		sh.AddJob(j)
+	}
+
+	for name, j := range c.HTTPJobs {
+		defaults.SetDefaults(j)
+
+		j.Name = name
+		c.inheritGlobal(&j.SlackConfig, &j.MailConfig, &j.WebhookConfig, &j.PingConfig, &j.NotifyConfig, &j.SyslogConfig, &j.GELFConfig)
+		j.buildMiddlewares()
+		sh.AddJob(j)
+	}
+
+	for name, j := range c.ComposeJobs {
+		defaults.SetDefaults(j)
+
+		j.Client = d
+		j.Name = name
+		c.inheritGlobal(&j.SlackConfig, &j.MailConfig, &j.WebhookConfig, &j.PingConfig, &j.NotifyConfig, &j.SyslogConfig, &j.GELFConfig)
+		j.buildMiddlewares()
+		sh.AddJob(j)
+	}
+
+	for name, j := range c.ContainerJobs {
+		defaults.SetDefaults(j)
+		if j.Container != "" && len(j.ContainerSelector) != 0 {
+			return nil, fmt.Errorf("job %q can't set both container and container-selector", name)
+		}
+
+		j.Client = d
+		j.Name = name
+		c.inheritGlobal(&j.SlackConfig, &j.MailConfig, &j.WebhookConfig, &j.PingConfig, &j.NotifyConfig, &j.SyslogConfig, &j.GELFConfig)
+		j.buildMiddlewares()
+		sh.AddJob(j)
+	}
+
+	for name, j := range c.PruneJobs {
+		defaults.SetDefaults(j)
+
+		j.Client = d
+		j.Name = name
+		c.inheritGlobal(&j.SlackConfig, &j.MailConfig, &j.WebhookConfig, &j.PingConfig, &j.NotifyConfig, &j.SyslogConfig, &j.GELFConfig)
+		j.buildMiddlewares()
+		sh.AddJob(j)
+	}
+
+	for name, j := range c.VolumeBackupJobs {
+		defaults.SetDefaults(j)
+
+		j.Client = d
+		j.Name = name
+		c.inheritGlobal(&j.SlackConfig, &j.MailConfig, &j.WebhookConfig, &j.PingConfig, &j.NotifyConfig, &j.SyslogConfig, &j.GELFConfig)
 		j.buildMiddlewares()
 		sh.AddJob(j)
 	}
 
+	if sh.Redactor, err = c.buildRedactor(sh); err != nil {
+		return nil, err
+	}
+
+	if err := validateMiddlewares(sh.Middlewares()); err != nil {
+		return nil, err
+	}
+
+	for _, j := range sh.Jobs {
+		if level := j.GetLogLevel(); level != "" {
+			if _, err := logging.LogLevel(level); err != nil {
+				return nil, fmt.Errorf("invalid log-level %q of job %q", level, j.GetName())
+			}
+		}
+
+		if err := validateMiddlewares(j.Middlewares()); err != nil {
+			return nil, fmt.Errorf("%s of job %q", err, j.GetName())
+		}
+	}
+
 	return sh, nil
 }
 
+// validateMiddlewares returns the first error of the middlewares validating
+// their configuration
+func validateMiddlewares(ms []core.Middleware) error {
+	for _, m := range ms {
+		if v, ok := m.(interface{ Validate() error }); ok {
+			if err := v.Validate(); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// inheritGlobal completes the notifier configurations of a job with the ones
+// of the global section
+func (c *Config) inheritGlobal(
+	slack *middlewares.SlackConfig,
+	mail *middlewares.MailConfig,
+	webhook *middlewares.WebhookConfig,
+	ping *middlewares.PingConfig,
+	notify *middlewares.NotifyConfig,
+	syslog *middlewares.SyslogConfig,
+	gelf *middlewares.GELFConfig,
+) {
+	slack.Inherit(&c.Global.SlackConfig)
+	mail.Inherit(&c.Global.MailConfig)
+	webhook.Inherit(&c.Global.WebhookConfig)
+	ping.Inherit(&c.Global.PingConfig)
+	notify.Inherit(&c.Global.NotifyConfig)
+	c.setNotifyStateFile(notify)
+	syslog.Inherit(&c.Global.SyslogConfig)
+	gelf.Inherit(&c.Global.GELFConfig)
+}
+
+// setNotifyStateFile persists the status of the jobs of a notification policy
+// by default, next to the store if any, or in the cache directory of the user
+func (c *Config) setNotifyStateFile(notify *middlewares.NotifyConfig) {
+	if middlewares.IsEmpty(notify) || notify.NotifyStateFile != "" {
+		return
+	}
+
+	if c.Global.StorePath != "" {
+		notify.NotifyStateFile = filepath.Join(filepath.Dir(c.Global.StorePath), "notify-state.json")
+		return
+	}
+
+	if dir, err := os.UserCacheDir(); err == nil {
+		notify.NotifyStateFile = filepath.Join(dir, "ofelia", "notify-state.json")
+	}
+}
+
 func (c *Config) buildDockerClient() (*docker.Client, error) {
 	d, err := docker.NewClientFromEnv()
 	if err != nil {
@@ -133,19 +314,188 @@ func (c *Config) buildDockerClient() (*docker.Client, error) {
 	return d, nil
 }
 
+var (
+	storesMu sync.Mutex
+	stores   = make(map[string]*core.BoltStore)
+)
+
+// closeStores closes the execution stores, once the scheduler is stopped for
+// good
+func closeStores() error {
+	storesMu.Lock()
+	defer storesMu.Unlock()
+
+	var err error
+	for path, s := range stores {
+		if e := s.Close(); e != nil && err == nil {
+			err = e
+		}
+
+		delete(stores, path)
+	}
+
+	return err
+}
+
+// buildStore returns the execution store, if any. The stores are kept open
+// across the rebuilds of the scheduler, their file is locked while open.
+func (c *Config) buildStore() (core.ExecutionStore, error) {
+	if c.Global.StorePath == "" {
+		return nil, nil
+	}
+
+	maxAge, err := core.ParseAge(c.Global.StoreMaxAge)
+	if err != nil {
+		return nil, fmt.Errorf("invalid store-max-age %q: %s", c.Global.StoreMaxAge, err)
+	}
+
+	storesMu.Lock()
+	defer storesMu.Unlock()
+
+	// the store of a previous store-path is not used anymore
+	for path, s := range stores {
+		if path != c.Global.StorePath {
+			s.Close()
+			delete(stores, path)
+		}
+	}
+
+	s, ok := stores[c.Global.StorePath]
+	if !ok {
+		var err error
+		if s, err = core.NewBoltStore(c.Global.StorePath); err != nil {
+			return nil, err
+		}
+
+		stores[c.Global.StorePath] = s
+	}
+
+	s.MaxOutput = c.Global.StoreMaxOutput
+	s.Retention = core.RetentionPolicy{MaxAge: maxAge, MaxExecutions: c.Global.StoreMaxExecutions}
+	if _, err := s.Prune(s.Retention); err != nil {
+		return nil, fmt.Errorf("error pruning store: %s", err)
+	}
+
+	return s, nil
+}
+
+// buildRedactor returns the redactor of the patterns and of the credentials
+// of the jobs and the middlewares, so they never leak in the outputs
+func (c *Config) buildRedactor(sh *core.Scheduler) (*core.Redactor, error) {
+	var secrets []string
+	addSecrets := func(ms []core.Middleware) {
+		for _, m := range ms {
+			switch m := m.(type) {
+			case *middlewares.Mail:
+				secrets = append(secrets, m.SMTPPassword)
+			case *middlewares.Slack:
+				secrets = append(secrets, m.SlackToken, m.SlackWebhook)
+			case *middlewares.Webhook:
+				secrets = append(secrets, m.WebhookURL)
+				secrets = append(secrets, headerSecrets(m.WebhookHeaders)...)
+			case *middlewares.Ping:
+				secrets = append(secrets, m.PingURL, m.PingStartURL, m.PingSuccessURL, m.PingFailURL)
+			}
+		}
+	}
+
+	addSecrets(sh.Middlewares())
+	for _, j := range sh.Jobs {
+		addSecrets(j.Middlewares())
+		secrets = append(secrets, jobSecrets(j)...)
+	}
+
+	r, err := core.NewRedactor(c.Global.RedactPatterns, secrets)
+	if err != nil {
+		return nil, fmt.Errorf("invalid redact-patterns: %s", err)
+	}
+
+	return r, nil
+}
+
+// jobSecrets returns the credentials of a job, and the values of its
+// environment variables named like a secret
+func jobSecrets(j core.Job) []string {
+	var secrets, env []string
+	switch j := j.(type) {
+	case *HTTPJobConfig:
+		secrets = append(secrets, j.Password, j.BearerToken)
+		secrets = append(secrets, headerSecrets(j.Headers)...)
+	case *RunJobConfig:
+		secrets = core.RegistrySecrets(j.Image)
+		env = strings.Split(j.Environment, ";")
+	case *RunServiceConfig:
+		secrets = core.RegistrySecrets(j.Image)
+		env = j.Environment
+	case *ExecJobConfig:
+		env = j.Environment
+	case *LocalJobConfig:
+		env = j.Environment
+	case *ComposeJobConfig:
+		env = j.Environment
+	}
+
+	for _, v := range env {
+		if parts := strings.SplitN(v, "=", 2); len(parts) == 2 && isSecretName(parts[0]) {
+			secrets = append(secrets, parts[1])
+		}
+	}
+
+	return secrets
+}
+
+// headerSecrets returns the values of the headers named like a secret, e.g.
+// the token of "Authorization: Bearer <token>"
+func headerSecrets(headers []string) []string {
+	var secrets []string
+	for _, h := range headers {
+		parts := strings.SplitN(h, ":", 2)
+		if len(parts) != 2 || !isSecretName(parts[0]) {
+			continue
+		}
+
+		fields := strings.Fields(parts[1])
+		if len(fields) > 0 {
+			secrets = append(secrets, fields[len(fields)-1])
+		}
+	}
+
+	return secrets
+}
+
+var secretNames = []string{"auth", "cookie", "credential", "key", "passw", "secret", "token"}
+
+// isSecretName returns if the name of a variable or a header suggests its
+// value is a secret, e.g. DB_PASSWORD or X-Api-Key
+func isSecretName(name string) bool {
+	name = strings.ToLower(name)
+	for _, s := range secretNames {
+		if strings.Contains(name, s) {
+			return true
+		}
+	}
+
+	return false
+}
+
 func (c *Config) buildLogger() core.Logger {
-	stdout := logging.NewLogBackend(os.Stdout, "", 0)
-	// Set the backends to be used.
-	logging.SetBackend(stdout)
-	logging.SetFormatter(logging.MustStringFormatter(logFormat))
+	if c.logger != nil {
+		return c.logger
+	}
 
-	return logging.MustGetLogger("ofelia")
+	l, _ := NewLogger(os.Stdout, LogFormatText, "debug")
+	return l
 }
 
 func (c *Config) buildSchedulerMiddlewares(sh *core.Scheduler) {
 	sh.Use(middlewares.NewSlack(&c.Global.SlackConfig))
 	sh.Use(middlewares.NewSave(&c.Global.SaveConfig))
 	sh.Use(middlewares.NewMail(&c.Global.MailConfig))
+	sh.Use(middlewares.NewWebhook(&c.Global.WebhookConfig))
+	sh.Use(middlewares.NewNotify(&c.Global.NotifyConfig))
+	sh.Use(middlewares.NewPing(&c.Global.PingConfig))
+	sh.Use(middlewares.NewSyslog(&c.Global.SyslogConfig))
+	sh.Use(middlewares.NewGELF(&c.Global.GELFConfig))
 }
 
 // ExecJobConfig contains all configuration params needed to build a ExecJob
@@ -155,6 +505,11 @@ type ExecJobConfig struct {
 	middlewares.SlackConfig   `mapstructure:",squash"`
 	middlewares.SaveConfig    `mapstructure:",squash"`
 	middlewares.MailConfig    `mapstructure:",squash"`
+	middlewares.WebhookConfig `mapstructure:",squash"`
+	middlewares.NotifyConfig  `mapstructure:",squash"`
+	middlewares.PingConfig    `mapstructure:",squash"`
+	middlewares.SyslogConfig  `mapstructure:",squash"`
+	middlewares.GELFConfig    `mapstructure:",squash"`
 }
 
 func (c *ExecJobConfig) GetName() string {
@@ -162,6 +517,10 @@ func (c *ExecJobConfig) GetName() string {
 }
 
 func (c *ExecJobConfig) GetLabel() string {
+	if c.Container == "" {
+		return strings.Join(c.ContainerSelector, ",")
+	}
+
 	return c.Container
 }
 
@@ -170,6 +529,11 @@ func (c *ExecJobConfig) buildMiddlewares() {
 	c.ExecJob.Use(middlewares.NewSlack(&c.SlackConfig))
 	c.ExecJob.Use(middlewares.NewSave(&c.SaveConfig))
 	c.ExecJob.Use(middlewares.NewMail(&c.MailConfig))
+	c.ExecJob.Use(middlewares.NewWebhook(&c.WebhookConfig))
+	c.ExecJob.Use(middlewares.NewNotify(&c.NotifyConfig))
+	c.ExecJob.Use(middlewares.NewPing(&c.PingConfig))
+	c.ExecJob.Use(middlewares.NewSyslog(&c.SyslogConfig))
+	c.ExecJob.Use(middlewares.NewGELF(&c.GELFConfig))
 }
 
 // RunServiceConfig contains all configuration params needed to build a RunJob
@@ -179,6 +543,11 @@ type RunServiceConfig struct {
 	middlewares.SlackConfig   `mapstructure:",squash"`
 	middlewares.SaveConfig    `mapstructure:",squash"`
 	middlewares.MailConfig    `mapstructure:",squash"`
+	middlewares.WebhookConfig `mapstructure:",squash"`
+	middlewares.NotifyConfig  `mapstructure:",squash"`
+	middlewares.PingConfig    `mapstructure:",squash"`
+	middlewares.SyslogConfig  `mapstructure:",squash"`
+	middlewares.GELFConfig    `mapstructure:",squash"`
 }
 
 func (c *RunServiceConfig) GetLabel() string {
@@ -191,6 +560,11 @@ type RunJobConfig struct {
 	middlewares.SlackConfig   `mapstructure:",squash"`
 	middlewares.SaveConfig    `mapstructure:",squash"`
 	middlewares.MailConfig    `mapstructure:",squash"`
+	middlewares.WebhookConfig `mapstructure:",squash"`
+	middlewares.NotifyConfig  `mapstructure:",squash"`
+	middlewares.PingConfig    `mapstructure:",squash"`
+	middlewares.SyslogConfig  `mapstructure:",squash"`
+	middlewares.GELFConfig    `mapstructure:",squash"`
 }
 
 func (c *RunJobConfig) GetLabel() string {
@@ -202,6 +576,11 @@ func (c *RunJobConfig) buildMiddlewares() {
 	c.RunJob.Use(middlewares.NewSlack(&c.SlackConfig))
 	c.RunJob.Use(middlewares.NewSave(&c.SaveConfig))
 	c.RunJob.Use(middlewares.NewMail(&c.MailConfig))
+	c.RunJob.Use(middlewares.NewWebhook(&c.WebhookConfig))
+	c.RunJob.Use(middlewares.NewNotify(&c.NotifyConfig))
+	c.RunJob.Use(middlewares.NewPing(&c.PingConfig))
+	c.RunJob.Use(middlewares.NewSyslog(&c.SyslogConfig))
+	c.RunJob.Use(middlewares.NewGELF(&c.GELFConfig))
 }
 
 // LocalJobConfig contains all configuration params needed to build a RunJob
@@ -211,6 +590,11 @@ type LocalJobConfig struct {
 	middlewares.SlackConfig   `mapstructure:",squash"`
 	middlewares.SaveConfig    `mapstructure:",squash"`
 	middlewares.MailConfig    `mapstructure:",squash"`
+	middlewares.WebhookConfig `mapstructure:",squash"`
+	middlewares.NotifyConfig  `mapstructure:",squash"`
+	middlewares.PingConfig    `mapstructure:",squash"`
+	middlewares.SyslogConfig  `mapstructure:",squash"`
+	middlewares.GELFConfig    `mapstructure:",squash"`
 }
 
 func (c *LocalJobConfig) GetLabel() string {
@@ -222,6 +606,11 @@ func (c *LocalJobConfig) buildMiddlewares() {
 	c.LocalJob.Use(middlewares.NewSlack(&c.SlackConfig))
 	c.LocalJob.Use(middlewares.NewSave(&c.SaveConfig))
 	c.LocalJob.Use(middlewares.NewMail(&c.MailConfig))
+	c.LocalJob.Use(middlewares.NewWebhook(&c.WebhookConfig))
+	c.LocalJob.Use(middlewares.NewNotify(&c.NotifyConfig))
+	c.LocalJob.Use(middlewares.NewPing(&c.PingConfig))
+	c.LocalJob.Use(middlewares.NewSyslog(&c.SyslogConfig))
+	c.LocalJob.Use(middlewares.NewGELF(&c.GELFConfig))
 }
 
 func (c *RunServiceConfig) buildMiddlewares() {
@@ -229,4 +618,163 @@ func (c *RunServiceConfig) buildMiddlewares() {
 	c.RunServiceJob.Use(middlewares.NewSlack(&c.SlackConfig))
 	c.RunServiceJob.Use(middlewares.NewSave(&c.SaveConfig))
 	c.RunServiceJob.Use(middlewares.NewMail(&c.MailConfig))
+	c.RunServiceJob.Use(middlewares.NewWebhook(&c.WebhookConfig))
+	c.RunServiceJob.Use(middlewares.NewNotify(&c.NotifyConfig))
+	c.RunServiceJob.Use(middlewares.NewPing(&c.PingConfig))
+	c.RunServiceJob.Use(middlewares.NewSyslog(&c.SyslogConfig))
+	c.RunServiceJob.Use(middlewares.NewGELF(&c.GELFConfig))
+}
+
+// HTTPJobConfig contains all configuration params needed to build a HTTPJob
+type HTTPJobConfig struct {
+	core.HTTPJob              `mapstructure:",squash"`
+	middlewares.OverlapConfig `mapstructure:",squash"`
+	middlewares.SlackConfig   `mapstructure:",squash"`
+	middlewares.SaveConfig    `mapstructure:",squash"`
+	middlewares.MailConfig    `mapstructure:",squash"`
+	middlewares.WebhookConfig `mapstructure:",squash"`
+	middlewares.NotifyConfig  `mapstructure:",squash"`
+	middlewares.PingConfig    `mapstructure:",squash"`
+	middlewares.SyslogConfig  `mapstructure:",squash"`
+	middlewares.GELFConfig    `mapstructure:",squash"`
+}
+
+func (c *HTTPJobConfig) GetLabel() string {
+	return c.URL
+}
+
+func (c *HTTPJobConfig) buildMiddlewares() {
+	c.HTTPJob.Use(middlewares.NewOverlap(&c.OverlapConfig))
+	c.HTTPJob.Use(middlewares.NewSlack(&c.SlackConfig))
+	c.HTTPJob.Use(middlewares.NewSave(&c.SaveConfig))
+	c.HTTPJob.Use(middlewares.NewMail(&c.MailConfig))
+	c.HTTPJob.Use(middlewares.NewWebhook(&c.WebhookConfig))
+	c.HTTPJob.Use(middlewares.NewNotify(&c.NotifyConfig))
+	c.HTTPJob.Use(middlewares.NewPing(&c.PingConfig))
+	c.HTTPJob.Use(middlewares.NewSyslog(&c.SyslogConfig))
+	c.HTTPJob.Use(middlewares.NewGELF(&c.GELFConfig))
+}
+
+// ComposeJobConfig contains all configuration params needed to build a ComposeJob
+type ComposeJobConfig struct {
+	core.ComposeJob           `mapstructure:",squash"`
+	middlewares.OverlapConfig `mapstructure:",squash"`
+	middlewares.SlackConfig   `mapstructure:",squash"`
+	middlewares.SaveConfig    `mapstructure:",squash"`
+	middlewares.MailConfig    `mapstructure:",squash"`
+	middlewares.WebhookConfig `mapstructure:",squash"`
+	middlewares.NotifyConfig  `mapstructure:",squash"`
+	middlewares.PingConfig    `mapstructure:",squash"`
+	middlewares.SyslogConfig  `mapstructure:",squash"`
+	middlewares.GELFConfig    `mapstructure:",squash"`
+}
+
+func (c *ComposeJobConfig) GetLabel() string {
+	return c.Project + "/" + c.Service
+}
+
+func (c *ComposeJobConfig) buildMiddlewares() {
+	c.ComposeJob.Use(middlewares.NewOverlap(&c.OverlapConfig))
+	c.ComposeJob.Use(middlewares.NewSlack(&c.SlackConfig))
+	c.ComposeJob.Use(middlewares.NewSave(&c.SaveConfig))
+	c.ComposeJob.Use(middlewares.NewMail(&c.MailConfig))
+	c.ComposeJob.Use(middlewares.NewWebhook(&c.WebhookConfig))
+	c.ComposeJob.Use(middlewares.NewNotify(&c.NotifyConfig))
+	c.ComposeJob.Use(middlewares.NewPing(&c.PingConfig))
+	c.ComposeJob.Use(middlewares.NewSyslog(&c.SyslogConfig))
+	c.ComposeJob.Use(middlewares.NewGELF(&c.GELFConfig))
+}
+
+// ContainerJobConfig contains all configuration params needed to build a ContainerJob
+type ContainerJobConfig struct {
+	core.ContainerJob         `mapstructure:",squash"`
+	middlewares.OverlapConfig `mapstructure:",squash"`
+	middlewares.SlackConfig   `mapstructure:",squash"`
+	middlewares.SaveConfig    `mapstructure:",squash"`
+	middlewares.MailConfig    `mapstructure:",squash"`
+	middlewares.WebhookConfig `mapstructure:",squash"`
+	middlewares.NotifyConfig  `mapstructure:",squash"`
+	middlewares.PingConfig    `mapstructure:",squash"`
+	middlewares.SyslogConfig  `mapstructure:",squash"`
+	middlewares.GELFConfig    `mapstructure:",squash"`
+}
+
+func (c *ContainerJobConfig) GetLabel() string {
+	if c.Container == "" {
+		return strings.Join(c.ContainerSelector, ",")
+	}
+
+	return c.Container
+}
+
+func (c *ContainerJobConfig) buildMiddlewares() {
+	c.ContainerJob.Use(middlewares.NewOverlap(&c.OverlapConfig))
+	c.ContainerJob.Use(middlewares.NewSlack(&c.SlackConfig))
+	c.ContainerJob.Use(middlewares.NewSave(&c.SaveConfig))
+	c.ContainerJob.Use(middlewares.NewMail(&c.MailConfig))
+	c.ContainerJob.Use(middlewares.NewWebhook(&c.WebhookConfig))
+	c.ContainerJob.Use(middlewares.NewNotify(&c.NotifyConfig))
+	c.ContainerJob.Use(middlewares.NewPing(&c.PingConfig))
+	c.ContainerJob.Use(middlewares.NewSyslog(&c.SyslogConfig))
+	c.ContainerJob.Use(middlewares.NewGELF(&c.GELFConfig))
+}
+
+// PruneJobConfig contains all configuration params needed to build a PruneJob
+type PruneJobConfig struct {
+	core.PruneJob             `mapstructure:",squash"`
+	middlewares.OverlapConfig `mapstructure:",squash"`
+	middlewares.SlackConfig   `mapstructure:",squash"`
+	middlewares.SaveConfig    `mapstructure:",squash"`
+	middlewares.MailConfig    `mapstructure:",squash"`
+	middlewares.WebhookConfig `mapstructure:",squash"`
+	middlewares.NotifyConfig  `mapstructure:",squash"`
+	middlewares.PingConfig    `mapstructure:",squash"`
+	middlewares.SyslogConfig  `mapstructure:",squash"`
+	middlewares.GELFConfig    `mapstructure:",squash"`
+}
+
+func (c *PruneJobConfig) GetLabel() string {
+	return c.GetCommand()
+}
+
+func (c *PruneJobConfig) buildMiddlewares() {
+	c.PruneJob.Use(middlewares.NewOverlap(&c.OverlapConfig))
+	c.PruneJob.Use(middlewares.NewSlack(&c.SlackConfig))
+	c.PruneJob.Use(middlewares.NewSave(&c.SaveConfig))
+	c.PruneJob.Use(middlewares.NewMail(&c.MailConfig))
+	c.PruneJob.Use(middlewares.NewWebhook(&c.WebhookConfig))
+	c.PruneJob.Use(middlewares.NewNotify(&c.NotifyConfig))
+	c.PruneJob.Use(middlewares.NewPing(&c.PingConfig))
+	c.PruneJob.Use(middlewares.NewSyslog(&c.SyslogConfig))
+	c.PruneJob.Use(middlewares.NewGELF(&c.GELFConfig))
+}
+
+// VolumeBackupJobConfig contains all configuration params needed to build a VolumeBackupJob
+type VolumeBackupJobConfig struct {
+	core.VolumeBackupJob      `mapstructure:",squash"`
+	middlewares.OverlapConfig `mapstructure:",squash"`
+	middlewares.SlackConfig   `mapstructure:",squash"`
+	middlewares.SaveConfig    `mapstructure:",squash"`
+	middlewares.MailConfig    `mapstructure:",squash"`
+	middlewares.WebhookConfig `mapstructure:",squash"`
+	middlewares.NotifyConfig  `mapstructure:",squash"`
+	middlewares.PingConfig    `mapstructure:",squash"`
+	middlewares.SyslogConfig  `mapstructure:",squash"`
+	middlewares.GELFConfig    `mapstructure:",squash"`
+}
+
+func (c *VolumeBackupJobConfig) GetLabel() string {
+	return strings.Join(c.Volume, ",")
+}
+
+func (c *VolumeBackupJobConfig) buildMiddlewares() {
+	c.VolumeBackupJob.Use(middlewares.NewOverlap(&c.OverlapConfig))
+	c.VolumeBackupJob.Use(middlewares.NewSlack(&c.SlackConfig))
+	c.VolumeBackupJob.Use(middlewares.NewSave(&c.SaveConfig))
+	c.VolumeBackupJob.Use(middlewares.NewMail(&c.MailConfig))
+	c.VolumeBackupJob.Use(middlewares.NewWebhook(&c.WebhookConfig))
+	c.VolumeBackupJob.Use(middlewares.NewNotify(&c.NotifyConfig))
+	c.VolumeBackupJob.Use(middlewares.NewPing(&c.PingConfig))
+	c.VolumeBackupJob.Use(middlewares.NewSyslog(&c.SyslogConfig))
+	c.VolumeBackupJob.Use(middlewares.NewGELF(&c.GELFConfig))
 }
@@ -1,6 +1,9 @@
 package cli
 
 import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
 	"testing"
 
 	defaults "github.com/mcuadros/go-defaults"
@@ -31,10 +34,372 @@ func (s *SuiteConfig) TestBuildFromString(c *C) {
 
 		[job-service-run "bob"]
 		schedule = @every 10s
-  `)
+
+		[job-http "alice"]
+		schedule = @every 10s
+		url = http://localhost/
+		headers = X-Foo: bar
+		headers = X-Qux: baz
+
+		[job-compose "migrate"]
+		schedule = @every 10s
+		project = myapp
+		service = migrations
+
+		[job-container "worker"]
+		schedule = @every 10s
+		action = restart
+		container = worker
+
+		[job-prune "cleanup"]
+		schedule = @every 10s
+		images = dangling
+		label-filter = env=test
+		label-filter = keep!=true
+
+		[job-volume-backup "backup"]
+		schedule = @every 10s
+		volume = data
+		target = /backups
+		keep-last = 7
+  `, nil)
+
+	c.Assert(err, IsNil)
+	c.Assert(sh.Jobs, HasLen, 10)
+}
+
+func (s *SuiteConfig) TestBuildStore(c *C) {
+	dir, err := ioutil.TempDir("", "store")
+	c.Assert(err, IsNil)
+	defer os.RemoveAll(dir)
+
+	config := `
+		[global]
+		store-path = ` + filepath.Join(dir, "ofelia.db") + `
+		store-max-age = 30d
+
+		[job-local "foo"]
+		schedule = @every 10s
+	`
+
+	sh, err := BuildFromString(config, nil)
+	c.Assert(err, IsNil)
+
+	store := sh.Store.(*core.BoltStore)
+	c.Assert(store.MaxOutput, Equals, 1048576)
+	c.Assert(store.Retention.MaxAge.Hours(), Equals, 720.0)
+
+	// the store is reused when the scheduler is rebuilt
+	sh, err = BuildFromString(config, nil)
+	c.Assert(err, IsNil)
+	c.Assert(sh.Store, Equals, store)
+
+	_, err = BuildFromString(`
+		[global]
+		store-path = `+filepath.Join(dir, "ofelia.db")+`
+		store-max-age = foo
+	`, nil)
+	c.Assert(err, ErrorMatches, `invalid store-max-age "foo": .*`)
+
+	// the store of the previous store-path is closed
+	sh, err = BuildFromString(`
+		[global]
+		store-path = `+filepath.Join(dir, "other.db")+`
+	`, nil)
+	c.Assert(err, IsNil)
+	c.Assert(sh.Store == store, Equals, false)
+	c.Assert(len(stores), Equals, 1)
+
+	_, err = store.Query(core.ExecutionQuery{})
+	c.Assert(err != nil, Equals, true)
+
+	c.Assert(closeStores(), IsNil)
+	c.Assert(len(stores), Equals, 0)
+}
+
+func (s *SuiteConfig) TestBuildNotifyStateFile(c *C) {
+	dir, err := ioutil.TempDir("", "store")
+	c.Assert(err, IsNil)
+	defer os.RemoveAll(dir)
+
+	sh, err := BuildFromString(`
+		[global]
+		store-path = `+filepath.Join(dir, "ofelia.db")+`
+		notify-on = change
+
+		[job-local "foo"]
+		schedule = @every 10s
+		command = echo foo
+		notify-threshold = 3
+
+		[job-local "bar"]
+		schedule = @every 10s
+		command = echo bar
+		notify-state-file = /tmp/bar.json
+	`, nil)
+
+	c.Assert(err, IsNil)
+	defer sh.Store.Close()
+
+	files := map[string]string{"global": notifyStateFile(sh.Middlewares())}
+	for _, j := range sh.Jobs {
+		files[j.GetName()] = notifyStateFile(j.Middlewares())
+	}
+
+	c.Assert(files, DeepEquals, map[string]string{
+		"global": filepath.Join(dir, "notify-state.json"),
+		"foo":    filepath.Join(dir, "notify-state.json"),
+		"bar":    "/tmp/bar.json",
+	})
+}
+
+func notifyStateFile(ms []core.Middleware) string {
+	for _, m := range ms {
+		if n, ok := m.(*middlewares.Notify); ok {
+			return n.NotifyStateFile
+		}
+	}
+
+	return ""
+}
+
+func (s *SuiteConfig) TestBuildJobLogLevel(c *C) {
+	sh, err := BuildFromString(`
+		[global]
+		log-output-lines = true
+		log-output-max-size = 1024
+
+		[job-local "foo"]
+		schedule = @every 10s
+		command = echo foo
+		log-level = warning
+	`, nil)
+
+	c.Assert(err, IsNil)
+	c.Assert(sh.LogOutputLines, Equals, true)
+	c.Assert(sh.LogOutputMaxSize, Equals, 1024)
+	c.Assert(sh.Jobs[0].GetLogLevel(), Equals, "warning")
+
+	_, err = BuildFromString(`
+		[job-local "foo"]
+		schedule = @every 10s
+		command = echo foo
+		log-level = foo
+	`, nil)
+
+	c.Assert(err, ErrorMatches, `invalid log-level "foo" of job "foo"`)
+}
+
+func (s *SuiteConfig) TestBuildMailOnlyOnError(c *C) {
+	sh, err := BuildFromString(`
+		[global]
+		smtp-host = localhost
+		email-to = foo@foo.com
+		mail-only-on-error = true
+
+		[job-local "foo"]
+		schedule = @every 10s
+		command = echo foo
+		email-to = bar@bar.com
+		mail-only-on-error = false
+	`, nil)
+
+	c.Assert(err, IsNil)
+	c.Assert(sh.Jobs[0].Middlewares(), HasLen, 1)
+
+	m := sh.Jobs[0].Middlewares()[0].(*middlewares.Mail)
+	c.Assert(m.EmailTo, Equals, "bar@bar.com")
+	c.Assert(*m.MailOnlyOnError, Equals, false)
+}
+
+func (s *SuiteConfig) TestBuildMailInvalid(c *C) {
+	_, err := BuildFromString(`
+		[job-local "foo"]
+		schedule = @every 10s
+		command = echo foo
+		smtp-host = localhost
+		email-to = foo@foo.com
+		mail-body-template = /non-existent/body.html
+	`, nil)
+
+	c.Assert(err, ErrorMatches, `error reading mail-body-template: .* of job "foo"`)
+}
+
+func (s *SuiteConfig) TestBuildNotifyInvalid(c *C) {
+	_, err := BuildFromString(`
+		[global]
+		notify-on = foo
+	`, nil)
+
+	c.Assert(err, ErrorMatches, `invalid notify-on "foo"`)
+
+	_, err = BuildFromString(`
+		[job-local "foo"]
+		schedule = @every 10s
+		command = echo foo
+		notify-interval = foo
+	`, nil)
+
+	c.Assert(err, ErrorMatches, `invalid notify-interval "foo": .* of job "foo"`)
+}
+
+func (s *SuiteConfig) TestBuildWebhookInherit(c *C) {
+	sh, err := BuildFromString(`
+		[global]
+		webhook-url = http://localhost/
+		webhook-only-on-error = true
+		ping-timeout = 5s
+
+		[job-local "foo"]
+		schedule = @every 10s
+		command = echo foo
+		webhook-preset = slack
+		ping-url = http://localhost/foo
+	`, nil)
+
+	c.Assert(err, IsNil)
+	c.Assert(sh.Jobs[0].Middlewares(), HasLen, 2)
+
+	w := sh.Jobs[0].Middlewares()[0].(*middlewares.Webhook)
+	c.Assert(w.WebhookURL, Equals, "http://localhost/")
+	c.Assert(*w.WebhookOnlyOnError, Equals, true)
+
+	p := sh.Jobs[0].Middlewares()[1].(*middlewares.Ping)
+	c.Assert(p.PingTimeout, Equals, "5s")
+
+	_, err = BuildFromString(`
+		[job-local "foo"]
+		schedule = @every 10s
+		command = echo foo
+		webhook-url = http://localhost/
+		webhook-preset = foo
+	`, nil)
+
+	c.Assert(err, ErrorMatches, `invalid webhook-preset "foo" of job "foo"`)
+}
+
+func (s *SuiteConfig) TestBuildSaveInvalid(c *C) {
+	_, err := BuildFromString(`
+		[global]
+		save-folder = /tmp
+		save-compression = foo
+	`, nil)
+
+	c.Assert(err, ErrorMatches, `invalid save-compression "foo"`)
+
+	_, err = BuildFromString(`
+		[job-local "foo"]
+		schedule = @every 10s
+		command = echo foo
+		save-folder = /tmp
+		save-max-age = foo
+	`, nil)
+
+	c.Assert(err, ErrorMatches, `invalid save-max-age "foo": .* of job "foo"`)
+}
+
+func (s *SuiteConfig) TestBuildSyslogInvalid(c *C) {
+	_, err := BuildFromString(`
+		[global]
+		syslog-address = udp://localhost:514
+
+		[job-local "foo"]
+		schedule = @every 10s
+		command = echo foo
+		syslog-facility = foo
+	`, nil)
+
+	c.Assert(err, ErrorMatches, `invalid syslog-facility "foo" of job "foo"`)
+
+	_, err = BuildFromString(`
+		[global]
+		gelf-tag = foo
+	`, nil)
+
+	c.Assert(err, ErrorMatches, "gelf-address is required")
+}
+
+func (s *SuiteConfig) TestBuildRedactor(c *C) {
+	sh, err := BuildFromString(`
+		[global]
+		smtp-password = s3cr3t
+		redact-patterns = "token=(\\w+)"
+
+		webhook-url = https://hooks.example.com/h00k
+		webhook-headers = Authorization: Bearer w3bh00k
+		webhook-headers = Content-Type: application/json
+
+		[job-http "foo"]
+		schedule = @every 10s
+		url = http://example.com
+		bearer-token = b34r3r
+		headers = X-Api-Key: 4p1k3y
+		slack-token = xoxb-123
+		slack-channel = ops
+		ping-url = https://hc-ping.com/p1ng
+
+		[job-local "bar"]
+		schedule = @every 10s
+		command = echo bar
+		environment = DB_PASSWORD=dbp4ss
+		environment = DB_HOST=postgres
+		environment = API_TOKEN=abc
+	`, nil)
+
+	c.Assert(err, IsNil)
+	c.Assert(string(sh.Redactor.Redact([]byte("s3cr3t b34r3r xoxb-123 token=foo"))), Equals, "*** *** *** token=***")
+	c.Assert(
+		string(sh.Redactor.Redact([]byte("https://hooks.example.com/h00k w3bh00k application/json 4p1k3y https://hc-ping.com/p1ng"))),
+		Equals, "*** *** application/json *** ***",
+	)
+
+	// the values shorter than 4 bytes are not redacted
+	c.Assert(string(sh.Redactor.Redact([]byte("dbp4ss postgres abc"))), Equals, "*** postgres abc")
+
+	_, err = BuildFromString(`
+		[global]
+		redact-patterns = (
+	`, nil)
+
+	c.Assert(err, ErrorMatches, `invalid redact-patterns: invalid pattern "\(": .*`)
+}
+
+func (s *SuiteConfig) TestBuildExecJobContainerAndSelector(c *C) {
+	_, err := BuildFromString(`
+		[job-exec "foo"]
+		schedule = @every 10s
+		command = echo foo
+		container = bar
+		container-selector = app=bar
+	`, nil)
+
+	c.Assert(err, ErrorMatches, `job "foo" can't set both container and container-selector`)
+}
+
+func (s *SuiteConfig) TestBuildExecJobExitCodesInvalid(c *C) {
+	_, err := BuildFromString(`
+		[job-exec "foo"]
+		schedule = @every 10s
+		command = echo foo
+		container = bar
+		success-exit-codes = 0,foo
+	`, nil)
+
+	c.Assert(err, ErrorMatches, `invalid success-exit-codes: invalid exit code "foo" of job "foo"`)
+}
+
+func (s *SuiteConfig) TestBuildFromStringScript(c *C) {
+	sh, err := BuildFromString(`
+[job-local "foo"]
+schedule = @every 10s
+script = "#!/bin/sh -e\n"\
+"echo foo\n"\
+"echo bar"
+`, nil)
 
 	c.Assert(err, IsNil)
-	c.Assert(sh.Jobs, HasLen, 5)
+	c.Assert(sh.Jobs, HasLen, 1)
+	c.Assert(sh.Jobs[0].(*LocalJobConfig).Script, Equals, "#!/bin/sh -e\necho foo\necho bar")
 }
 
 func (s *SuiteConfig) TestJobDefaultsSet(c *C) {
@@ -252,6 +617,153 @@ func (s *SuiteConfig) TestLabelsConfig(c *C) {
 			},
 			Comment: "Test run job with volumes",
 		},
+		{
+			Labels: map[string]map[string]string{
+				"some": {
+					requiredLabel: "true",
+					serviceLabel:  "true",
+					labelPrefix + "." + jobHTTP + ".job1.schedule": "schedule1",
+					labelPrefix + "." + jobHTTP + ".job1.url":      "http://localhost/",
+					labelPrefix + "." + jobHTTP + ".job1.headers":  `["X-Foo: bar", "X-Qux: baz"]`,
+				},
+			},
+			ExpectedConfig: Config{
+				HTTPJobs: map[string]*HTTPJobConfig{
+					"job1": {HTTPJob: core.HTTPJob{BareJob: core.BareJob{
+						Schedule: "schedule1",
+					},
+						URL:     "http://localhost/",
+						Headers: []string{"X-Foo: bar", "X-Qux: baz"},
+					},
+					},
+				},
+			},
+			Comment: "Test http job with headers",
+		},
+		{
+			Labels: map[string]map[string]string{
+				"some": {
+					requiredLabel: "true",
+					serviceLabel:  "true",
+					labelPrefix + "." + jobCompose + ".job1.schedule":    "schedule1",
+					labelPrefix + "." + jobCompose + ".job1.project":     "myapp",
+					labelPrefix + "." + jobCompose + ".job1.service":     "migrations",
+					labelPrefix + "." + jobCompose + ".job1.environment": `["FOO=bar"]`,
+				},
+			},
+			ExpectedConfig: Config{
+				ComposeJobs: map[string]*ComposeJobConfig{
+					"job1": {ComposeJob: core.ComposeJob{BareJob: core.BareJob{
+						Schedule: "schedule1",
+					},
+						Project:     "myapp",
+						Service:     "migrations",
+						Environment: []string{"FOO=bar"},
+					},
+					},
+				},
+			},
+			Comment: "Test compose job",
+		},
+		{
+			Labels: map[string]map[string]string{
+				"some": {
+					requiredLabel: "true",
+					serviceLabel:  "true",
+					labelPrefix + "." + jobContainer + ".job1.schedule":           "schedule1",
+					labelPrefix + "." + jobContainer + ".job1.action":             "signal",
+					labelPrefix + "." + jobContainer + ".job1.signal":             "SIGHUP",
+					labelPrefix + "." + jobContainer + ".job1.container-selector": `["app=nginx"]`,
+				},
+			},
+			ExpectedConfig: Config{
+				ContainerJobs: map[string]*ContainerJobConfig{
+					"job1": {ContainerJob: core.ContainerJob{BareJob: core.BareJob{
+						Schedule: "schedule1",
+					},
+						Action:            "signal",
+						Signal:            "SIGHUP",
+						ContainerSelector: []string{"app=nginx"},
+					},
+					},
+				},
+			},
+			Comment: "Test container job",
+		},
+		{
+			Labels: map[string]map[string]string{
+				"some": {
+					requiredLabel: "true",
+					serviceLabel:  "true",
+					labelPrefix + "." + jobPrune + ".job1.schedule":     "schedule1",
+					labelPrefix + "." + jobPrune + ".job1.containers":   "true",
+					labelPrefix + "." + jobPrune + ".job1.build-cache":  "true",
+					labelPrefix + "." + jobPrune + ".job1.label-filter": `["env=test", "keep!=true"]`,
+				},
+			},
+			ExpectedConfig: Config{
+				PruneJobs: map[string]*PruneJobConfig{
+					"job1": {PruneJob: core.PruneJob{BareJob: core.BareJob{
+						Schedule: "schedule1",
+					},
+						Containers:  true,
+						BuildCache:  true,
+						LabelFilter: []string{"env=test", "keep!=true"},
+					},
+					},
+				},
+			},
+			Comment: "Test prune job",
+		},
+		{
+			Labels: map[string]map[string]string{
+				"some": {
+					requiredLabel: "true",
+					serviceLabel:  "true",
+					labelPrefix + "." + jobVolumeBackup + ".job1.schedule":       "schedule1",
+					labelPrefix + "." + jobVolumeBackup + ".job1.volume":         `["data", "config"]`,
+					labelPrefix + "." + jobVolumeBackup + ".job1.target":         "/backups",
+					labelPrefix + "." + jobVolumeBackup + ".job1.pause-selector": `["backup=pause"]`,
+					labelPrefix + "." + jobVolumeBackup + ".job1.keep-daily":     "7",
+				},
+			},
+			ExpectedConfig: Config{
+				VolumeBackupJobs: map[string]*VolumeBackupJobConfig{
+					"job1": {VolumeBackupJob: core.VolumeBackupJob{BareJob: core.BareJob{
+						Schedule: "schedule1",
+					},
+						Volume:        []string{"data", "config"},
+						Target:        "/backups",
+						PauseSelector: []string{"backup=pause"},
+						KeepDaily:     7,
+					},
+					},
+				},
+			},
+			Comment: "Test volume backup job",
+		},
+		{
+			Labels: map[string]map[string]string{
+				"some": {
+					requiredLabel:                          "true",
+					serviceLabel:                           "true",
+					labelPrefix + ".webhook-url":           "http://localhost/",
+					labelPrefix + ".webhook-headers":       `["X-Foo: bar", "X-Qux: baz"]`,
+					labelPrefix + ".webhook-retries":       "3",
+					labelPrefix + ".webhook-only-on-error": "true",
+				},
+			},
+			ExpectedConfig: func() Config {
+				var c Config
+				c.Global.WebhookURL = "http://localhost/"
+				c.Global.WebhookHeaders = []string{"X-Foo: bar", "X-Qux: baz"}
+				c.Global.WebhookRetries = 3
+				onlyOnError := true
+				c.Global.WebhookOnlyOnError = &onlyOnError
+				return c
+			}(),
+			Comment: "Test global webhook",
+		},
 	}
 
 	for _, t := range testcases {
@@ -12,10 +12,17 @@ import (
 
 // DaemonCommand daemon process
 type DaemonCommand struct {
-	ConfigFile         string `long:"config" description:"configuration file" default:"/etc/ofelia.conf"`
-	DockerLabelsConfig bool   `short:"d" long:"docker" description:"read configurations from docker labels"`
+	ConfigFile         string        `long:"config" description:"configuration file" default:"/etc/ofelia.conf"`
+	DockerLabelsConfig bool          `short:"d" long:"docker" description:"read configurations from docker labels"`
+	LogFormat          string        `long:"log-format" description:"log format, text or json" default:"text"`
+	LogLevel           string        `long:"log-level" description:"log level: debug, notice, warning, error or critical" default:"debug"`
+	HTTPAddr           string        `long:"http-addr" description:"address of the HTTP API, e.g. 127.0.0.1:8081, disabled if empty"`
+	HTTPToken          string        `long:"http-token" env:"OFELIA_HTTP_TOKEN" description:"token required as a bearer token by the HTTP API"`
+	ShutdownTimeout    time.Duration `long:"shutdown-timeout" description:"time given to the running jobs to finish on shutdown, the local jobs get SIGTERM and are killed once it is over" default:"10s"`
 
 	config    *Config
+	logger    *Logger
+	server    *Server
 	scheduler *core.Scheduler
 	signals   chan os.Signal
 	done      chan bool
@@ -27,6 +34,19 @@ func (c *DaemonCommand) Execute(args []string) error {
 	_, err := os.Stat("/.dockerenv")
 	IsDockerEnv = !os.IsNotExist(err)
 
+	if c.logger, err = NewLogger(os.Stdout, c.LogFormat, c.LogLevel); err != nil {
+		return err
+	}
+
+	if c.HTTPAddr != "" {
+		c.server = NewServer(c.HTTPAddr, c.HTTPToken)
+		if err := c.server.Start(); err != nil {
+			return err
+		}
+
+		defer c.server.Close()
+	}
+
 	exit := false
 
 	for {
@@ -55,13 +75,17 @@ func (c *DaemonCommand) Execute(args []string) error {
 
 func (c *DaemonCommand) boot() (err error) {
 	if c.DockerLabelsConfig {
-		c.scheduler, err = BuildFromDockerLabels()
+		c.scheduler, err = BuildFromDockerLabels(c.logger)
 		c.setWaiter()
 		if err != nil {
 			return err
 		}
 	} else {
-		c.scheduler, err = BuildFromFile(c.ConfigFile)
+		c.scheduler, err = BuildFromFile(c.ConfigFile, c.logger)
+	}
+
+	if err == nil && c.server != nil {
+		c.server.SetScheduler(c.scheduler)
 	}
 
 	return
@@ -139,6 +163,17 @@ func (c *DaemonCommand) shutdown() (bool, error) {
 		}
 	}
 
+	if needExit {
+		if c.scheduler.IsRunning() {
+			c.scheduler.Logger.Warningf("Waiting running jobs.")
+			if err := c.scheduler.Shutdown(c.ShutdownTimeout); err != nil {
+				return needExit, err
+			}
+		}
+
+		return needExit, closeStores()
+	}
+
 	if !c.scheduler.IsRunning() {
 		return needExit, nil
 	}
@@ -59,6 +59,11 @@ func (c *Config) buildFromDockerLabels(labels map[string]map[string]string) erro
 	localJobs := make(map[string]map[string]interface{})
 	runJobs := make(map[string]map[string]interface{})
 	serviceJobs := make(map[string]map[string]interface{})
+	httpJobs := make(map[string]map[string]interface{})
+	composeJobs := make(map[string]map[string]interface{})
+	containerJobs := make(map[string]map[string]interface{})
+	pruneJobs := make(map[string]map[string]interface{})
+	volumeBackupJobs := make(map[string]map[string]interface{})
 	globalConfigs := make(map[string]interface{})
 
 	for c, l := range labels {
@@ -75,7 +80,7 @@ func (c *Config) buildFromDockerLabels(labels map[string]map[string]string) erro
 			parts := strings.Split(k, ".")
 			if len(parts) < 4 {
 				if isServiceContainer {
-					globalConfigs[parts[1]] = v
+					setJobParam(globalConfigs, parts[1], v)
 				}
 
 				continue
@@ -109,6 +114,31 @@ func (c *Config) buildFromDockerLabels(labels map[string]map[string]string) erro
 					runJobs[jobName] = make(map[string]interface{})
 				}
 				setJobParam(runJobs[jobName], jopParam, v)
+			case jobType == jobHTTP && isServiceContainer:
+				if _, ok := httpJobs[jobName]; !ok {
+					httpJobs[jobName] = make(map[string]interface{})
+				}
+				setJobParam(httpJobs[jobName], jopParam, v)
+			case jobType == jobCompose && isServiceContainer:
+				if _, ok := composeJobs[jobName]; !ok {
+					composeJobs[jobName] = make(map[string]interface{})
+				}
+				setJobParam(composeJobs[jobName], jopParam, v)
+			case jobType == jobContainer && isServiceContainer:
+				if _, ok := containerJobs[jobName]; !ok {
+					containerJobs[jobName] = make(map[string]interface{})
+				}
+				setJobParam(containerJobs[jobName], jopParam, v)
+			case jobType == jobPrune && isServiceContainer:
+				if _, ok := pruneJobs[jobName]; !ok {
+					pruneJobs[jobName] = make(map[string]interface{})
+				}
+				setJobParam(pruneJobs[jobName], jopParam, v)
+			case jobType == jobVolumeBackup && isServiceContainer:
+				if _, ok := volumeBackupJobs[jobName]; !ok {
+					volumeBackupJobs[jobName] = make(map[string]interface{})
+				}
+				setJobParam(volumeBackupJobs[jobName], jopParam, v)
 			default:
 				// TODO: warn about unknown parameter
 			}
@@ -145,13 +175,43 @@ func (c *Config) buildFromDockerLabels(labels map[string]map[string]string) erro
 		}
 	}
 
+	if len(httpJobs) > 0 {
+		if err := mapstructure.WeakDecode(httpJobs, &c.HTTPJobs); err != nil {
+			return err
+		}
+	}
+
+	if len(composeJobs) > 0 {
+		if err := mapstructure.WeakDecode(composeJobs, &c.ComposeJobs); err != nil {
+			return err
+		}
+	}
+
+	if len(containerJobs) > 0 {
+		if err := mapstructure.WeakDecode(containerJobs, &c.ContainerJobs); err != nil {
+			return err
+		}
+	}
+
+	if len(pruneJobs) > 0 {
+		if err := mapstructure.WeakDecode(pruneJobs, &c.PruneJobs); err != nil {
+			return err
+		}
+	}
+
+	if len(volumeBackupJobs) > 0 {
+		if err := mapstructure.WeakDecode(volumeBackupJobs, &c.VolumeBackupJobs); err != nil {
+			return err
+		}
+	}
+
 	return nil
 }
 
 func setJobParam(params map[string]interface{}, paramName, paramVal string) {
 	switch paramName {
-	case "volume":
-		arr := []string{} // allow providing JSON arr of volume mounts
+	case "volume", "environment", "secret", "config", "constraint", "container-selector", "headers", "label-filter", "pause-selector", "stop-selector", "slack-mentions", "webhook-headers", "redact-patterns":
+		arr := []string{} // allow providing JSON arr of list values
 		if err := json.Unmarshal([]byte(paramVal), &arr); err == nil {
 			params[paramName] = arr
 			return
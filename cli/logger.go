@@ -0,0 +1,182 @@
+package cli
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	logging "github.com/op/go-logging"
+	"github.com/vigasin/ofelia/core"
+)
+
+// Log formats supported by the daemon
+const (
+	LogFormatText = "text"
+	LogFormatJSON = "json"
+)
+
+// Logger is the logger of the daemon, writing the lines as text, with the
+// fields appended, or as JSON objects, one per line
+type Logger struct {
+	format string
+	level  logging.Level
+	fields core.Fields
+
+	out  io.Writer
+	mu   *sync.Mutex
+	text *logging.Logger
+}
+
+// NewLogger returns a Logger writing to out in the given format, discarding
+// the lines below the given level
+func NewLogger(out io.Writer, format, level string) (*Logger, error) {
+	lvl, err := logging.LogLevel(level)
+	if err != nil {
+		return nil, fmt.Errorf("invalid log level %q", level)
+	}
+
+	l := &Logger{format: format, level: lvl, out: out, mu: &sync.Mutex{}}
+	switch format {
+	case LogFormatText:
+		backend := logging.NewBackendFormatter(
+			logging.NewLogBackend(out, "", 0),
+			logging.MustStringFormatter(logFormat),
+		)
+
+		leveled := logging.AddModuleLevel(backend)
+		leveled.SetLevel(logging.DEBUG, "")
+
+		// the calls to Logger are skipped, so the caller is reported
+		l.text = &logging.Logger{Module: "ofelia", ExtraCalldepth: 2}
+		l.text.SetBackend(leveled)
+	case LogFormatJSON:
+	default:
+		return nil, fmt.Errorf("invalid log format %q", format)
+	}
+
+	return l, nil
+}
+
+// WithFields returns a logger adding the given fields to every line
+func (l *Logger) WithFields(fields core.Fields) core.FieldLogger {
+	c := *l
+	c.fields = make(core.Fields, len(l.fields)+len(fields))
+	for k, v := range l.fields {
+		c.fields[k] = v
+	}
+
+	for k, v := range fields {
+		c.fields[k] = v
+	}
+
+	return &c
+}
+
+// WithLevel returns a logger discarding the lines below the given level
+func (l *Logger) WithLevel(level string) core.FieldLogger {
+	lvl, err := logging.LogLevel(level)
+	if err != nil {
+		return l
+	}
+
+	c := *l
+	c.level = lvl
+	return &c
+}
+
+func (l *Logger) Criticalf(format string, args ...interface{}) {
+	l.log(logging.CRITICAL, format, args...)
+}
+
+func (l *Logger) Debugf(format string, args ...interface{}) {
+	l.log(logging.DEBUG, format, args...)
+}
+
+func (l *Logger) Errorf(format string, args ...interface{}) {
+	l.log(logging.ERROR, format, args...)
+}
+
+func (l *Logger) Noticef(format string, args ...interface{}) {
+	l.log(logging.NOTICE, format, args...)
+}
+
+func (l *Logger) Warningf(format string, args ...interface{}) {
+	l.log(logging.WARNING, format, args...)
+}
+
+func (l *Logger) log(lvl logging.Level, format string, args ...interface{}) {
+	if lvl > l.level {
+		return
+	}
+
+	msg := fmt.Sprintf(format, args...)
+	if l.format == LogFormatJSON {
+		l.writeJSON(lvl, msg)
+		return
+	}
+
+	msg += l.textFields()
+	switch lvl {
+	case logging.CRITICAL:
+		l.text.Critical(msg)
+	case logging.ERROR:
+		l.text.Error(msg)
+	case logging.WARNING:
+		l.text.Warning(msg)
+	case logging.NOTICE:
+		l.text.Notice(msg)
+	default:
+		l.text.Debug(msg)
+	}
+}
+
+// textFields returns the fields as " key=value", sorted by key
+func (l *Logger) textFields() string {
+	keys := make([]string, 0, len(l.fields))
+	for k := range l.fields {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+
+	var b strings.Builder
+	for _, k := range keys {
+		v := fmt.Sprint(l.fields[k])
+		if v == "" || strings.ContainsAny(v, " \t\n\"=") {
+			v = strconv.Quote(v)
+		}
+
+		fmt.Fprintf(&b, " %s=%s", k, v)
+	}
+
+	return b.String()
+}
+
+func (l *Logger) writeJSON(lvl logging.Level, msg string) {
+	line := make(map[string]interface{}, len(l.fields)+3)
+	for k, v := range l.fields {
+		line[k] = v
+	}
+
+	line["time"] = time.Now().Format(time.RFC3339Nano)
+	line["level"] = strings.ToLower(lvl.String())
+	line["message"] = msg
+
+	b, err := json.Marshal(line)
+	if err != nil {
+		b, _ = json.Marshal(map[string]interface{}{
+			"time":    line["time"],
+			"level":   line["level"],
+			"message": msg,
+		})
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.out.Write(append(b, '\n'))
+}
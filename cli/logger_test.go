@@ -0,0 +1,63 @@
+package cli
+
+import (
+	"bytes"
+	"encoding/json"
+
+	"github.com/vigasin/ofelia/core"
+	. "gopkg.in/check.v1"
+)
+
+type SuiteLogger struct{}
+
+var _ = Suite(&SuiteLogger{})
+
+func (s *SuiteLogger) TestText(c *C) {
+	buf := bytes.NewBuffer(nil)
+	l, err := NewLogger(buf, LogFormatText, "notice")
+	c.Assert(err, IsNil)
+
+	l.Debugf("foo")
+	l.WithFields(core.Fields{"job": "foo", "status": "failed job"}).Noticef("bar %d", 42)
+
+	c.Assert(buf.String(), Matches, `(?s).*NOTICE.* bar 42 job=foo status="failed job"\n`)
+}
+
+func (s *SuiteLogger) TestJSON(c *C) {
+	buf := bytes.NewBuffer(nil)
+	l, err := NewLogger(buf, LogFormatJSON, "warning")
+	c.Assert(err, IsNil)
+
+	jl := l.WithFields(core.Fields{"job": "foo", "exit_code": 1})
+	jl.Noticef("skipped")
+	jl.WithLevel("debug").Debugf("bar")
+	jl.WithLevel("foo").Debugf("skipped")
+	l.Errorf("baz")
+
+	dec := json.NewDecoder(buf)
+
+	var line map[string]interface{}
+	c.Assert(dec.Decode(&line), IsNil)
+	c.Assert(line["time"], NotNil)
+	delete(line, "time")
+	c.Assert(line, DeepEquals, map[string]interface{}{
+		"level":     "debug",
+		"message":   "bar",
+		"job":       "foo",
+		"exit_code": float64(1),
+	})
+
+	line = nil
+	c.Assert(dec.Decode(&line), IsNil)
+	c.Assert(line["message"], Equals, "baz")
+	c.Assert(line["job"], IsNil)
+	c.Assert(dec.More(), Equals, false)
+}
+
+func (s *SuiteLogger) TestInvalid(c *C) {
+	_, err := NewLogger(nil, "xml", "debug")
+	c.Assert(err, ErrorMatches, `invalid log format "xml"`)
+
+	_, err = NewLogger(nil, LogFormatText, "foo")
+	c.Assert(err, ErrorMatches, `invalid log level "foo"`)
+}
@@ -0,0 +1,82 @@
+package cli
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+
+	"github.com/vigasin/ofelia/core"
+	gcfg "gopkg.in/gcfg.v1"
+)
+
+// RunCommand runs a job once, now, printing its output while it runs
+type RunCommand struct {
+	ConfigFile         string `long:"config" description:"configuration file" default:"/etc/ofelia.conf"`
+	DockerLabelsConfig bool   `short:"d" long:"docker" description:"read configurations from docker labels"`
+	LogFormat          string `long:"log-format" description:"log format, text or json" default:"text"`
+	LogLevel           string `long:"log-level" description:"log level: debug, notice, warning, error or critical" default:"warning"`
+
+	Args struct {
+		Job string `positional-arg-name:"job" description:"name of the job"`
+	} `positional-args:"yes" required:"yes"`
+
+	stdout, stderr io.Writer
+}
+
+// Execute runs the job, failing if the execution fails
+func (c *RunCommand) Execute(args []string) error {
+	if c.stdout == nil {
+		c.stdout, c.stderr = os.Stdout, os.Stderr
+	}
+
+	logger, err := NewLogger(c.stderr, c.LogFormat, c.LogLevel)
+	if err != nil {
+		return err
+	}
+
+	sh, err := c.build(logger)
+	if err != nil {
+		return err
+	}
+
+	e := core.NewExecution()
+
+	var wg sync.WaitGroup
+	follow := func(w io.Writer, s *core.Stream) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			io.Copy(w, s.Follow())
+		}()
+	}
+
+	follow(c.stdout, e.OutputStream)
+	follow(c.stderr, e.ErrorStream)
+
+	if err := sh.RunJob(c.Args.Job, e); err != nil {
+		return err
+	}
+
+	wg.Wait()
+	if e.Failed {
+		return fmt.Errorf("job %q failed: %s", c.Args.Job, e.Error)
+	}
+
+	return nil
+}
+
+func (c *RunCommand) build(logger core.Logger) (*core.Scheduler, error) {
+	conf := &Config{logger: logger}
+	if c.DockerLabelsConfig {
+		if err := conf.readDockerLabels(); err != nil {
+			return nil, err
+		}
+	} else if err := gcfg.ReadFileInto(conf, c.ConfigFile); err != nil {
+		return nil, err
+	}
+
+	// the store is locked by the daemon, the history is only recorded by it
+	conf.Global.StorePath = ""
+	return conf.build()
+}
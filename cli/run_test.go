@@ -0,0 +1,66 @@
+package cli
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"sync"
+
+	. "gopkg.in/check.v1"
+)
+
+type SuiteRun struct{}
+
+var _ = Suite(&SuiteRun{})
+
+func (s *SuiteRun) TestExecute(c *C) {
+	f, err := ioutil.TempFile("", "ofelia.conf")
+	c.Assert(err, IsNil)
+	defer os.Remove(f.Name())
+
+	f.WriteString(`
+		[job-local "foo"]
+		schedule = @hourly
+		shell = /bin/sh
+		command = echo foo && echo bar >&2
+
+		[job-local "bar"]
+		schedule = @hourly
+		command = false
+	`)
+	f.Close()
+
+	stdout, stderr := &syncBuffer{}, &syncBuffer{}
+	cmd := &RunCommand{ConfigFile: f.Name(), LogFormat: LogFormatText, LogLevel: "warning", stdout: stdout, stderr: stderr}
+	cmd.Args.Job = "foo"
+
+	c.Assert(cmd.Execute(nil), IsNil)
+	c.Assert(stdout.String(), Equals, "foo\n")
+	c.Assert(stderr.String(), Equals, "bar\n")
+
+	cmd.Args.Job = "bar"
+	c.Assert(cmd.Execute(nil), ErrorMatches, `job "bar" failed: .*`)
+
+	cmd.Args.Job = "qux"
+	c.Assert(cmd.Execute(nil), ErrorMatches, `unknown job "qux"`)
+}
+
+// syncBuffer is a buffer written by the job and the logger at the same time
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.buf.String()
+}
@@ -0,0 +1,241 @@
+package cli
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/vigasin/ofelia/core"
+)
+
+// Server is the HTTP API of the daemon, serving the output of the executions:
+//
+//	GET /jobs/{name}/executions/{id}/logs[?follow=1]
+//
+// The output is sent as plain text, or as server-sent events if requested by
+// the Accept header, with a stdout or stderr event per line, an error event if
+// an output can't be read, and an end event
+// with the status of the execution. The requests must carry the token, if any,
+// in an "Authorization: Bearer <token>" header.
+type Server struct {
+	mu        sync.RWMutex
+	scheduler *core.Scheduler
+	srv       *http.Server
+	token     string
+}
+
+// NewServer returns a Server listening on the given address once started,
+// requiring the given token if not empty
+func NewServer(addr, token string) *Server {
+	s := &Server{token: token}
+	s.srv = &http.Server{Addr: addr, Handler: s}
+	return s
+}
+
+// SetScheduler sets the scheduler of the executions served, replaced when the
+// configuration is reloaded
+func (s *Server) SetScheduler(sh *core.Scheduler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.scheduler = sh
+}
+
+// Start listens and serves the requests in the background
+func (s *Server) Start() error {
+	l, err := net.Listen("tcp", s.srv.Addr)
+	if err != nil {
+		return fmt.Errorf("error listening on %q: %s", s.srv.Addr, err)
+	}
+
+	go s.srv.Serve(l)
+	return nil
+}
+
+// Close stops listening and closes the connections
+func (s *Server) Close() error {
+	return s.srv.Close()
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !s.authorized(r) {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	parts := strings.Split(strings.Trim(r.URL.EscapedPath(), "/"), "/")
+	if len(parts) != 5 || parts[0] != "jobs" || parts[2] != "executions" || parts[4] != "logs" {
+		http.NotFound(w, r)
+		return
+	}
+
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	job, err := url.PathUnescape(parts[1])
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s.serveLogs(w, r, job, parts[3])
+}
+
+func (s *Server) authorized(r *http.Request) bool {
+	if s.token == "" {
+		return true
+	}
+
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return subtle.ConstantTimeCompare([]byte(token), []byte(s.token)) == 1
+}
+
+// logLine is a line of an output, the stream is stdout or stderr, or error if
+// an output couldn't be read
+type logLine struct {
+	stream string
+	text   string
+}
+
+func (s *Server) serveLogs(w http.ResponseWriter, r *http.Request, job, id string) {
+	s.mu.RLock()
+	sh := s.scheduler
+	s.mu.RUnlock()
+
+	if sh == nil {
+		http.Error(w, "scheduler not ready", http.StatusServiceUnavailable)
+		return
+	}
+
+	follow := r.URL.Query().Get("follow")
+	outputs, status, err := executionOutputs(sh, job, id, follow == "1" || follow == "true")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if outputs == nil {
+		http.Error(w, fmt.Sprintf("execution %q of job %q not found", id, job), http.StatusNotFound)
+		return
+	}
+
+	sse := strings.Contains(r.Header.Get("Accept"), "text/event-stream")
+	if sse {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+	} else {
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	}
+
+	flusher, _ := w.(http.Flusher)
+	for l := range readLines(r, outputs) {
+		if sse {
+			fmt.Fprintf(w, "event: %s\ndata: %s\n\n", l.stream, l.text)
+		} else {
+			fmt.Fprintf(w, "%s\n", l.text)
+		}
+
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+
+	if sse && r.Context().Err() == nil {
+		fmt.Fprintf(w, "event: end\ndata: %s\n\n", status())
+	}
+}
+
+// executionOutputs returns the readers of the outputs of an execution, and a
+// function returning its status once they are read. The running executions
+// are followed if requested, the finished ones are read from the store.
+func executionOutputs(sh *core.Scheduler, job, id string, follow bool) (map[string]io.Reader, func() string, error) {
+	if e := sh.RunningExecution(job, id); e != nil {
+		if follow {
+			return map[string]io.Reader{
+				"stdout": e.OutputStream.Follow(),
+				"stderr": e.ErrorStream.Follow(),
+			}, e.Status, nil
+		}
+
+		return map[string]io.Reader{
+			"stdout": strings.NewReader(e.OutputStream.String()),
+			"stderr": strings.NewReader(e.ErrorStream.String()),
+		}, func() string { return "running" }, nil
+	}
+
+	if sh.Store == nil {
+		return nil, nil, nil
+	}
+
+	records, err := sh.Store.Query(core.ExecutionQuery{Job: job})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	for _, record := range records {
+		if record.ID == id {
+			return map[string]io.Reader{
+				"stdout": strings.NewReader(record.Output),
+				"stderr": strings.NewReader(record.Stderr),
+			}, func() string { return record.Status }, nil
+		}
+	}
+
+	return nil, nil, nil
+}
+
+// readLines reads the lines of the outputs as they are available, until all
+// of them are read or the request is canceled
+func readLines(r *http.Request, outputs map[string]io.Reader) <-chan logLine {
+	lines := make(chan logLine)
+
+	var wg sync.WaitGroup
+	for stream, output := range outputs {
+		wg.Add(1)
+		go func(stream string, output io.Reader) {
+			defer wg.Done()
+
+			scanner := bufio.NewScanner(output)
+			scanner.Buffer(nil, 1024*1024)
+			for scanner.Scan() {
+				select {
+				case lines <- logLine{stream, scanner.Text()}:
+				case <-r.Context().Done():
+					return
+				}
+			}
+
+			if err := scanner.Err(); err != nil && r.Context().Err() == nil {
+				select {
+				case lines <- logLine{"error", fmt.Sprintf("error reading %s: %s", stream, err)}:
+				case <-r.Context().Done():
+				}
+			}
+		}(stream, output)
+	}
+
+	go func() {
+		<-r.Context().Done()
+		for _, output := range outputs {
+			if c, ok := output.(io.Closer); ok {
+				c.Close()
+			}
+		}
+	}()
+
+	go func() {
+		wg.Wait()
+		close(lines)
+	}()
+
+	return lines
+}
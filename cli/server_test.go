@@ -0,0 +1,138 @@
+package cli
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/vigasin/ofelia/core"
+	. "gopkg.in/check.v1"
+)
+
+type SuiteServer struct {
+	scheduler *core.Scheduler
+	server    *httptest.Server
+	dir       string
+}
+
+var _ = Suite(&SuiteServer{})
+
+func (s *SuiteServer) SetUpTest(c *C) {
+	var err error
+	s.dir, err = ioutil.TempDir("", "server")
+	c.Assert(err, IsNil)
+
+	s.scheduler, err = BuildFromString(`
+		[global]
+		store-path = `+filepath.Join(s.dir, "ofelia.db")+`
+
+		[job-local "foo"]
+		schedule = @hourly
+		shell = /bin/sh
+		command = echo foo && sleep 0.2 && echo bar >&2 && echo baz
+	`, nil)
+	c.Assert(err, IsNil)
+
+	srv := NewServer("", "")
+	srv.SetScheduler(s.scheduler)
+	s.server = httptest.NewServer(srv)
+}
+
+func (s *SuiteServer) TearDownTest(c *C) {
+	s.server.Close()
+	s.scheduler.Store.Close()
+	delete(stores, filepath.Join(s.dir, "ofelia.db"))
+	os.RemoveAll(s.dir)
+}
+
+func (s *SuiteServer) get(c *C, path string, sse bool) (int, string) {
+	req, err := http.NewRequest(http.MethodGet, s.server.URL+path, nil)
+	c.Assert(err, IsNil)
+	if sse {
+		req.Header.Set("Accept", "text/event-stream")
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	c.Assert(err, IsNil)
+	defer resp.Body.Close()
+
+	b, err := ioutil.ReadAll(resp.Body)
+	c.Assert(err, IsNil)
+	return resp.StatusCode, string(b)
+}
+
+func (s *SuiteServer) TestFollow(c *C) {
+	e := core.NewExecution()
+	done := make(chan struct{})
+	go func() {
+		c.Check(s.scheduler.RunJob("foo", e), IsNil)
+		close(done)
+	}()
+
+	for s.scheduler.RunningExecution("foo", e.ID) == nil {
+		time.Sleep(time.Millisecond)
+	}
+
+	code, body := s.get(c, "/jobs/foo/executions/"+e.ID+"/logs?follow=1", true)
+	c.Assert(code, Equals, http.StatusOK)
+	c.Assert(body, Matches, `(?s)event: stdout\ndata: foo\n\n.*event: stderr\ndata: bar\n\n.*event: end\ndata: successful\n\n`)
+	c.Assert(body, Matches, `(?s).*event: stdout\ndata: baz\n\n.*`)
+	<-done
+
+	code, body = s.get(c, "/jobs/foo/executions/"+e.ID+"/logs", false)
+	c.Assert(code, Equals, http.StatusOK)
+	lines := strings.Split(strings.TrimSpace(body), "\n")
+	sort.Strings(lines)
+	c.Assert(lines, DeepEquals, []string{"bar", "baz", "foo"})
+}
+
+func (s *SuiteServer) TestNotFound(c *C) {
+	code, _ := s.get(c, "/jobs/foo/executions/bar/logs", false)
+	c.Assert(code, Equals, http.StatusNotFound)
+
+	code, _ = s.get(c, "/foo", false)
+	c.Assert(code, Equals, http.StatusNotFound)
+}
+
+func (s *SuiteServer) TestToken(c *C) {
+	srv := NewServer("", "foo")
+	srv.SetScheduler(s.scheduler)
+
+	r := httptest.NewRequest(http.MethodGet, "/jobs/foo/executions/bar/logs", nil)
+	w := httptest.NewRecorder()
+	srv.ServeHTTP(w, r)
+	c.Assert(w.Code, Equals, http.StatusUnauthorized)
+
+	r.Header.Set("Authorization", "Bearer bar")
+	w = httptest.NewRecorder()
+	srv.ServeHTTP(w, r)
+	c.Assert(w.Code, Equals, http.StatusUnauthorized)
+
+	r.Header.Set("Authorization", "Bearer foo")
+	w = httptest.NewRecorder()
+	srv.ServeHTTP(w, r)
+	c.Assert(w.Code, Equals, http.StatusNotFound)
+}
+
+func (s *SuiteServer) TestReadLinesError(c *C) {
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	outputs := map[string]io.Reader{
+		"stdout": strings.NewReader("foo\n" + strings.Repeat("x", 2*1024*1024)),
+	}
+
+	var lines []logLine
+	for l := range readLines(r, outputs) {
+		lines = append(lines, l)
+	}
+
+	c.Assert(lines, DeepEquals, []logLine{
+		{"stdout", "foo"},
+		{"error", "error reading stdout: bufio.Scanner: token too long"},
+	})
+}
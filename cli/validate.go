@@ -1,6 +1,11 @@
 package cli
 
-import "fmt"
+import (
+	"fmt"
+
+	"github.com/vigasin/ofelia/core"
+	gcfg "gopkg.in/gcfg.v1"
+)
 
 // ValidateCommand validates the config file
 type ValidateCommand struct {
@@ -10,7 +15,7 @@ type ValidateCommand struct {
 // Execute runs the validation command
 func (c *ValidateCommand) Execute(args []string) error {
 	fmt.Printf("Validating %q ... ", c.ConfigFile)
-	config, err := BuildFromFile(c.ConfigFile)
+	config, err := c.build()
 	if err != nil {
 		fmt.Println("ERROR")
 		return err
@@ -28,3 +33,19 @@ func (c *ValidateCommand) Execute(args []string) error {
 
 	return nil
 }
+
+// build builds the scheduler without opening the store, it may be locked by
+// the daemon and must not be pruned by a validation
+func (c *ValidateCommand) build() (*core.Scheduler, error) {
+	conf := &Config{}
+	if err := gcfg.ReadFileInto(conf, c.ConfigFile); err != nil {
+		return nil, err
+	}
+
+	if _, err := core.ParseAge(conf.Global.StoreMaxAge); err != nil {
+		return nil, fmt.Errorf("invalid store-max-age %q: %s", conf.Global.StoreMaxAge, err)
+	}
+
+	conf.Global.StorePath = ""
+	return conf.build()
+}
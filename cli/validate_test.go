@@ -0,0 +1,44 @@
+package cli
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	. "gopkg.in/check.v1"
+)
+
+type SuiteValidate struct{}
+
+var _ = Suite(&SuiteValidate{})
+
+func (s *SuiteValidate) TestExecuteWithoutStore(c *C) {
+	dir, err := ioutil.TempDir("", "validate")
+	c.Assert(err, IsNil)
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "ofelia.conf")
+	store := filepath.Join(dir, "ofelia.db")
+	c.Assert(ioutil.WriteFile(file, []byte(`
+		[global]
+		store-path = `+store+`
+
+		[job-local "foo"]
+		schedule = @hourly
+		command = echo foo
+	`), 0644), IsNil)
+
+	cmd := &ValidateCommand{ConfigFile: file}
+	c.Assert(cmd.Execute(nil), IsNil)
+
+	_, err = os.Stat(store)
+	c.Assert(os.IsNotExist(err), Equals, true)
+
+	c.Assert(ioutil.WriteFile(file, []byte(`
+		[global]
+		store-path = `+store+`
+		store-max-age = foo
+	`), 0644), IsNil)
+
+	c.Assert(cmd.Execute(nil), ErrorMatches, `invalid store-max-age "foo": .*`)
+}
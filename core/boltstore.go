@@ -0,0 +1,251 @@
+package core
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	boltMetaBucket       = []byte("meta")
+	boltExecutionsBucket = []byte("executions")
+	boltVersionKey       = []byte("version")
+
+	// boltOpenTimeout is the time waited for the lock of the file, held by
+	// another process using the store
+	boltOpenTimeout = 5 * time.Second
+)
+
+// boltMigrations upgrade the schema of the store, the migration i upgrades it
+// from the version i to i+1. New versions are added at the end, the existing
+// ones are never modified.
+var boltMigrations = []func(*bolt.Tx) error{
+	func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(boltExecutionsBucket)
+		return err
+	},
+}
+
+// BoltStore is an ExecutionStore saving the executions in a bbolt database,
+// a single file, with a bucket per job where the executions are sorted by date
+type BoltStore struct {
+	// MaxOutput is the number of bytes of each output kept, all of it if zero
+	MaxOutput int
+	// Retention is applied to the job after every execution saved
+	Retention RetentionPolicy
+
+	db *bolt.DB
+}
+
+// NewBoltStore opens the store at the given path, creating or migrating it if
+// needed
+func NewBoltStore(path string) (*BoltStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: boltOpenTimeout})
+	if err != nil {
+		return nil, fmt.Errorf("error opening store %q: %s", path, err)
+	}
+
+	s := &BoltStore{db: db}
+	if err := s.migrate(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("error migrating store %q: %s", path, err)
+	}
+
+	return s, nil
+}
+
+func (s *BoltStore) migrate() error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		meta, err := tx.CreateBucketIfNotExists(boltMetaBucket)
+		if err != nil {
+			return err
+		}
+
+		version := 0
+		if v := meta.Get(boltVersionKey); v != nil {
+			if version, err = strconv.Atoi(string(v)); err != nil {
+				return fmt.Errorf("invalid version %q", v)
+			}
+		}
+
+		if version > len(boltMigrations) {
+			return fmt.Errorf("version %d is newer than the supported one, %d", version, len(boltMigrations))
+		}
+
+		for ; version < len(boltMigrations); version++ {
+			if err := boltMigrations[version](tx); err != nil {
+				return err
+			}
+		}
+
+		return meta.Put(boltVersionKey, []byte(strconv.Itoa(version)))
+	})
+}
+
+// Save records the execution, truncating its output to MaxOutput and applying
+// the retention policy to the job
+func (s *BoltStore) Save(r *ExecutionRecord) error {
+	record := *r
+	if s.MaxOutput > 0 {
+		record.Truncate(s.MaxOutput)
+	}
+
+	value, err := json.Marshal(&record)
+	if err != nil {
+		return err
+	}
+
+	return s.db.Update(func(tx *bolt.Tx) error {
+		b, err := tx.Bucket(boltExecutionsBucket).CreateBucketIfNotExists([]byte(record.Job))
+		if err != nil {
+			return err
+		}
+
+		if err := b.Put(boltKey(record.Date, record.ID), value); err != nil {
+			return err
+		}
+
+		_, err = boltPrune(b, s.Retention, time.Now())
+		return err
+	})
+}
+
+// Query returns the executions matching the query, newest first
+func (s *BoltStore) Query(q ExecutionQuery) ([]*ExecutionRecord, error) {
+	var records []*ExecutionRecord
+	err := s.db.View(func(tx *bolt.Tx) error {
+		executions := tx.Bucket(boltExecutionsBucket)
+		if q.Job != "" {
+			b := executions.Bucket([]byte(q.Job))
+			if b == nil {
+				return nil
+			}
+
+			var err error
+			records, err = boltQuery(b, &q)
+			return err
+		}
+
+		return executions.ForEach(func(job, _ []byte) error {
+			found, err := boltQuery(executions.Bucket(job), &q)
+			records = append(records, found...)
+			return err
+		})
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	sort.SliceStable(records, func(i, j int) bool {
+		return records[i].Date.After(records[j].Date)
+	})
+
+	if q.Limit > 0 && len(records) > q.Limit {
+		records = records[:q.Limit]
+	}
+
+	return records, nil
+}
+
+// Prune deletes the executions of every job not kept by the policy
+func (s *BoltStore) Prune(p RetentionPolicy) (int, error) {
+	var deleted int
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		executions := tx.Bucket(boltExecutionsBucket)
+		return executions.ForEach(func(job, _ []byte) error {
+			n, err := boltPrune(executions.Bucket(job), p, time.Now())
+			deleted += n
+			return err
+		})
+	})
+
+	return deleted, err
+}
+
+// Close closes the database, releasing its file
+func (s *BoltStore) Close() error {
+	return s.db.Close()
+}
+
+// boltKey returns the key of an execution, sorted by date
+func boltKey(date time.Time, id string) []byte {
+	key := make([]byte, 8, 8+len(id))
+	binary.BigEndian.PutUint64(key, uint64(date.UnixNano()))
+
+	return append(key, id...)
+}
+
+func boltKeyDate(key []byte) time.Time {
+	return time.Unix(0, int64(binary.BigEndian.Uint64(key[:8])))
+}
+
+// boltQuery returns the executions of a job matching the query, newest first,
+// skipping the ones out of the time range without decoding them
+func boltQuery(b *bolt.Bucket, q *ExecutionQuery) ([]*ExecutionRecord, error) {
+	var records []*ExecutionRecord
+
+	c := b.Cursor()
+	for k, v := c.Last(); k != nil; k, v = c.Prev() {
+		date := boltKeyDate(k)
+		if !q.Until.IsZero() && !date.Before(q.Until) {
+			continue
+		}
+
+		if !q.Since.IsZero() && date.Before(q.Since) {
+			break
+		}
+
+		r := &ExecutionRecord{}
+		if err := json.Unmarshal(v, r); err != nil {
+			return nil, err
+		}
+
+		if !q.Match(r) {
+			continue
+		}
+
+		records = append(records, r)
+		if q.Limit > 0 && len(records) >= q.Limit {
+			break
+		}
+	}
+
+	return records, nil
+}
+
+// boltPrune deletes the executions of a job not kept by the policy
+func boltPrune(b *bolt.Bucket, p RetentionPolicy, now time.Time) (int, error) {
+	if p.IsZero() {
+		return 0, nil
+	}
+
+	var expired [][]byte
+
+	c := b.Cursor()
+	count := 0
+	for k, _ := c.Last(); k != nil; k, _ = c.Prev() {
+		count++
+
+		tooMany := p.MaxExecutions > 0 && count > p.MaxExecutions
+		tooOld := p.MaxAge > 0 && now.Sub(boltKeyDate(k)) > p.MaxAge
+		if tooMany || tooOld {
+			expired = append(expired, append([]byte(nil), k...))
+		}
+	}
+
+	// the keys are deleted once the iteration is done, deleting while
+	// iterating skips keys
+	for _, k := range expired {
+		if err := b.Delete(k); err != nil {
+			return 0, err
+		}
+	}
+
+	return len(expired), nil
+}
@@ -0,0 +1,170 @@
+package core
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+	. "gopkg.in/check.v1"
+)
+
+type SuiteBoltStore struct {
+	dir   string
+	store *BoltStore
+	now   time.Time
+}
+
+var _ = Suite(&SuiteBoltStore{})
+
+func (s *SuiteBoltStore) SetUpTest(c *C) {
+	var err error
+	s.dir, err = ioutil.TempDir("", "store")
+	c.Assert(err, IsNil)
+
+	s.store, err = NewBoltStore(filepath.Join(s.dir, "ofelia.db"))
+	c.Assert(err, IsNil)
+
+	s.now = time.Now().Truncate(time.Second)
+}
+
+func (s *SuiteBoltStore) TearDownTest(c *C) {
+	s.store.Close()
+	os.RemoveAll(s.dir)
+}
+
+func (s *SuiteBoltStore) TestSaveQuery(c *C) {
+	s.save(c, "foo", "1", -3*time.Hour, ExecutionStatusSuccessful)
+	s.save(c, "foo", "2", -2*time.Hour, ExecutionStatusFailed)
+	s.save(c, "bar", "3", -90*time.Minute, ExecutionStatusFailed)
+	s.save(c, "foo", "4", -time.Hour, ExecutionStatusSuccessful)
+
+	c.Assert(s.query(c, ExecutionQuery{}), DeepEquals, []string{"4", "3", "2", "1"})
+	c.Assert(s.query(c, ExecutionQuery{Job: "foo"}), DeepEquals, []string{"4", "2", "1"})
+	c.Assert(s.query(c, ExecutionQuery{Job: "qux"}), HasLen, 0)
+	c.Assert(s.query(c, ExecutionQuery{Status: ExecutionStatusFailed}), DeepEquals, []string{"3", "2"})
+	c.Assert(s.query(c, ExecutionQuery{Limit: 2}), DeepEquals, []string{"4", "3"})
+
+	c.Assert(s.query(c, ExecutionQuery{
+		Since: s.now.Add(-2 * time.Hour),
+		Until: s.now.Add(-time.Hour),
+	}), DeepEquals, []string{"3", "2"})
+
+	records, err := s.store.Query(ExecutionQuery{Job: "bar"})
+	c.Assert(err, IsNil)
+	c.Assert(records[0].Error, Equals, "foo")
+	c.Assert(records[0].Date.Equal(s.now.Add(-90*time.Minute)), Equals, true)
+}
+
+func (s *SuiteBoltStore) TestSaveMaxOutput(c *C) {
+	s.store.MaxOutput = 3
+
+	r := &ExecutionRecord{Job: "foo", ID: "1", Date: s.now, Output: "foobar", Stderr: "qux"}
+	c.Assert(s.store.Save(r), IsNil)
+	c.Assert(r.Output, Equals, "foobar")
+
+	records, err := s.store.Query(ExecutionQuery{})
+	c.Assert(err, IsNil)
+	c.Assert(records[0].Output, Equals, "bar")
+	c.Assert(records[0].Stderr, Equals, "qux")
+	c.Assert(records[0].Truncated, Equals, true)
+}
+
+func (s *SuiteBoltStore) TestSaveRetention(c *C) {
+	s.store.Retention = RetentionPolicy{MaxExecutions: 2}
+
+	s.save(c, "foo", "1", -3*time.Hour, ExecutionStatusSuccessful)
+	s.save(c, "foo", "2", -2*time.Hour, ExecutionStatusSuccessful)
+	s.save(c, "bar", "3", -2*time.Hour, ExecutionStatusSuccessful)
+	s.save(c, "foo", "4", -time.Hour, ExecutionStatusSuccessful)
+
+	c.Assert(s.query(c, ExecutionQuery{}), DeepEquals, []string{"4", "3", "2"})
+}
+
+func (s *SuiteBoltStore) TestPrune(c *C) {
+	s.save(c, "foo", "1", -3*time.Hour, ExecutionStatusSuccessful)
+	s.save(c, "foo", "2", -2*time.Hour, ExecutionStatusSuccessful)
+	s.save(c, "bar", "3", -90*time.Minute, ExecutionStatusSuccessful)
+	s.save(c, "foo", "4", -time.Hour, ExecutionStatusSuccessful)
+
+	n, err := s.store.Prune(RetentionPolicy{})
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 0)
+
+	n, err = s.store.Prune(RetentionPolicy{MaxAge: 150 * time.Minute})
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 1)
+	c.Assert(s.query(c, ExecutionQuery{}), DeepEquals, []string{"4", "3", "2"})
+
+	n, err = s.store.Prune(RetentionPolicy{MaxExecutions: 1})
+	c.Assert(err, IsNil)
+	c.Assert(n, Equals, 1)
+	c.Assert(s.query(c, ExecutionQuery{}), DeepEquals, []string{"4", "3"})
+}
+
+func (s *SuiteBoltStore) TestReopen(c *C) {
+	s.save(c, "foo", "1", -time.Hour, ExecutionStatusSuccessful)
+	c.Assert(s.store.Close(), IsNil)
+
+	var err error
+	s.store, err = NewBoltStore(filepath.Join(s.dir, "ofelia.db"))
+	c.Assert(err, IsNil)
+	c.Assert(s.query(c, ExecutionQuery{}), DeepEquals, []string{"1"})
+}
+
+func (s *SuiteBoltStore) TestMigrateNewerVersion(c *C) {
+	err := s.store.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(boltMetaBucket).Put(boltVersionKey, []byte("100"))
+	})
+	c.Assert(err, IsNil)
+	c.Assert(s.store.Close(), IsNil)
+
+	_, err = NewBoltStore(filepath.Join(s.dir, "ofelia.db"))
+	c.Assert(err, ErrorMatches, "error migrating store .*: version 100 is newer than the supported one, 1")
+}
+
+func (s *SuiteBoltStore) TestNewExecutionRecord(c *C) {
+	job := &TestJob{}
+	job.Name = "foo"
+
+	e := NewExecution()
+	e.Start()
+	e.OutputStream.Write([]byte("bar"))
+	e.Stop(errors.New("qux"))
+	e.ExitCode = 2
+
+	r := NewExecutionRecord(job, e)
+	c.Assert(r.Job, Equals, "foo")
+	c.Assert(r.ID, Equals, e.ID)
+	c.Assert(r.Status, Equals, ExecutionStatusFailed)
+	c.Assert(r.ExitCode, Equals, 2)
+	c.Assert(r.Error, Equals, "qux")
+	c.Assert(r.Output, Equals, "bar")
+
+	r.Truncate(2)
+	c.Assert(r.Output, Equals, "ar")
+	c.Assert(r.Truncated, Equals, true)
+}
+
+func (s *SuiteBoltStore) save(c *C, job, id string, age time.Duration, status string) {
+	r := &ExecutionRecord{Job: job, ID: id, Date: s.now.Add(age), Status: status}
+	if status == ExecutionStatusFailed {
+		r.Error = "foo"
+	}
+
+	c.Assert(s.store.Save(r), IsNil)
+}
+
+func (s *SuiteBoltStore) query(c *C, q ExecutionQuery) []string {
+	records, err := s.store.Query(q)
+	c.Assert(err, IsNil)
+
+	ids := make([]string, len(records))
+	for i, r := range records {
+		ids[i] = r.ID
+	}
+
+	return ids
+}
@@ -2,10 +2,11 @@ package core
 
 import (
 	"crypto/rand"
+	"encoding/json"
 	"errors"
 	"fmt"
-	"github.com/armon/circbuf"
 	"reflect"
+	"strconv"
 	"strings"
 	"time"
 
@@ -21,7 +22,27 @@ var (
 	ErrLocalImageNotFound = errors.New("couldn't find image on the host")
 )
 
-// maximum size of a stdout/stderr stream to be kept in memory and optional stored/sent via mail
+// SkippedError pass this error to `Execution.Stop` if you wish to mark it as
+// skipped for a given reason.
+type SkippedError struct {
+	Reason string
+}
+
+func (e *SkippedError) Error() string {
+	return fmt.Sprintf("%s: %s", ErrSkippedExecution, e.Reason)
+}
+
+// IsSkipped returns true if the given error marks an execution as skipped
+func IsSkipped(err error) bool {
+	if err == ErrSkippedExecution {
+		return true
+	}
+
+	_, ok := err.(*SkippedError)
+	return ok
+}
+
+// default maximum size of a stdout/stderr stream to be kept and optional stored/sent via mail
 const maxStreamSize = 10 * 1024 * 1024
 
 type Job interface {
@@ -30,6 +51,8 @@ type Job interface {
 	GetSchedule() string
 	GetRunOnStart() bool
 	GetCommand() string
+	GetLogLevel() string
+	GetMaxOutputSize() int64
 	Middlewares() []Middleware
 	Use(...Middleware)
 	Run(*Context) error
@@ -38,6 +61,15 @@ type Job interface {
 	NotifyStop()
 }
 
+// Killer is implemented by the jobs able to stop their running executions,
+// e.g. the process trees of the local jobs
+type Killer interface {
+	// Terminate asks the running executions to stop
+	Terminate()
+	// Kill kills the running executions
+	Kill()
+}
+
 type Context struct {
 	Scheduler *Scheduler
 	Logger    Logger
@@ -52,7 +84,7 @@ type Context struct {
 func NewContext(s *Scheduler, j Job, e *Execution) *Context {
 	return &Context{
 		Scheduler:   s,
-		Logger:      s.Logger,
+		Logger:      jobLogger(s.Logger, j, e),
 		Job:         j,
 		Execution:   e,
 		middlewares: j.Middlewares(),
@@ -91,7 +123,12 @@ func (c *Context) doNext() error {
 	}
 
 	c.executed = true
-	return c.Job.Run(c)
+	err := c.Job.Run(c)
+	if c.Scheduler != nil {
+		err = c.Scheduler.Redactor.RedactError(err)
+	}
+
+	return err
 }
 
 func (c *Context) getNext() (Middleware, bool) {
@@ -113,17 +150,44 @@ func (c *Context) Stop(err error) {
 }
 
 func (c *Context) Log(msg string) {
+	c.LogFields(msg, nil)
+}
+
+// LogFields logs the message with the given fields attached, when the logger
+// supports them
+func (c *Context) LogFields(msg string, fields Fields) {
 	format := "[Job %q (%s %s)] %s"
 	args := []interface{}{c.Job.GetName(), c.Job.GetLabel(), c.Execution.ID, msg}
 
+	l := c.Logger
+	if fl, ok := l.(FieldLogger); ok && len(fields) > 0 {
+		l = fl.WithFields(fields)
+	}
+
 	switch {
 	case c.Execution.Failed:
-		c.Logger.Errorf(format, args...)
+		l.Errorf(format, args...)
 	case c.Execution.Skipped:
-		c.Logger.Warningf(format, args...)
+		l.Warningf(format, args...)
 	default:
-		c.Logger.Noticef(format, args...)
+		l.Noticef(format, args...)
+	}
+}
+
+// jobLogger returns the logger of an execution, attaching the job and the
+// execution to every line, at the level of the job, when supported
+func jobLogger(l Logger, j Job, e *Execution) Logger {
+	fl, ok := l.(FieldLogger)
+	if !ok {
+		return l
+	}
+
+	fl = fl.WithFields(Fields{"job": j.GetName(), "execution": e.ID})
+	if level := j.GetLogLevel(); level != "" {
+		fl = fl.WithLevel(level)
 	}
+
+	return fl
 }
 
 // Execution contains all the information relative to a Job execution.
@@ -135,19 +199,70 @@ type Execution struct {
 	Failed    bool
 	Skipped   bool
 	Error     error
+	ExitCode  int
+
+	// Usage contains the resources used by the process, when known
+	Usage *ResourceUsage `json:",omitempty"`
+
+	// Targets contains the result on every target (e.g. container) the job
+	// was executed on
+	Targets []*TargetResult `json:",omitempty"`
 
-	OutputStream, ErrorStream *circbuf.Buffer `json:"-"`
+	// Prune contains what was deleted by a prune job
+	Prune *PruneResult `json:",omitempty"`
+
+	OutputStream, ErrorStream *Stream `json:"-"`
+}
+
+// ResourceUsage contains the resources used by an execution
+type ResourceUsage struct {
+	UserTime   time.Duration
+	SystemTime time.Duration
+	// MaxRSS is the maximum resident set size, in bytes
+	MaxRSS int64
+}
+
+// TargetResult is the result of an execution on a single target
+type TargetResult struct {
+	Name     string
+	ExitCode int
+	Error    string `json:",omitempty"`
+}
+
+// PruneResult contains the number of deleted objects of each kind, and the
+// disk space reclaimed in bytes
+type PruneResult struct {
+	ContainersDeleted int
+	ImagesDeleted     int
+	VolumesDeleted    int
+	NetworksDeleted   int
+	BuildCacheDeleted int
+	SpaceReclaimed    int64
 }
 
 // NewExecution returns a new Execution, with a random ID
 func NewExecution() *Execution {
-	bufOut, _ := circbuf.NewBuffer(maxStreamSize)
-	bufErr, _ := circbuf.NewBuffer(maxStreamSize)
 	return &Execution{
 		ID:           randomID(),
-		OutputStream: bufOut,
-		ErrorStream:  bufErr,
+		OutputStream: NewStream(maxStreamSize),
+		ErrorStream:  NewStream(maxStreamSize),
+	}
+}
+
+// MarshalJSON encodes the error as its message, an error interface is encoded
+// as an empty object otherwise
+func (e *Execution) MarshalJSON() ([]byte, error) {
+	type execution Execution
+
+	var msg string
+	if e.Error != nil {
+		msg = e.Error.Error()
 	}
+
+	return json.Marshal(&struct {
+		*execution
+		Error string `json:",omitempty"`
+	}{(*execution)(e), msg})
 }
 
 // Start start the exection, initialize the running flags and the start date.
@@ -156,19 +271,74 @@ func (e *Execution) Start() {
 	e.Date = time.Now()
 }
 
-// Stop stops the executions, if a ErrSkippedExecution is given the exection
-// is mark as skipped, if any other error is given the exection is mark as
-// failed. Also mark the exection as IsRunning false and save the duration time
+// Stop stops the executions, if a ErrSkippedExecution or a SkippedError is
+// given the exection is mark as skipped, if any other error is given the
+// exection is mark as failed. Also mark the exection as IsRunning false, save
+// the duration time and close the streams, ending the readers following them
 func (e *Execution) Stop(err error) {
 	e.IsRunning = false
 	e.Duration = time.Since(e.Date)
 
-	if err != nil && err != ErrSkippedExecution {
+	switch {
+	case err == nil:
+	case IsSkipped(err):
+		e.Skipped = true
+		if err != ErrSkippedExecution {
+			e.Error = err
+		}
+	default:
 		e.Error = err
 		e.Failed = true
-	} else if err == ErrSkippedExecution {
-		e.Skipped = true
 	}
+
+	for _, s := range []*Stream{e.OutputStream, e.ErrorStream} {
+		if s != nil {
+			s.Close()
+		}
+	}
+}
+
+// Status returns the status of a stopped execution: successful, failed or
+// skipped
+func (e *Execution) Status() string {
+	switch {
+	case e.Skipped:
+		return ExecutionStatusSkipped
+	case e.Failed:
+		return ExecutionStatusFailed
+	default:
+		return ExecutionStatusSuccessful
+	}
+}
+
+// SetMaxOutputSize changes the number of bytes kept of each output, it must
+// be called before the execution starts
+func (e *Execution) SetMaxOutputSize(size int64) {
+	e.OutputStream.SetMaxSize(size)
+	e.ErrorStream.SetMaxSize(size)
+}
+
+// SetRedactor redacts the outputs, it must be called before the execution
+// starts
+func (e *Execution) SetRedactor(r *Redactor) {
+	e.OutputStream.SetRedactor(r)
+	e.ErrorStream.SetRedactor(r)
+}
+
+// Release frees the outputs, once every middleware is done with them
+func (e *Execution) Release() {
+	e.OutputStream.Release()
+	e.ErrorStream.Release()
+}
+
+// AddTarget records the result of the execution on the given target
+func (e *Execution) AddTarget(name string, exitCode int, err error) {
+	r := &TargetResult{Name: name, ExitCode: exitCode}
+	if err != nil {
+		r.Error = err.Error()
+	}
+
+	e.Targets = append(e.Targets, r)
 }
 
 // Middleware can wrap any job execution, allowing to execution code before
@@ -225,6 +395,18 @@ type Logger interface {
 	Warningf(format string, args ...interface{})
 }
 
+// Fields are structured data attached to the log lines
+type Fields map[string]interface{}
+
+// FieldLogger is a Logger attaching fields to its lines, and able to log at a
+// different level than the configured one, e.g. for a single job
+type FieldLogger interface {
+	Logger
+	WithFields(Fields) FieldLogger
+	// WithLevel returns the logger unchanged if the level is invalid
+	WithLevel(level string) FieldLogger
+}
+
 func randomID() string {
 	b := make([]byte, 6)
 	if _, err := rand.Read(b); err != nil {
@@ -234,6 +416,55 @@ func randomID() string {
 	return fmt.Sprintf("%x", b)
 }
 
+// defaultScriptInterpreter is used to run scripts without shebang
+var defaultScriptInterpreter = []string{"/bin/sh"}
+
+// scriptInterpreter returns the interpreter of a script based on its shebang
+// e.g. `#!/usr/bin/env python3`, `/bin/sh` if none is given.
+func scriptInterpreter(script string) []string {
+	if !strings.HasPrefix(script, "#!") {
+		return defaultScriptInterpreter
+	}
+
+	line := strings.SplitN(script[2:], "\n", 2)[0]
+	interpreter := strings.Fields(line)
+	if len(interpreter) == 0 {
+		return defaultScriptInterpreter
+	}
+
+	return interpreter
+}
+
+// parseExitCodes parses a comma separated list of exit codes, e.g. `0,3`
+func parseExitCodes(codes string) ([]int, error) {
+	var result []int
+	for _, c := range strings.Split(codes, ",") {
+		c = strings.TrimSpace(c)
+		if c == "" {
+			continue
+		}
+
+		code, err := strconv.Atoi(c)
+		if err != nil {
+			return nil, fmt.Errorf("invalid exit code %q", c)
+		}
+
+		result = append(result, code)
+	}
+
+	return result, nil
+}
+
+func containsInt(list []int, i int) bool {
+	for _, v := range list {
+		if v == i {
+			return true
+		}
+	}
+
+	return false
+}
+
 func buildFindLocalImageOptions(image string) docker.ListImagesOptions {
 	return docker.ListImagesOptions{
 		Filters: map[string][]string{
@@ -271,7 +502,33 @@ func parseRegistry(repository string) string {
 	return ""
 }
 
+// RegistrySecrets returns the credentials used to pull the image, if any
+func RegistrySecrets(image string) []string {
+	_, auth := buildPullOptions(image)
+	return []string{auth.Password, auth.IdentityToken, auth.RegistryToken}
+}
+
 func buildAuthConfiguration(registry string) docker.AuthConfiguration {
-	authConfiguration, _ := docker.NewAuthConfigurationsFromCredsHelpers(registry)
+	authConfiguration, err := docker.NewAuthConfigurationsFromCredsHelpers(registry)
+	if err != nil || authConfiguration == nil {
+		return docker.AuthConfiguration{}
+	}
+
 	return *authConfiguration
 }
+
+// ParseAge parses a duration, also accepting a number of days, e.g. "7d"
+func ParseAge(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, nil
+	}
+
+	if strings.HasSuffix(s, "d") {
+		days, err := strconv.Atoi(strings.TrimSuffix(s, "d"))
+		if err == nil {
+			return time.Duration(days) * 24 * time.Hour, nil
+		}
+	}
+
+	return time.ParseDuration(s)
+}
@@ -1,6 +1,7 @@
 package core
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
 	"testing"
@@ -218,6 +219,22 @@ func (s *SuiteCommon) TestExecutionStopError(c *C) {
 	c.Assert(exe.Duration.Seconds() > .0, Equals, true)
 }
 
+func (s *SuiteCommon) TestExecutionMarshalJSON(c *C) {
+	exe := NewExecution()
+	exe.Start()
+	exe.Stop(errors.New("foo"))
+	exe.ExitCode = 2
+
+	b, err := json.Marshal(map[string]interface{}{"Execution": exe})
+	c.Assert(err, IsNil)
+
+	var v struct{ Execution map[string]interface{} }
+	c.Assert(json.Unmarshal(b, &v), IsNil)
+	c.Assert(v.Execution["Error"], Equals, "foo")
+	c.Assert(v.Execution["ExitCode"], Equals, 2.0)
+	c.Assert(v.Execution["ID"], Equals, exe.ID)
+}
+
 func (s *SuiteCommon) TestExecutionStopErrorSkip(c *C) {
 	exe := &Execution{}
 	exe.Start()
@@ -230,6 +247,20 @@ func (s *SuiteCommon) TestExecutionStopErrorSkip(c *C) {
 	c.Assert(exe.Duration.Seconds() > .0, Equals, true)
 }
 
+func (s *SuiteCommon) TestExecutionStopSkippedError(c *C) {
+	err := &SkippedError{Reason: "foo"}
+
+	exe := &Execution{}
+	exe.Start()
+	exe.Stop(err)
+
+	c.Assert(exe.IsRunning, Equals, false)
+	c.Assert(exe.Failed, Equals, false)
+	c.Assert(exe.Skipped, Equals, true)
+	c.Assert(exe.Error, Equals, err)
+	c.Assert(exe.Error, ErrorMatches, "skipped execution: foo")
+}
+
 func (s *SuiteCommon) TestMiddlewareContainerUseTwice(c *C) {
 	mA := &TestMiddleware{}
 	mB := &TestMiddleware{}
@@ -302,10 +333,6 @@ type TestJob struct {
 	Called int
 }
 
-func (j *TestJob) GetLabel() string {
-	panic("implement me")
-}
-
 func (j *TestJob) Run(ctx *Context) error {
 	j.Called++
 	time.Sleep(time.Millisecond * 500)
@@ -327,3 +354,27 @@ func (s *SuiteCommon) TestParseRegistry(c *C) {
 	c.Assert(parseRegistry("dir/image"), Equals, "")
 	c.Assert(parseRegistry("image"), Equals, "")
 }
+
+func (s *SuiteCommon) TestScriptInterpreter(c *C) {
+	c.Assert(scriptInterpreter("echo foo"), DeepEquals, []string{"/bin/sh"})
+	c.Assert(scriptInterpreter("#!/bin/bash -e\necho foo"), DeepEquals, []string{"/bin/bash", "-e"})
+	c.Assert(scriptInterpreter("#!/usr/bin/env python3\n"), DeepEquals, []string{"/usr/bin/env", "python3"})
+	c.Assert(scriptInterpreter("#!\necho foo"), DeepEquals, []string{"/bin/sh"})
+}
+
+func (s *SuiteCommon) TestParseAge(c *C) {
+	d, err := ParseAge("7d")
+	c.Assert(err, IsNil)
+	c.Assert(d, Equals, 7*24*time.Hour)
+
+	d, err = ParseAge("90m")
+	c.Assert(err, IsNil)
+	c.Assert(d, Equals, 90*time.Minute)
+
+	d, err = ParseAge("")
+	c.Assert(err, IsNil)
+	c.Assert(d, Equals, time.Duration(0))
+
+	_, err = ParseAge("foo")
+	c.Assert(err, NotNil)
+}
@@ -0,0 +1,168 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+
+	docker "github.com/fsouza/go-dockerclient"
+	"github.com/gobs/args"
+)
+
+// Labels set by docker-compose on the containers it creates
+const (
+	composeProjectLabel         = "com.docker.compose.project"
+	composeServiceLabel         = "com.docker.compose.service"
+	composeOneoffLabel          = "com.docker.compose.oneoff"
+	composeContainerNumberLabel = "com.docker.compose.container-number"
+)
+
+var ErrNoServiceContainer = errors.New("couldn't find any container of the compose service")
+
+// ComposeJob runs a one-off container of a docker-compose service, like
+// `docker compose run --rm` does, based on the definition of an existing
+// container of the service.
+type ComposeJob struct {
+	BareJob     `mapstructure:",squash"`
+	Client      *docker.Client `json:"-"`
+	Project     string
+	Service     string
+	User        string
+	TTY         bool `default:"false"`
+	Environment []string
+
+	// see RunJob.Delete
+	Delete string `default:"true"`
+}
+
+func NewComposeJob(c *docker.Client) *ComposeJob {
+	return &ComposeJob{Client: c}
+}
+
+func (j *ComposeJob) Run(ctx *Context) error {
+	template, err := j.findServiceContainer()
+	if err != nil {
+		return err
+	}
+
+	container, err := j.buildContainer(template)
+	if err != nil {
+		return err
+	}
+
+	runner := &RunJob{Client: j.Client, Delete: j.Delete}
+	return runner.runContainer(ctx, container, true, true)
+}
+
+// findServiceContainer returns a container created by compose for the
+// service, ignoring the one-off containers.
+func (j *ComposeJob) findServiceContainer() (*docker.Container, error) {
+	containers, err := j.Client.ListContainers(docker.ListContainersOptions{
+		All: true,
+		Filters: map[string][]string{
+			"label": {
+				fmt.Sprintf("%s=%s", composeProjectLabel, j.Project),
+				fmt.Sprintf("%s=%s", composeServiceLabel, j.Service),
+				fmt.Sprintf("%s=False", composeOneoffLabel),
+			},
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error listing containers: %s", err)
+	}
+
+	if len(containers) == 0 {
+		return nil, ErrNoServiceContainer
+	}
+
+	return j.Client.InspectContainer(containers[0].ID)
+}
+
+func (j *ComposeJob) buildContainer(template *docker.Container) (*docker.Container, error) {
+	config := *template.Config
+	config.Hostname = ""
+	config.Tty = j.TTY
+	config.AttachStdin = false
+	config.AttachStdout = true
+	config.AttachStderr = true
+	config.OpenStdin = false
+	config.Env = append(append([]string{}, template.Config.Env...), j.Environment...)
+
+	config.Labels = make(map[string]string, len(template.Config.Labels))
+	for k, v := range template.Config.Labels {
+		config.Labels[k] = v
+	}
+
+	config.Labels[composeOneoffLabel] = "True"
+	delete(config.Labels, composeContainerNumberLabel)
+
+	if j.Command != "" {
+		config.Cmd = args.GetArgs(j.Command)
+	}
+
+	if j.User != "" {
+		config.User = j.User
+	}
+
+	hostConfig := &docker.HostConfig{}
+	if template.HostConfig != nil {
+		*hostConfig = *template.HostConfig
+	}
+
+	hostConfig.PortBindings = nil
+	hostConfig.RestartPolicy = docker.RestartPolicy{}
+	hostConfig.AutoRemove = false
+
+	// the service is reachable by its name on every network, like compose does
+	networks := templateNetworks(template)
+	networkingConfig := &docker.NetworkingConfig{}
+	if _, ok := networks[hostConfig.NetworkMode]; ok {
+		networkingConfig.EndpointsConfig = map[string]*docker.EndpointConfig{
+			hostConfig.NetworkMode: {Aliases: []string{j.Service}},
+		}
+	}
+
+	c, err := j.Client.CreateContainer(docker.CreateContainerOptions{
+		Name:             fmt.Sprintf("%s-%s-run-%s", j.Project, j.Service, randomID()),
+		Config:           &config,
+		HostConfig:       hostConfig,
+		NetworkingConfig: networkingConfig,
+	})
+
+	if err != nil {
+		return c, fmt.Errorf("error creating container: %s", err)
+	}
+
+	var names []string
+	for name := range networks {
+		if name != hostConfig.NetworkMode {
+			names = append(names, name)
+		}
+	}
+
+	sort.Strings(names)
+	for _, name := range names {
+		if err := j.Client.ConnectNetwork(networks[name], docker.NetworkConnectionOptions{
+			Container:      c.ID,
+			EndpointConfig: &docker.EndpointConfig{Aliases: []string{j.Service}},
+		}); err != nil {
+			return c, fmt.Errorf("error connecting container to network %q: %s", name, err)
+		}
+	}
+
+	return c, nil
+}
+
+// templateNetworks returns the networks of the container, by name to ID
+func templateNetworks(c *docker.Container) map[string]string {
+	networks := make(map[string]string)
+	if c.NetworkSettings == nil {
+		return networks
+	}
+
+	for name, n := range c.NetworkSettings.Networks {
+		networks[name] = n.NetworkID
+	}
+
+	return networks
+}
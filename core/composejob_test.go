@@ -0,0 +1,178 @@
+package core
+
+import (
+	"archive/tar"
+	"bytes"
+	"strings"
+	"sync"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+	"github.com/fsouza/go-dockerclient/testing"
+	. "gopkg.in/check.v1"
+)
+
+type SuiteComposeJob struct {
+	server *testing.DockerServer
+	client *docker.Client
+}
+
+var _ = Suite(&SuiteComposeJob{})
+
+func (s *SuiteComposeJob) SetUpTest(c *C) {
+	var err error
+	s.server, err = testing.NewServer("127.0.0.1:0", nil, nil)
+	c.Assert(err, IsNil)
+
+	s.client, err = docker.NewClient(s.server.URL())
+	c.Assert(err, IsNil)
+
+	s.buildImage(c)
+	s.buildServiceContainer(c)
+}
+
+func (s *SuiteComposeJob) TestBuildContainer(c *C) {
+	job := &ComposeJob{Client: s.client}
+	job.Project = "myapp"
+	job.Service = "migrations"
+	job.Command = `migrate --to "last one"`
+	job.User = "foo"
+	job.Environment = []string{"BAR=qux"}
+
+	template, err := job.findServiceContainer()
+	c.Assert(err, IsNil)
+	c.Assert(template.Name, Equals, "myapp_migrations_1")
+
+	container, err := job.buildContainer(template)
+	c.Assert(err, IsNil)
+
+	container, err = s.client.InspectContainer(container.ID)
+	c.Assert(err, IsNil)
+	c.Assert(strings.HasPrefix(container.Name, "myapp-migrations-run-"), Equals, true)
+	c.Assert(container.Config.Image, Equals, ImageFixture)
+	c.Assert(container.Config.Cmd, DeepEquals, []string{"migrate", "--to", "last one"})
+	c.Assert(container.Config.User, Equals, "foo")
+	c.Assert(container.Config.Env, DeepEquals, []string{"FOO=bar", "BAR=qux"})
+	c.Assert(container.Config.Labels[composeOneoffLabel], Equals, "True")
+	c.Assert(container.Config.Labels[composeProjectLabel], Equals, "myapp")
+	c.Assert(container.HostConfig.Binds, DeepEquals, []string{"/data:/data"})
+	c.Assert(container.HostConfig.PortBindings, HasLen, 0)
+	c.Assert(container.HostConfig.RestartPolicy.Name, Equals, "")
+}
+
+func (s *SuiteComposeJob) TestRun(c *C) {
+	job := &ComposeJob{Client: s.client}
+	job.Project = "myapp"
+	job.Service = "migrations"
+	job.Command = "migrate"
+	job.Delete = "true"
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+
+	go func() {
+		defer wg.Done()
+		time.Sleep(time.Millisecond * 200)
+
+		containers, err := s.client.ListContainers(docker.ListContainersOptions{
+			Filters: map[string][]string{"label": {composeOneoffLabel + "=True"}},
+		})
+		c.Assert(err, IsNil)
+		c.Assert(containers, HasLen, 1)
+
+		err = s.client.StopContainer(containers[0].ID, 0)
+		c.Assert(err, IsNil)
+	}()
+
+	err := job.Run(&Context{Execution: NewExecution()})
+	c.Assert(err, IsNil)
+	wg.Wait()
+
+	containers, err := s.client.ListContainers(docker.ListContainersOptions{All: true})
+	c.Assert(err, IsNil)
+	c.Assert(containers, HasLen, 1)
+}
+
+func (s *SuiteComposeJob) TestRunFailed(c *C) {
+	job := &ComposeJob{Client: s.client}
+	job.Project = "myapp"
+	job.Service = "migrations"
+	job.Command = "migrate"
+	job.Delete = "true"
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+
+	go func() {
+		defer wg.Done()
+		time.Sleep(time.Millisecond * 200)
+
+		containers, err := s.client.ListContainers(docker.ListContainersOptions{
+			Filters: map[string][]string{"label": {composeOneoffLabel + "=True"}},
+		})
+		c.Check(err, IsNil)
+		c.Check(containers, HasLen, 1)
+
+		err = s.server.MutateContainer(containers[0].ID, docker.State{ExitCode: 2})
+		c.Check(err, IsNil)
+	}()
+
+	e := NewExecution()
+	err := job.Run(&Context{Execution: e})
+	c.Assert(err, ErrorMatches, "error non-zero exit code: 2")
+	c.Assert(e.ExitCode, Equals, 2)
+	wg.Wait()
+
+	containers, err := s.client.ListContainers(docker.ListContainersOptions{All: true})
+	c.Assert(err, IsNil)
+	c.Assert(containers, HasLen, 1)
+}
+
+func (s *SuiteComposeJob) TestRunNoServiceContainer(c *C) {
+	job := &ComposeJob{Client: s.client}
+	job.Project = "myapp"
+	job.Service = "foo"
+
+	err := job.Run(&Context{Execution: NewExecution()})
+	c.Assert(err, Equals, ErrNoServiceContainer)
+}
+
+func (s *SuiteComposeJob) buildImage(c *C) {
+	inputbuf := bytes.NewBuffer(nil)
+	tr := tar.NewWriter(inputbuf)
+	tr.WriteHeader(&tar.Header{Name: "Dockerfile"})
+	tr.Write([]byte("FROM base\n"))
+	tr.Close()
+
+	err := s.client.BuildImage(docker.BuildImageOptions{
+		Name:         ImageFixture,
+		InputStream:  inputbuf,
+		OutputStream: bytes.NewBuffer(nil),
+	})
+	c.Assert(err, IsNil)
+}
+
+func (s *SuiteComposeJob) buildServiceContainer(c *C) {
+	_, err := s.client.CreateContainer(docker.CreateContainerOptions{
+		Name: "myapp_migrations_1",
+		Config: &docker.Config{
+			Image: ImageFixture,
+			Cmd:   []string{"sleep", "infinity"},
+			Env:   []string{"FOO=bar"},
+			Labels: map[string]string{
+				composeProjectLabel:         "myapp",
+				composeServiceLabel:         "migrations",
+				composeOneoffLabel:          "False",
+				composeContainerNumberLabel: "1",
+			},
+		},
+		HostConfig: &docker.HostConfig{
+			Binds:         []string{"/data:/data"},
+			RestartPolicy: docker.AlwaysRestart(),
+			PortBindings: map[docker.Port][]docker.PortBinding{
+				"80/tcp": {{HostPort: "8080"}},
+			},
+		},
+	})
+	c.Assert(err, IsNil)
+}
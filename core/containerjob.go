@@ -0,0 +1,181 @@
+package core
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/docker/docker/pkg/signal"
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+// Actions supported by ContainerJob
+const (
+	ContainerActionRestart = "restart"
+	ContainerActionStop    = "stop"
+	ContainerActionStart   = "start"
+	ContainerActionKill    = "kill"
+	ContainerActionPause   = "pause"
+	ContainerActionUnpause = "unpause"
+	ContainerActionSignal  = "signal"
+)
+
+var ErrSignalRequired = errors.New("signal is required by the signal action")
+
+// ContainerJob changes the state of containers, similar to `docker restart`,
+// `docker stop`, `docker kill`, etc.
+type ContainerJob struct {
+	BareJob           `mapstructure:",squash"`
+	Client            *docker.Client `json:"-"`
+	Action            string
+	Container         string
+	ContainerSelector []string `gcfg:"container-selector" mapstructure:"container-selector"`
+	Target            string   `default:"all"`
+	Signal            string
+	Timeout           string
+}
+
+func NewContainerJob(c *docker.Client) *ContainerJob {
+	return &ContainerJob{Client: c}
+}
+
+// GetCommand returns the action, as there is no command to run
+func (j *ContainerJob) GetCommand() string {
+	return j.Action
+}
+
+func (j *ContainerJob) Run(ctx *Context) error {
+	action, err := j.buildAction()
+	if err != nil {
+		return err
+	}
+
+	containers, err := j.resolveContainers()
+	if err != nil {
+		return err
+	}
+
+	var failed []string
+	for _, container := range containers {
+		err := action(container)
+		ctx.Execution.AddTarget(container, 0, err)
+
+		if err != nil {
+			fmt.Fprintf(ctx.Execution.OutputStream, "%s %s: %s\n", j.Action, container, err)
+			failed = append(failed, fmt.Sprintf("%s: %s", container, err))
+			continue
+		}
+
+		fmt.Fprintf(ctx.Execution.OutputStream, "%s %s: done\n", j.Action, container)
+	}
+
+	if len(failed) == 0 {
+		return nil
+	}
+
+	if len(containers) == 1 {
+		return fmt.Errorf("error on container %s", failed[0])
+	}
+
+	return fmt.Errorf(
+		"error on %d of %d containers: %s",
+		len(failed), len(containers), strings.Join(failed, "; "),
+	)
+}
+
+// resolveContainers returns the containers affected by the action, based on
+// the container name or the selector and target strategy.
+func (j *ContainerJob) resolveContainers() ([]string, error) {
+	if len(j.ContainerSelector) == 0 {
+		return []string{j.Container}, nil
+	}
+
+	names, err := findContainers(j.Client, j.ContainerSelector, true)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(names) == 0 {
+		return nil, ErrNoContainerFound
+	}
+
+	return selectTargets(names, j.Target)
+}
+
+// buildAction returns the function applying the configured action to a
+// container, validating its options.
+func (j *ContainerJob) buildAction() (func(container string) error, error) {
+	timeout, err := j.timeout()
+	if err != nil {
+		return nil, err
+	}
+
+	sig, err := j.signal()
+	if err != nil {
+		return nil, err
+	}
+
+	switch j.Action {
+	case ContainerActionRestart:
+		return func(container string) error {
+			return j.Client.RestartContainer(container, timeout)
+		}, nil
+	case ContainerActionStop:
+		return func(container string) error {
+			return j.Client.StopContainer(container, timeout)
+		}, nil
+	case ContainerActionStart:
+		return func(container string) error {
+			return j.Client.StartContainer(container, nil)
+		}, nil
+	case ContainerActionPause:
+		return j.Client.PauseContainer, nil
+	case ContainerActionUnpause:
+		return j.Client.UnpauseContainer, nil
+	case ContainerActionSignal:
+		if sig == 0 {
+			return nil, ErrSignalRequired
+		}
+
+		fallthrough
+	case ContainerActionKill:
+		return func(container string) error {
+			return j.Client.KillContainer(docker.KillContainerOptions{
+				ID:     container,
+				Signal: sig,
+			})
+		}, nil
+	default:
+		return nil, fmt.Errorf("invalid action %q", j.Action)
+	}
+}
+
+// timeout returns the seconds to wait for the container to stop before
+// killing it, docker defaults to 10 seconds.
+func (j *ContainerJob) timeout() (uint, error) {
+	if j.Timeout == "" {
+		return 10, nil
+	}
+
+	t, err := parseSeconds(j.Timeout)
+	if err != nil {
+		return 0, fmt.Errorf("invalid timeout %q: %s", j.Timeout, err)
+	}
+
+	return uint(t / time.Second), nil
+}
+
+// signal returns the configured signal, zero meaning docker's default SIGKILL
+func (j *ContainerJob) signal() (docker.Signal, error) {
+	if j.Signal == "" {
+		return 0, nil
+	}
+
+	sig, err := signal.ParseSignal(j.Signal)
+	if err != nil {
+		return 0, fmt.Errorf("invalid signal %q", j.Signal)
+	}
+
+	return docker.Signal(sig), nil
+}
@@ -0,0 +1,153 @@
+package core
+
+import (
+	"archive/tar"
+	"bytes"
+
+	docker "github.com/fsouza/go-dockerclient"
+	"github.com/fsouza/go-dockerclient/testing"
+	. "gopkg.in/check.v1"
+)
+
+type SuiteContainerJob struct {
+	server *testing.DockerServer
+	client *docker.Client
+}
+
+var _ = Suite(&SuiteContainerJob{})
+
+func (s *SuiteContainerJob) SetUpTest(c *C) {
+	var err error
+	s.server, err = testing.NewServer("127.0.0.1:0", nil, nil)
+	c.Assert(err, IsNil)
+
+	s.client, err = docker.NewClient(s.server.URL())
+	c.Assert(err, IsNil)
+
+	s.buildImage(c)
+	s.buildContainer(c, "worker-1", true)
+	s.buildContainer(c, "worker-2", false)
+}
+
+func (s *SuiteContainerJob) TestRunStop(c *C) {
+	job := &ContainerJob{Client: s.client}
+	job.Action = ContainerActionStop
+	job.Container = "worker-1"
+
+	e := NewExecution()
+	err := job.Run(&Context{Execution: e})
+	c.Assert(err, IsNil)
+	c.Assert(e.OutputStream.String(), Equals, "stop worker-1: done\n")
+
+	container, err := s.client.InspectContainer("worker-1")
+	c.Assert(err, IsNil)
+	c.Assert(container.State.Running, Equals, false)
+}
+
+func (s *SuiteContainerJob) TestRunPauseSelector(c *C) {
+	job := &ContainerJob{Client: s.client}
+	job.Action = ContainerActionPause
+	job.ContainerSelector = []string{"app=worker"}
+	job.Target = TargetAll
+
+	err := s.client.PauseContainer("worker-2")
+	c.Assert(err, IsNil)
+
+	e := NewExecution()
+	err = job.Run(&Context{Execution: e})
+	c.Assert(err, NotNil)
+	c.Assert(e.Targets, HasLen, 2)
+	c.Assert(e.Targets[0].Name, Equals, "worker-1")
+	c.Assert(e.Targets[0].Error, Equals, "")
+	c.Assert(e.Targets[1].Name, Equals, "worker-2")
+	c.Assert(e.Targets[1].Error, Not(Equals), "")
+	c.Assert(err, ErrorMatches, "error on 1 of 2 containers: worker-2: .*already paused\n")
+
+	container, err := s.client.InspectContainer("worker-1")
+	c.Assert(err, IsNil)
+	c.Assert(container.State.Paused, Equals, true)
+}
+
+func (s *SuiteContainerJob) TestRunSignal(c *C) {
+	job := &ContainerJob{Client: s.client}
+	job.Action = ContainerActionSignal
+	job.Container = "worker-1"
+
+	c.Assert(job.Run(&Context{Execution: NewExecution()}), Equals, ErrSignalRequired)
+
+	job.Signal = "SIGHUP"
+	c.Assert(job.Run(&Context{Execution: NewExecution()}), IsNil)
+}
+
+func (s *SuiteContainerJob) TestRunNoContainerMatch(c *C) {
+	job := &ContainerJob{Client: s.client}
+	job.Action = ContainerActionRestart
+	job.ContainerSelector = []string{"app=foo"}
+
+	c.Assert(job.Run(&Context{Execution: NewExecution()}), Equals, ErrNoContainerFound)
+}
+
+func (s *SuiteContainerJob) TestBuildActionInvalid(c *C) {
+	job := &ContainerJob{Client: s.client}
+	job.Action = "foo"
+
+	_, err := job.buildAction()
+	c.Assert(err, ErrorMatches, `invalid action "foo"`)
+
+	job.Action = ContainerActionKill
+	job.Signal = "FOO"
+	_, err = job.buildAction()
+	c.Assert(err, ErrorMatches, `invalid signal "FOO"`)
+
+	job.Signal = ""
+	job.Timeout = "foo"
+	_, err = job.buildAction()
+	c.Assert(err, ErrorMatches, `invalid timeout "foo": .*`)
+}
+
+func (s *SuiteContainerJob) TestSignal(c *C) {
+	job := &ContainerJob{}
+	for value, expected := range map[string]docker.Signal{
+		"":       0,
+		"SIGHUP": docker.SIGHUP,
+		"hup":    docker.SIGHUP,
+		"15":     docker.SIGTERM,
+	} {
+		job.Signal = value
+		sig, err := job.signal()
+		c.Assert(err, IsNil)
+		c.Assert(sig, Equals, expected)
+	}
+}
+
+func (s *SuiteContainerJob) buildImage(c *C) {
+	inputbuf := bytes.NewBuffer(nil)
+	tr := tar.NewWriter(inputbuf)
+	tr.WriteHeader(&tar.Header{Name: "Dockerfile"})
+	tr.Write([]byte("FROM base\n"))
+	tr.Close()
+
+	err := s.client.BuildImage(docker.BuildImageOptions{
+		Name:         ImageFixture,
+		InputStream:  inputbuf,
+		OutputStream: bytes.NewBuffer(nil),
+	})
+	c.Assert(err, IsNil)
+}
+
+func (s *SuiteContainerJob) buildContainer(c *C, name string, start bool) {
+	container, err := s.client.CreateContainer(docker.CreateContainerOptions{
+		Name: name,
+		Config: &docker.Config{
+			Image:  ImageFixture,
+			Cmd:    []string{"sleep", "infinity"},
+			Labels: map[string]string{"app": "worker"},
+		},
+	})
+	c.Assert(err, IsNil)
+
+	if start {
+		err = s.client.StartContainer(container.ID, nil)
+		c.Assert(err, IsNil)
+	}
+}
@@ -1,18 +1,56 @@
 package core
 
 import (
+	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"sort"
+	"strings"
+	"time"
 
 	docker "github.com/fsouza/go-dockerclient"
 	"github.com/gobs/args"
 )
 
+// Strategies to choose the containers matching a selector
+const (
+	TargetOne    = "one"
+	TargetAll    = "all"
+	TargetRandom = "random"
+)
+
+const containerWaitInterval = time.Second
+
+var (
+	ErrNoContainerFound = errors.New("couldn't find any container matching the selector")
+	ErrScriptWithStdin  = errors.New("script can't be used together with stdin or stdin-file")
+)
+
 type ExecJob struct {
-	BareJob   `mapstructure:",squash"`
-	Client    *docker.Client `json:"-"`
-	Container string
-	User      string `default:"root"`
-	TTY       bool   `default:"false"`
+	BareJob           `mapstructure:",squash"`
+	Client            *docker.Client `json:"-"`
+	Container         string
+	ContainerSelector []string `gcfg:"container-selector" mapstructure:"container-selector"`
+	Target            string   `default:"one"`
+	User              string   `default:"root"`
+	TTY               bool     `default:"false"`
+	Script            string
+	Environment       []string
+	WorkingDir        string `gcfg:"working-dir" mapstructure:"working-dir"`
+	Privileged        bool   `default:"false"`
+	Stdin             string
+	StdinFile         string `gcfg:"stdin-file" mapstructure:"stdin-file"`
+	SuccessExitCodes  string `gcfg:"success-exit-codes" mapstructure:"success-exit-codes" default:"0"`
+	SkipExitCodes     string `gcfg:"skip-exit-codes" mapstructure:"skip-exit-codes"`
+	RequireRunning    bool   `gcfg:"require-running" mapstructure:"require-running"`
+	RequireHealthy    bool   `gcfg:"require-healthy" mapstructure:"require-healthy"`
+	WaitTimeout       string `gcfg:"wait-timeout" mapstructure:"wait-timeout"`
+
+	successCodes []int
+	skipCodes    []int
 }
 
 func NewExecJob(c *docker.Client) *ExecJob {
@@ -20,27 +58,246 @@ func NewExecJob(c *docker.Client) *ExecJob {
 }
 
 func (j *ExecJob) Run(ctx *Context) error {
-	exec, err := j.buildExec()
+	if j.Script != "" && (j.Stdin != "" || j.StdinFile != "") {
+		return ErrScriptWithStdin
+	}
+
+	containers, err := j.resolveContainers()
 	if err != nil {
 		return err
 	}
 
-	if err := j.startExec(ctx.Execution, exec); err != nil {
+	if len(containers) == 1 {
+		return j.runInContainer(ctx.Execution, containers[0])
+	}
+
+	var failed []string
+	var skipped int
+	for _, container := range containers {
+		err := j.runInContainer(ctx.Execution, container)
+		switch {
+		case IsSkipped(err):
+			skipped++
+		case err != nil:
+			failed = append(failed, fmt.Sprintf("%s: %s", container, err))
+		}
+	}
+
+	if len(failed) != 0 {
+		return fmt.Errorf(
+			"error on %d of %d containers: %s",
+			len(failed), len(containers), strings.Join(failed, "; "),
+		)
+	}
+
+	if skipped == len(containers) {
+		return ErrSkippedExecution
+	}
+
+	return nil
+}
+
+// runInContainer executes the command in the given container, recording the
+// result as a target of the execution.
+func (j *ExecJob) runInContainer(e *Execution, container string) error {
+	if err := j.waitForContainer(container); err != nil {
+		e.AddTarget(container, 0, err)
 		return err
 	}
 
+	exitCode, err := j.execInContainer(e, container)
+	if err == nil {
+		err = j.exitCodeError(exitCode)
+	}
+
+	if exitCode != 0 && e.ExitCode == 0 {
+		e.ExitCode = exitCode
+	}
+
+	e.AddTarget(container, exitCode, err)
+	return err
+}
+
+// waitForContainer waits up to the configured timeout for the container to be
+// running and healthy when required, otherwise the execution is skipped.
+func (j *ExecJob) waitForContainer(container string) error {
+	if !j.RequireRunning && !j.RequireHealthy {
+		return nil
+	}
+
+	var timeout time.Duration
+	if j.WaitTimeout != "" {
+		var err error
+		if timeout, err = time.ParseDuration(j.WaitTimeout); err != nil {
+			return fmt.Errorf("invalid wait-timeout %q: %s", j.WaitTimeout, err)
+		}
+	}
+
+	deadline := time.Now().Add(timeout)
+	for {
+		c, err := j.Client.InspectContainer(container)
+		if err != nil {
+			return fmt.Errorf("error inspecting container: %s", err)
+		}
+
+		reason := j.containerNotReady(c)
+		if reason == "" {
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			return &SkippedError{
+				Reason: fmt.Sprintf("container %q is %s", container, reason),
+			}
+		}
+
+		time.Sleep(containerWaitInterval)
+	}
+}
+
+// containerNotReady returns why the container isn't ready to exec into, empty
+// if it is. Containers without healthcheck are considered healthy.
+func (j *ExecJob) containerNotReady(c *docker.Container) string {
+	if !c.State.Running || c.State.Paused || c.State.Restarting {
+		return "not running (" + c.State.StateString() + ")"
+	}
+
+	if !j.RequireHealthy {
+		return ""
+	}
+
+	switch c.State.Health.Status {
+	case "", "healthy":
+		return ""
+	default:
+		return "not healthy (" + c.State.Health.Status + ")"
+	}
+}
+
+func (j *ExecJob) execInContainer(e *Execution, container string) (int, error) {
+	exec, err := j.buildExec(container)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := j.startExec(e, exec); err != nil {
+		return 0, err
+	}
+
 	return j.inspectExec(exec)
 }
 
-func (j *ExecJob) buildExec() (*docker.Exec, error) {
+// ParseExitCodes parses the success and skip exit codes, it must be called
+// before running the job, otherwise only the exit code 0 is a success.
+func (j *ExecJob) ParseExitCodes() error {
+	success, err := parseExitCodes(j.SuccessExitCodes)
+	if err != nil {
+		return fmt.Errorf("invalid success-exit-codes: %s", err)
+	}
+
+	skip, err := parseExitCodes(j.SkipExitCodes)
+	if err != nil {
+		return fmt.Errorf("invalid skip-exit-codes: %s", err)
+	}
+
+	j.successCodes, j.skipCodes = success, skip
+	return nil
+}
+
+// exitCodeError maps the exit code of the command to the result of the
+// execution, based on the configured success and skip exit codes.
+func (j *ExecJob) exitCodeError(exitCode int) error {
+	success := j.successCodes
+	if len(success) == 0 {
+		success = []int{0}
+	}
+
+	switch {
+	case containsInt(success, exitCode):
+		return nil
+	case containsInt(j.skipCodes, exitCode):
+		return ErrSkippedExecution
+	case exitCode == -1:
+		return ErrUnexpected
+	default:
+		return fmt.Errorf("error non-zero exit code: %d", exitCode)
+	}
+}
+
+// resolveContainers returns the containers where the command should be
+// executed, based on the container name or the selector and target strategy.
+func (j *ExecJob) resolveContainers() ([]string, error) {
+	if len(j.ContainerSelector) == 0 {
+		return []string{j.Container}, nil
+	}
+
+	names, err := findContainers(j.Client, j.ContainerSelector, false)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(names) == 0 {
+		return nil, ErrNoContainerFound
+	}
+
+	return selectTargets(names, j.Target)
+}
+
+// findContainers returns the sorted names of the containers matching the
+// label selector, only the running ones unless all is set.
+func findContainers(client *docker.Client, selector []string, all bool) ([]string, error) {
+	filters := map[string][]string{"label": selector}
+	if !all {
+		filters["status"] = []string{"running"}
+	}
+
+	containers, err := client.ListContainers(docker.ListContainersOptions{
+		All:     all,
+		Filters: filters,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error listing containers: %s", err)
+	}
+
+	var names []string
+	for _, c := range containers {
+		if len(c.Names) > 0 {
+			names = append(names, strings.TrimPrefix(c.Names[0], "/"))
+		} else {
+			names = append(names, c.ID)
+		}
+	}
+
+	sort.Strings(names)
+	return names, nil
+}
+
+// selectTargets applies the target strategy to the given containers
+func selectTargets(names []string, target string) ([]string, error) {
+	switch target {
+	case "", TargetOne:
+		return names[:1], nil
+	case TargetAll:
+		return names, nil
+	case TargetRandom:
+		return []string{names[rand.Intn(len(names))]}, nil
+	default:
+		return nil, fmt.Errorf("invalid target %q", target)
+	}
+}
+
+func (j *ExecJob) buildExec(container string) (*docker.Exec, error) {
 	exec, err := j.Client.CreateExec(docker.CreateExecOptions{
-		AttachStdin:  false,
+		AttachStdin:  j.hasStdin(),
 		AttachStdout: true,
 		AttachStderr: true,
 		Tty:          j.TTY,
-		Cmd:          args.GetArgs(j.Command),
-		Container:    j.Container,
+		Cmd:          j.buildCmd(),
+		Container:    container,
 		User:         j.User,
+		Env:          j.Environment,
+		WorkingDir:   j.WorkingDir,
+		Privileged:   j.Privileged,
 	})
 
 	if err != nil {
@@ -50,34 +307,70 @@ func (j *ExecJob) buildExec() (*docker.Exec, error) {
 	return exec, nil
 }
 
+// buildCmd returns the command to execute, the script interpreter if a script
+// is given, since the script is streamed via stdin
+func (j *ExecJob) buildCmd() []string {
+	if j.Script != "" {
+		return scriptInterpreter(j.Script)
+	}
+
+	return args.GetArgs(j.Command)
+}
+
+func (j *ExecJob) hasStdin() bool {
+	return j.Stdin != "" || j.StdinFile != "" || j.Script != ""
+}
+
+// buildStdin returns the reader piped into the command, if any
+func (j *ExecJob) buildStdin() (io.ReadCloser, error) {
+	switch {
+	case j.Script != "":
+		return ioutil.NopCloser(strings.NewReader(j.Script)), nil
+	case j.StdinFile != "":
+		f, err := os.Open(j.StdinFile)
+		if err != nil {
+			return nil, fmt.Errorf("error opening stdin file: %s", err)
+		}
+
+		return f, nil
+	case j.Stdin != "":
+		return ioutil.NopCloser(strings.NewReader(j.Stdin)), nil
+	default:
+		return nil, nil
+	}
+}
+
 func (j *ExecJob) startExec(e *Execution, exec *docker.Exec) error {
-	err := j.Client.StartExec(exec.ID, docker.StartExecOptions{
+	stdin, err := j.buildStdin()
+	if err != nil {
+		return err
+	}
+
+	opts := docker.StartExecOptions{
 		Tty:          j.TTY,
 		OutputStream: e.OutputStream,
 		ErrorStream:  e.ErrorStream,
 		RawTerminal:  j.TTY,
-	})
+	}
 
-	if err != nil {
+	if stdin != nil {
+		defer stdin.Close()
+		opts.InputStream = stdin
+	}
+
+	if err := j.Client.StartExec(exec.ID, opts); err != nil {
 		return fmt.Errorf("error starting exec: %s", err)
 	}
 
 	return nil
 }
 
-func (j *ExecJob) inspectExec(exec *docker.Exec) error {
+func (j *ExecJob) inspectExec(exec *docker.Exec) (int, error) {
 	i, err := j.Client.InspectExec(exec.ID)
 
 	if err != nil {
-		return fmt.Errorf("error inspecting exec: %s", err)
+		return 0, fmt.Errorf("error inspecting exec: %s", err)
 	}
 
-	switch i.ExitCode {
-	case 0:
-		return nil
-	case -1:
-		return ErrUnexpected
-	default:
-		return fmt.Errorf("error non-zero exit code: %d", i.ExitCode)
-	}
+	return i.ExitCode, nil
 }
@@ -40,6 +40,8 @@ func (s *SuiteExecJob) TestRun(c *C) {
 	job.Command = `echo -a "foo bar"`
 	job.User = "foo"
 	job.TTY = true
+	job.Privileged = true
+	job.Stdin = "foo"
 
 	e := NewExecution()
 
@@ -56,6 +58,121 @@ func (s *SuiteExecJob) TestRun(c *C) {
 	c.Assert(exec.ProcessConfig.Arguments, DeepEquals, []string{"-a", "foo bar"})
 	c.Assert(exec.ProcessConfig.User, Equals, "foo")
 	c.Assert(exec.ProcessConfig.Tty, Equals, true)
+	c.Assert(e.ExitCode, Equals, 0)
+	c.Assert(e.Targets, HasLen, 1)
+}
+
+func (s *SuiteExecJob) TestRunRequireRunning(c *C) {
+	job := &ExecJob{Client: s.client}
+	job.Container = ContainerFixture
+	job.Command = `echo foo`
+	job.RequireRunning = true
+
+	e := NewExecution()
+	err := job.Run(&Context{Execution: e})
+	c.Assert(IsSkipped(err), Equals, true)
+	c.Assert(err, ErrorMatches, `skipped execution: container "test-container" is not running \(created\)`)
+
+	e.Start()
+	e.Stop(err)
+	c.Assert(e.Skipped, Equals, true)
+	c.Assert(e.Failed, Equals, false)
+
+	c.Assert(s.client.StartContainer(ContainerFixture, nil), IsNil)
+	c.Assert(job.Run(&Context{Execution: NewExecution()}), IsNil)
+}
+
+func (s *SuiteExecJob) TestRunRequireInvalidTimeout(c *C) {
+	job := &ExecJob{Client: s.client}
+	job.Container = ContainerFixture
+	job.RequireHealthy = true
+	job.WaitTimeout = "foo"
+
+	err := job.Run(&Context{Execution: NewExecution()})
+	c.Assert(err, ErrorMatches, `invalid wait-timeout "foo".*`)
+}
+
+func (s *SuiteExecJob) TestRunScript(c *C) {
+	job := &ExecJob{Client: s.client}
+	job.Container = ContainerFixture
+	job.Script = "#!/bin/bash -e\necho foo\n"
+	job.TTY = true
+
+	c.Assert(job.Run(&Context{Execution: NewExecution()}), IsNil)
+
+	container, err := s.client.InspectContainer(ContainerFixture)
+	c.Assert(err, IsNil)
+
+	exec, err := s.client.InspectExec(container.ExecIDs[0])
+	c.Assert(err, IsNil)
+	c.Assert(exec.ProcessConfig.EntryPoint, Equals, "/bin/bash")
+	c.Assert(exec.ProcessConfig.Arguments, DeepEquals, []string{"-e"})
+
+	job.Stdin = "foo"
+	c.Assert(job.Run(&Context{Execution: NewExecution()}), Equals, ErrScriptWithStdin)
+}
+
+func (s *SuiteExecJob) TestExitCodeError(c *C) {
+	job := &ExecJob{}
+	c.Assert(job.exitCodeError(0), IsNil)
+	c.Assert(job.exitCodeError(-1), Equals, ErrUnexpected)
+	c.Assert(job.exitCodeError(3), ErrorMatches, "error non-zero exit code: 3")
+
+	job.SuccessExitCodes = "0, 3"
+	job.SkipExitCodes = "75"
+	c.Assert(job.ParseExitCodes(), IsNil)
+	c.Assert(job.exitCodeError(3), IsNil)
+	c.Assert(job.exitCodeError(75), Equals, ErrSkippedExecution)
+	c.Assert(job.exitCodeError(1), NotNil)
+
+	job.SkipExitCodes = "foo"
+	c.Assert(job.ParseExitCodes(), ErrorMatches, `invalid skip-exit-codes: invalid exit code "foo"`)
+	c.Assert(job.exitCodeError(75), Equals, ErrSkippedExecution)
+}
+
+func (s *SuiteExecJob) TestRunContainerSelector(c *C) {
+	for _, name := range []string{"app-2", "app-1", "other"} {
+		service := "web"
+		if name == "other" {
+			service = "db"
+		}
+
+		container, err := s.client.CreateContainer(docker.CreateContainerOptions{
+			Name: name,
+			Config: &docker.Config{
+				Image:  "test",
+				Labels: map[string]string{"com.docker.compose.service": service},
+			},
+		})
+		c.Assert(err, IsNil)
+		c.Assert(s.client.StartContainer(container.ID, nil), IsNil)
+	}
+
+	job := &ExecJob{Client: s.client}
+	job.ContainerSelector = []string{"com.docker.compose.service=web"}
+	job.Command = `echo foo`
+
+	job.Target = TargetAll
+	e := NewExecution()
+	c.Assert(job.Run(&Context{Execution: e}), IsNil)
+	c.Assert(e.Targets, HasLen, 2)
+	c.Assert(e.Targets[0].Name, Equals, "app-1")
+	c.Assert(e.Targets[1].Name, Equals, "app-2")
+
+	job.Target = TargetOne
+	e = NewExecution()
+	c.Assert(job.Run(&Context{Execution: e}), IsNil)
+	c.Assert(e.Targets, HasLen, 1)
+	c.Assert(e.Targets[0].Name, Equals, "app-1")
+
+	for name, count := range map[string]int{"app-1": 2, "app-2": 1, "other": 0} {
+		container, err := s.client.InspectContainer(name)
+		c.Assert(err, IsNil)
+		c.Assert(container.ExecIDs, HasLen, count)
+	}
+
+	job.ContainerSelector = []string{"com.docker.compose.service=foo"}
+	c.Assert(job.Run(&Context{Execution: NewExecution()}), Equals, ErrNoContainerFound)
 }
 
 func (s *SuiteExecJob) buildContainer(c *C) {
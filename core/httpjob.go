@@ -0,0 +1,203 @@
+package core
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type HTTPJob struct {
+	BareJob        `mapstructure:",squash"`
+	URL            string
+	Method         string   `default:"GET"`
+	Headers        []string `json:"-"`
+	Body           string
+	Timeout        string `default:"30s"`
+	ExpectedStatus string `gcfg:"expected-status" mapstructure:"expected-status" default:"2xx"`
+	Username       string
+	Password       string `json:"-"`
+	BearerToken    string `gcfg:"bearer-token" mapstructure:"bearer-token" json:"-"`
+	TLSSkipVerify  bool   `gcfg:"tls-skip-verify" mapstructure:"tls-skip-verify"`
+	TLSCACert      string `gcfg:"tls-ca-cert" mapstructure:"tls-ca-cert"`
+	TLSCert        string `gcfg:"tls-cert" mapstructure:"tls-cert"`
+	TLSKey         string `gcfg:"tls-key" mapstructure:"tls-key"`
+}
+
+func NewHTTPJob() *HTTPJob {
+	return &HTTPJob{}
+}
+
+// GetCommand returns the method and URL called, used to describe the job
+func (j *HTTPJob) GetCommand() string {
+	method := j.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+
+	return strings.ToUpper(method) + " " + j.URL
+}
+
+func (j *HTTPJob) Run(ctx *Context) error {
+	client, err := j.buildClient()
+	if err != nil {
+		return err
+	}
+
+	req, err := j.buildRequest()
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("error calling %q: %s", j.URL, err)
+	}
+
+	defer resp.Body.Close()
+	if _, err := io.Copy(ctx.Execution.OutputStream, resp.Body); err != nil {
+		return fmt.Errorf("error reading response: %s", err)
+	}
+
+	ok, err := matchStatus(j.ExpectedStatus, resp.StatusCode)
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		return fmt.Errorf("error unexpected status code: %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+func (j *HTTPJob) buildRequest() (*http.Request, error) {
+	method := j.Method
+	if method == "" {
+		method = http.MethodGet
+	}
+
+	var body io.Reader
+	if j.Body != "" {
+		body = strings.NewReader(j.Body)
+	}
+
+	req, err := http.NewRequest(strings.ToUpper(method), j.URL, body)
+	if err != nil {
+		return nil, fmt.Errorf("error building request: %s", err)
+	}
+
+	for _, h := range j.Headers {
+		parts := strings.SplitN(h, ":", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("invalid header %q", h)
+		}
+
+		req.Header.Add(strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1]))
+	}
+
+	switch {
+	case j.BearerToken != "":
+		req.Header.Set("Authorization", "Bearer "+j.BearerToken)
+	case j.Username != "":
+		req.SetBasicAuth(j.Username, j.Password)
+	}
+
+	return req, nil
+}
+
+func (j *HTTPJob) buildClient() (*http.Client, error) {
+	client := &http.Client{}
+	if j.Timeout != "" {
+		timeout, err := time.ParseDuration(j.Timeout)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timeout %q: %s", j.Timeout, err)
+		}
+
+		client.Timeout = timeout
+	}
+
+	config, err := j.buildTLSConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	if config != nil {
+		transport := http.DefaultTransport.(*http.Transport).Clone()
+		transport.TLSClientConfig = config
+		client.Transport = transport
+	}
+
+	return client, nil
+}
+
+// buildTLSConfig returns nil if no TLS option is given
+func (j *HTTPJob) buildTLSConfig() (*tls.Config, error) {
+	if !j.TLSSkipVerify && j.TLSCACert == "" && j.TLSCert == "" {
+		return nil, nil
+	}
+
+	config := &tls.Config{InsecureSkipVerify: j.TLSSkipVerify}
+	if j.TLSCACert != "" {
+		pem, err := ioutil.ReadFile(j.TLSCACert)
+		if err != nil {
+			return nil, fmt.Errorf("error reading tls-ca-cert: %s", err)
+		}
+
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("invalid tls-ca-cert %q", j.TLSCACert)
+		}
+	}
+
+	if j.TLSCert != "" {
+		cert, err := tls.LoadX509KeyPair(j.TLSCert, j.TLSKey)
+		if err != nil {
+			return nil, fmt.Errorf("error loading tls-cert: %s", err)
+		}
+
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	return config, nil
+}
+
+// matchStatus returns true if the status code is in the comma separated list
+// of expected status codes, a class of codes can be given as e.g. `2xx`.
+func matchStatus(expected string, code int) (bool, error) {
+	if strings.TrimSpace(expected) == "" {
+		expected = "2xx"
+	}
+
+	for _, e := range strings.Split(expected, ",") {
+		e = strings.ToLower(strings.TrimSpace(e))
+		if len(e) == 3 && strings.HasSuffix(e, "xx") {
+			class, err := strconv.Atoi(e[:1])
+			if err != nil {
+				return false, fmt.Errorf("invalid expected status %q", e)
+			}
+
+			if code/100 == class {
+				return true, nil
+			}
+
+			continue
+		}
+
+		s, err := strconv.Atoi(e)
+		if err != nil {
+			return false, fmt.Errorf("invalid expected status %q", e)
+		}
+
+		if s == code {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
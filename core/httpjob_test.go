@@ -0,0 +1,90 @@
+package core
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+
+	. "gopkg.in/check.v1"
+)
+
+type SuiteHTTPJob struct{}
+
+var _ = Suite(&SuiteHTTPJob{})
+
+func (s *SuiteHTTPJob) TestRun(c *C) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		c.Assert(r.Method, Equals, "POST")
+		c.Assert(r.Header.Get("X-Foo"), Equals, "bar")
+		c.Assert(r.Header.Get("Authorization"), Equals, "Bearer qux")
+		c.Assert(string(body), Equals, "foo")
+
+		w.WriteHeader(http.StatusAccepted)
+		w.Write([]byte("bar"))
+	}))
+	defer ts.Close()
+
+	job := &HTTPJob{}
+	job.URL = ts.URL
+	job.Method = "post"
+	job.Headers = []string{"X-Foo: bar"}
+	job.Body = "foo"
+	job.BearerToken = "qux"
+
+	e := NewExecution()
+	c.Assert(job.Run(&Context{Execution: e}), IsNil)
+	c.Assert(e.OutputStream.String(), Equals, "bar")
+	c.Assert(job.GetCommand(), Equals, "POST "+ts.URL)
+}
+
+func (s *SuiteHTTPJob) TestRunUnexpectedStatus(c *C) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, password, _ := r.BasicAuth()
+		c.Assert(user, Equals, "foo")
+		c.Assert(password, Equals, "bar")
+
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("error"))
+	}))
+	defer ts.Close()
+
+	job := &HTTPJob{}
+	job.URL = ts.URL
+	job.Username = "foo"
+	job.Password = "bar"
+
+	e := NewExecution()
+	c.Assert(job.Run(&Context{Execution: e}), ErrorMatches, "error unexpected status code: 500")
+	c.Assert(e.OutputStream.String(), Equals, "error")
+
+	job.ExpectedStatus = "200,500"
+	c.Assert(job.Run(&Context{Execution: NewExecution()}), IsNil)
+}
+
+func (s *SuiteHTTPJob) TestRunTLSSkipVerify(c *C) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer ts.Close()
+
+	job := &HTTPJob{}
+	job.URL = ts.URL
+	c.Assert(job.Run(&Context{Execution: NewExecution()}), NotNil)
+
+	job.TLSSkipVerify = true
+	c.Assert(job.Run(&Context{Execution: NewExecution()}), IsNil)
+}
+
+func (s *SuiteHTTPJob) TestMatchStatus(c *C) {
+	ok, err := matchStatus("", 204)
+	c.Assert(err, IsNil)
+	c.Assert(ok, Equals, true)
+
+	ok, _ = matchStatus("2xx, 404", 404)
+	c.Assert(ok, Equals, true)
+
+	ok, _ = matchStatus("200", 201)
+	c.Assert(ok, Equals, false)
+
+	_, err = matchStatus("foo", 200)
+	c.Assert(err, ErrorMatches, `invalid expected status "foo"`)
+}
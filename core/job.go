@@ -11,6 +11,11 @@ type BareJob struct {
 	Label    string
 	Command  string
 	RunOnStart bool
+	// LogLevel overrides the level of the daemon for the lines of the job
+	LogLevel string `gcfg:"log-level" mapstructure:"log-level"`
+	// MaxOutputSize is the number of bytes kept of each output, 10MB if zero,
+	// all of them if negative
+	MaxOutputSize int64 `gcfg:"max-output-size" mapstructure:"max-output-size"`
 
 	middlewareContainer
 	running int32
@@ -34,6 +39,14 @@ func (j *BareJob) GetCommand() string {
 	return j.Command
 }
 
+func (j *BareJob) GetLogLevel() string {
+	return j.LogLevel
+}
+
+func (j *BareJob) GetMaxOutputSize() int64 {
+	return j.MaxOutputSize
+}
+
 func (j *BareJob) Running() int32 {
 	return atomic.LoadInt32(&j.running)
 }
@@ -45,3 +58,7 @@ func (j *BareJob) NotifyStart() {
 func (j *BareJob) NotifyStop() {
 	atomic.AddInt32(&j.running, -1)
 }
+
+func (j *BareJob) GetLabel() string {
+	return j.Label
+}
@@ -1,15 +1,44 @@
 package core
 
 import (
+	"fmt"
+	"io/ioutil"
+	"os"
 	"os/exec"
+	"os/user"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	units "github.com/docker/go-units"
 	"github.com/gobs/args"
 )
 
 type LocalJob struct {
 	BareJob     `mapstructure:",squash"`
 	Dir         string
+	Script      string
 	Environment []string
+	InheritEnv  bool `gcfg:"inherit-env" mapstructure:"inherit-env"`
+	Shell       string
+	User        string
+	Group       string
+	Umask       string
+	Timeout     string
+
+	Nice         int
+	IONice       string `gcfg:"ionice" mapstructure:"ionice"`
+	RlimitNofile string `gcfg:"rlimit-nofile" mapstructure:"rlimit-nofile"`
+	RlimitAS     string `gcfg:"rlimit-as" mapstructure:"rlimit-as"`
+	RlimitCPU    string `gcfg:"rlimit-cpu" mapstructure:"rlimit-cpu"`
+	MemoryMax    string `gcfg:"memory-max" mapstructure:"memory-max"`
+	CPUMax       string `gcfg:"cpu-max" mapstructure:"cpu-max"`
+
+	procLock   sync.Mutex
+	processes  map[*exec.Cmd]bool
+	terminated bool
+	killed     bool
 }
 
 func NewLocalJob() *LocalJob {
@@ -17,27 +46,310 @@ func NewLocalJob() *LocalJob {
 }
 
 func (j *LocalJob) Run(ctx *Context) error {
-	cmd, err := j.buildCommand(ctx)
+	timeout, err := j.timeout()
+	if err != nil {
+		return err
+	}
+
+	cgroup, err := j.createCgroup(ctx.Execution)
+	if err != nil {
+		return err
+	}
+
+	if cgroup != "" {
+		defer removeCgroup(ctx, cgroup)
+	}
+
+	script, err := j.writeScript()
+	if err != nil {
+		return err
+	}
+
+	if script != "" {
+		defer os.Remove(script)
+	}
+
+	cmd, err := j.buildCommand(ctx, script)
+	if err != nil {
+		return err
+	}
+
+	var dir *os.File
+	if cgroup != "" {
+		if dir, err = useCgroup(cmd, cgroup); err != nil {
+			return err
+		}
+	}
+
+	err = cmd.Start()
+	if dir != nil {
+		dir.Close()
+	}
+
 	if err != nil {
 		return err
 	}
 
-	return cmd.Run()
+	defer j.track(cmd)()
+
+	if err := j.setPriority(cmd.Process.Pid); err != nil {
+		killProcessGroup(cmd)
+		cmd.Wait()
+		return err
+	}
+
+	var timedOut int32
+	if timeout != 0 {
+		timer := time.AfterFunc(timeout, func() {
+			atomic.StoreInt32(&timedOut, 1)
+			killProcessGroup(cmd)
+		})
+		defer timer.Stop()
+	}
+
+	err = cmd.Wait()
+	if cmd.ProcessState != nil {
+		ctx.Execution.ExitCode = cmd.ProcessState.ExitCode()
+		ctx.Execution.Usage = buildResourceUsage(cmd.ProcessState)
+	}
+
+	if atomic.LoadInt32(&timedOut) == 1 {
+		return ErrMaxTimeRunning
+	}
+
+	return err
+}
+
+func (j *LocalJob) timeout() (time.Duration, error) {
+	if j.Timeout == "" {
+		return 0, nil
+	}
+
+	t, err := time.ParseDuration(j.Timeout)
+	if err != nil {
+		return 0, fmt.Errorf("invalid timeout %q: %s", j.Timeout, err)
+	}
+
+	return t, nil
+}
+
+// writeScript writes the script, if any, to a temporary file readable by the
+// user running the job, returning its path.
+func (j *LocalJob) writeScript() (string, error) {
+	if j.Script == "" {
+		return "", nil
+	}
+
+	f, err := ioutil.TempFile("", "ofelia-script-")
+	if err != nil {
+		return "", fmt.Errorf("error creating script file: %s", err)
+	}
+
+	defer f.Close()
+	if _, err := f.WriteString(j.Script); err != nil {
+		os.Remove(f.Name())
+		return "", fmt.Errorf("error writing script file: %s", err)
+	}
+
+	if err := j.chownScript(f); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+
+	return f.Name(), nil
 }
 
-func (j *LocalJob) buildCommand(ctx *Context) (*exec.Cmd, error) {
-	args := args.GetArgs(j.Command)
+func (j *LocalJob) buildCommand(ctx *Context, script string) (*exec.Cmd, error) {
+	args, err := j.buildArgs(script)
+	if err != nil {
+		return nil, err
+	}
+
 	bin, err := exec.LookPath(args[0])
 	if err != nil {
 		return nil, err
 	}
 
+	attr, err := j.buildSysProcAttr()
+	if err != nil {
+		return nil, err
+	}
+
 	return &exec.Cmd{
-		Path:   bin,
-		Args:   args,
-		Stdout: ctx.Execution.OutputStream,
-		Stderr: ctx.Execution.ErrorStream,
-		Env:    j.Environment,
-		Dir:    j.Dir,
+		Path:        bin,
+		Args:        args,
+		Stdout:      ctx.Execution.OutputStream,
+		Stderr:      ctx.Execution.ErrorStream,
+		Env:         j.buildEnv(),
+		Dir:         j.Dir,
+		SysProcAttr: attr,
 	}, nil
 }
+
+// buildArgs returns the arguments of the process, running the script file
+// with its interpreter, or the command with the configured shell if any. The
+// umask and rlimits can't be set for a single child process, so in that case
+// the command is wrapped by a shell setting them before exec'ing it, on unix.
+func (j *LocalJob) buildArgs(script string) ([]string, error) {
+	var cmd []string
+	if script != "" {
+		cmd = append(append([]string{}, scriptInterpreter(j.Script)...), script)
+	} else if j.Shell != "" {
+		cmd = []string{j.Shell, "-c", j.Command}
+	} else {
+		cmd = args.GetArgs(j.Command)
+	}
+
+	if len(cmd) == 0 {
+		return nil, fmt.Errorf("empty command")
+	}
+
+	preamble, err := j.buildPreamble()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(preamble) == 0 {
+		return cmd, nil
+	}
+
+	return wrapArgs(preamble, cmd)
+}
+
+func (j *LocalJob) buildPreamble() ([]string, error) {
+	var preamble []string
+	if j.Umask != "" {
+		if _, err := strconv.ParseUint(j.Umask, 8, 32); err != nil {
+			return nil, fmt.Errorf("invalid umask %q", j.Umask)
+		}
+
+		preamble = append(preamble, "umask "+j.Umask)
+	}
+
+	if j.RlimitNofile != "" {
+		n, err := strconv.ParseUint(j.RlimitNofile, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid rlimit-nofile %q", j.RlimitNofile)
+		}
+
+		preamble = append(preamble, fmt.Sprintf("ulimit -n %d", n))
+	}
+
+	if j.RlimitAS != "" {
+		n, err := units.RAMInBytes(j.RlimitAS)
+		if err != nil {
+			return nil, fmt.Errorf("invalid rlimit-as %q: %s", j.RlimitAS, err)
+		}
+
+		preamble = append(preamble, fmt.Sprintf("ulimit -v %d", n/1024))
+	}
+
+	if j.RlimitCPU != "" {
+		d, err := parseSeconds(j.RlimitCPU)
+		if err != nil {
+			return nil, fmt.Errorf("invalid rlimit-cpu %q: %s", j.RlimitCPU, err)
+		}
+
+		preamble = append(preamble, fmt.Sprintf("ulimit -t %d", int64(d.Seconds())))
+	}
+
+	return preamble, nil
+}
+
+// parseSeconds parses a duration, a plain number is considered seconds
+func parseSeconds(s string) (time.Duration, error) {
+	if n, err := strconv.ParseUint(s, 10, 64); err == nil {
+		return time.Duration(n) * time.Second, nil
+	}
+
+	return time.ParseDuration(s)
+}
+
+func (j *LocalJob) buildEnv() []string {
+	if !j.InheritEnv {
+		return j.Environment
+	}
+
+	return append(os.Environ(), j.Environment...)
+}
+
+func lookupUser(name string) (*user.User, error) {
+	if _, err := strconv.Atoi(name); err == nil {
+		return user.LookupId(name)
+	}
+
+	return user.Lookup(name)
+}
+
+func lookupGroup(name string) (*user.Group, error) {
+	if _, err := strconv.Atoi(name); err == nil {
+		return user.LookupGroupId(name)
+	}
+
+	return user.LookupGroup(name)
+}
+
+// track records the running process, so it's stopped by Terminate and Kill,
+// returning the function to call once it's finished
+func (j *LocalJob) track(cmd *exec.Cmd) func() {
+	j.procLock.Lock()
+	defer j.procLock.Unlock()
+
+	switch {
+	case j.killed:
+		killProcessGroup(cmd)
+	case j.terminated:
+		terminateProcessGroup(cmd)
+	}
+
+	if j.processes == nil {
+		j.processes = make(map[*exec.Cmd]bool)
+	}
+
+	j.processes[cmd] = true
+	return func() {
+		j.procLock.Lock()
+		defer j.procLock.Unlock()
+
+		delete(j.processes, cmd)
+	}
+}
+
+func (j *LocalJob) runningProcesses() int {
+	j.procLock.Lock()
+	defer j.procLock.Unlock()
+
+	return len(j.processes)
+}
+
+// Terminate sends SIGTERM to the process trees of the running executions, and
+// of the ones starting afterwards
+func (j *LocalJob) Terminate() {
+	j.procLock.Lock()
+	defer j.procLock.Unlock()
+
+	j.terminated = true
+	for cmd := range j.processes {
+		terminateProcessGroup(cmd)
+	}
+}
+
+// Kill kills the process trees of the running executions, and of the ones
+// starting afterwards
+func (j *LocalJob) Kill() {
+	j.procLock.Lock()
+	defer j.procLock.Unlock()
+
+	j.killed = true
+	for cmd := range j.processes {
+		killProcessGroup(cmd)
+	}
+}
+
+func buildResourceUsage(ps *os.ProcessState) *ResourceUsage {
+	return &ResourceUsage{
+		UserTime:   ps.UserTime(),
+		SystemTime: ps.SystemTime(),
+		MaxRSS:     maxRSS(ps),
+	}
+}
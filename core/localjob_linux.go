@@ -0,0 +1,197 @@
+package core
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	units "github.com/docker/go-units"
+)
+
+// cgroupRoot is where the cgroup v2 hierarchy is mounted
+var cgroupRoot = "/sys/fs/cgroup"
+
+const cgroupParent = "ofelia"
+
+var cgroupNameCleaner = regexp.MustCompile(`[^a-zA-Z0-9_.-]`)
+
+// createCgroup creates a dedicated cgroup for the execution if any cgroup
+// limit is configured, returning its path.
+func (j *LocalJob) createCgroup(e *Execution) (string, error) {
+	if j.MemoryMax == "" && j.CPUMax == "" {
+		return "", nil
+	}
+
+	if _, err := os.Stat(filepath.Join(cgroupRoot, "cgroup.controllers")); err != nil {
+		return "", fmt.Errorf("cgroup v2 is not available at %s", cgroupRoot)
+	}
+
+	// the controllers are only enabled under the parent of ofelia, the ones of
+	// the root are left to the system
+	parent := filepath.Join(cgroupRoot, cgroupParent)
+	if err := os.MkdirAll(parent, 0755); err != nil {
+		return "", fmt.Errorf("error creating cgroup: %s", err)
+	}
+
+	if err := writeCgroupFile(parent, "cgroup.subtree_control", j.cgroupControllers()); err != nil {
+		return "", fmt.Errorf("error enabling cgroup controllers, they must be enabled in %s: %s", cgroupRoot, err)
+	}
+
+	name := cgroupNameCleaner.ReplaceAllString(j.Name, "_")
+	dir := filepath.Join(parent, fmt.Sprintf("%s_%s", name, e.ID))
+	if err := os.Mkdir(dir, 0755); err != nil {
+		return "", fmt.Errorf("error creating cgroup: %s", err)
+	}
+
+	if err := j.setCgroupLimits(dir); err != nil {
+		os.Remove(dir)
+		return "", err
+	}
+
+	return dir, nil
+}
+
+// cgroupControllers returns the controllers required by the limits
+func (j *LocalJob) cgroupControllers() string {
+	var controllers []string
+	if j.MemoryMax != "" {
+		controllers = append(controllers, "+memory")
+	}
+
+	if j.CPUMax != "" {
+		controllers = append(controllers, "+cpu")
+	}
+
+	return strings.Join(controllers, " ")
+}
+
+// useCgroup makes the command start directly in the cgroup, so neither the
+// process nor its children ever run outside of it, returning the cgroup
+// directory to close once the command is started
+func useCgroup(cmd *exec.Cmd, dir string) (*os.File, error) {
+	f, err := os.Open(dir)
+	if err != nil {
+		return nil, fmt.Errorf("error opening cgroup: %s", err)
+	}
+
+	cmd.SysProcAttr.UseCgroupFD = true
+	cmd.SysProcAttr.CgroupFD = int(f.Fd())
+	return f, nil
+}
+
+func (j *LocalJob) setCgroupLimits(dir string) error {
+	if j.MemoryMax != "" {
+		n, err := units.RAMInBytes(j.MemoryMax)
+		if err != nil {
+			return fmt.Errorf("invalid memory-max %q: %s", j.MemoryMax, err)
+		}
+
+		if err := writeCgroupFile(dir, "memory.max", strconv.FormatInt(n, 10)); err != nil {
+			return err
+		}
+	}
+
+	if j.CPUMax != "" {
+		cpus, err := strconv.ParseFloat(j.CPUMax, 64)
+		if err != nil || cpus <= 0 {
+			return fmt.Errorf("invalid cpu-max %q", j.CPUMax)
+		}
+
+		const period = 100000
+		value := fmt.Sprintf("%d %d", int64(cpus*period), period)
+		if err := writeCgroupFile(dir, "cpu.max", value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func writeCgroupFile(dir, file, value string) error {
+	if err := ioutil.WriteFile(filepath.Join(dir, file), []byte(value), 0644); err != nil {
+		return fmt.Errorf("error writing cgroup file %s: %s", file, err)
+	}
+
+	return nil
+}
+
+// removeCgroup kills any process left in the cgroup and removes it
+func removeCgroup(ctx *Context, dir string) {
+	writeCgroupFile(dir, "cgroup.kill", "1")
+
+	var err error
+	for i := 0; i < 10; i++ {
+		if err = os.Remove(dir); err == nil {
+			return
+		}
+
+		time.Sleep(watchDuration)
+	}
+
+	if ctx.Logger != nil {
+		ctx.Logger.Warningf("Unable to remove cgroup %s: %s", dir, err)
+	}
+}
+
+const (
+	ioprioWhoPgrp      = 2
+	ioprioClassShift   = 13
+	ioprioClassBestEff = 2
+	ioprioClassIdle    = 3
+)
+
+// setPriority sets the scheduling and I/O priority of the process group
+func (j *LocalJob) setPriority(pid int) error {
+	if j.Nice != 0 {
+		if err := syscall.Setpriority(syscall.PRIO_PGRP, pid, j.Nice); err != nil {
+			return fmt.Errorf("error setting nice: %s", err)
+		}
+	}
+
+	if j.IONice == "" {
+		return nil
+	}
+
+	prio, err := parseIONice(j.IONice)
+	if err != nil {
+		return err
+	}
+
+	_, _, errno := syscall.Syscall(syscall.SYS_IOPRIO_SET, ioprioWhoPgrp, uintptr(pid), uintptr(prio))
+	if errno != 0 {
+		return fmt.Errorf("error setting ionice: %s", errno)
+	}
+
+	return nil
+}
+
+// parseIONice parses `idle` or a best-effort level from 0 to 7
+func parseIONice(value string) (int, error) {
+	if strings.TrimSpace(value) == "idle" {
+		return ioprioClassIdle << ioprioClassShift, nil
+	}
+
+	level, err := strconv.Atoi(value)
+	if err != nil || level < 0 || level > 7 {
+		return 0, fmt.Errorf("invalid ionice %q", value)
+	}
+
+	return ioprioClassBestEff<<ioprioClassShift | level, nil
+}
+
+// maxRSS returns the maximum resident set size in bytes, linux reports it in
+// kilobytes
+func maxRSS(ps *os.ProcessState) int64 {
+	if u, ok := ps.SysUsage().(*syscall.Rusage); ok {
+		return u.Maxrss * 1024
+	}
+
+	return 0
+}
@@ -0,0 +1,98 @@
+package core
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"syscall"
+
+	. "gopkg.in/check.v1"
+)
+
+func (s *SuiteLocalJob) TestCreateCgroup(c *C) {
+	root, err := ioutil.TempDir("", "cgroup")
+	c.Assert(err, IsNil)
+	defer os.RemoveAll(root)
+
+	c.Assert(ioutil.WriteFile(filepath.Join(root, "cgroup.controllers"), nil, 0644), IsNil)
+
+	defer func(r string) { cgroupRoot = r }(cgroupRoot)
+	cgroupRoot = root
+
+	job := &LocalJob{}
+	job.Name = "foo bar"
+	job.Command = "echo foo"
+	job.MemoryMax = "64m"
+	job.CPUMax = "0.5"
+
+	e := NewExecution()
+	dir, err := job.createCgroup(e)
+	c.Assert(err, IsNil)
+	c.Assert(dir, Equals, filepath.Join(root, "ofelia", "foo_bar_"+e.ID))
+
+	memory, _ := ioutil.ReadFile(filepath.Join(dir, "memory.max"))
+	c.Assert(string(memory), Equals, "67108864")
+
+	cpu, _ := ioutil.ReadFile(filepath.Join(dir, "cpu.max"))
+	c.Assert(string(cpu), Equals, "50000 100000")
+
+	controllers, _ := ioutil.ReadFile(filepath.Join(root, "ofelia", "cgroup.subtree_control"))
+	c.Assert(string(controllers), Equals, "+memory +cpu")
+
+	_, err = os.Stat(filepath.Join(root, "cgroup.subtree_control"))
+	c.Assert(os.IsNotExist(err), Equals, true)
+
+	cmd := &exec.Cmd{SysProcAttr: &syscall.SysProcAttr{}}
+	f, err := useCgroup(cmd, dir)
+	c.Assert(err, IsNil)
+	defer f.Close()
+	c.Assert(cmd.SysProcAttr.UseCgroupFD, Equals, true)
+	c.Assert(cmd.SysProcAttr.CgroupFD, Equals, int(f.Fd()))
+
+	args, err := job.buildArgs("")
+	c.Assert(err, IsNil)
+	c.Assert(args, DeepEquals, []string{"echo", "foo"})
+}
+
+func (s *SuiteLocalJob) TestCreateCgroupControllersError(c *C) {
+	root, err := ioutil.TempDir("", "cgroup")
+	c.Assert(err, IsNil)
+	defer os.RemoveAll(root)
+
+	c.Assert(ioutil.WriteFile(filepath.Join(root, "cgroup.controllers"), nil, 0644), IsNil)
+	c.Assert(os.MkdirAll(filepath.Join(root, "ofelia", "cgroup.subtree_control"), 0755), IsNil)
+
+	defer func(r string) { cgroupRoot = r }(cgroupRoot)
+	cgroupRoot = root
+
+	job := &LocalJob{}
+	job.MemoryMax = "64m"
+
+	_, err = job.createCgroup(NewExecution())
+	c.Assert(err, ErrorMatches, "error enabling cgroup controllers.*")
+}
+
+func (s *SuiteLocalJob) TestCreateCgroupUnavailable(c *C) {
+	defer func(r string) { cgroupRoot = r }(cgroupRoot)
+	cgroupRoot = "/non-existent"
+
+	job := &LocalJob{}
+	job.MemoryMax = "64m"
+
+	_, err := job.createCgroup(NewExecution())
+	c.Assert(err, ErrorMatches, "cgroup v2 is not available.*")
+}
+
+func (s *SuiteLocalJob) TestParseIONice(c *C) {
+	p, err := parseIONice("idle")
+	c.Assert(err, IsNil)
+	c.Assert(p, Equals, 3<<13)
+
+	p, err = parseIONice("7")
+	c.Assert(err, IsNil)
+	c.Assert(p, Equals, 2<<13|7)
+
+	_, err = parseIONice("8")
+	c.Assert(err, NotNil)
+}
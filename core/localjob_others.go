@@ -0,0 +1,46 @@
+//go:build !linux && !windows
+// +build !linux,!windows
+
+package core
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+var errLinuxOnly = errors.New("ionice, memory-max and cpu-max are only supported on linux")
+
+func (j *LocalJob) createCgroup(e *Execution) (string, error) {
+	if j.MemoryMax != "" || j.CPUMax != "" {
+		return "", errLinuxOnly
+	}
+
+	return "", nil
+}
+
+func useCgroup(cmd *exec.Cmd, dir string) (*os.File, error) { return nil, nil }
+
+func removeCgroup(ctx *Context, dir string) {}
+
+func (j *LocalJob) setPriority(pid int) error {
+	if j.IONice != "" {
+		return errLinuxOnly
+	}
+
+	if j.Nice != 0 {
+		return syscall.Setpriority(syscall.PRIO_PGRP, pid, j.Nice)
+	}
+
+	return nil
+}
+
+// maxRSS returns the maximum resident set size in bytes
+func maxRSS(ps *os.ProcessState) int64 {
+	if u, ok := ps.SysUsage().(*syscall.Rusage); ok {
+		return u.Maxrss
+	}
+
+	return 0
+}
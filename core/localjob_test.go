@@ -1,7 +1,10 @@
 package core
 
 import (
-	"github.com/armon/circbuf"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
 
 	. "gopkg.in/check.v1"
 )
@@ -14,7 +17,7 @@ func (s *SuiteLocalJob) TestRun(c *C) {
 	job := &LocalJob{}
 	job.Command = `echo "foo bar"`
 
-	b, _ := circbuf.NewBuffer(1000)
+	b := NewStream(1000)
 	e := NewExecution()
 	e.OutputStream = b
 
@@ -22,3 +25,141 @@ func (s *SuiteLocalJob) TestRun(c *C) {
 	c.Assert(err, IsNil)
 	c.Assert(b.String(), Equals, "foo bar\n")
 }
+
+func (s *SuiteLocalJob) TestRunShell(c *C) {
+	job := &LocalJob{}
+	job.Command = `echo foo | tr a-z A-Z && echo bar >&2`
+	job.Shell = "/bin/sh"
+	job.Umask = "077"
+
+	e := NewExecution()
+	err := job.Run(&Context{Execution: e})
+	c.Assert(err, IsNil)
+	c.Assert(e.OutputStream.String(), Equals, "FOO\n")
+	c.Assert(e.ErrorStream.String(), Equals, "bar\n")
+}
+
+func (s *SuiteLocalJob) TestRunInheritEnv(c *C) {
+	os.Setenv("OFELIA_TEST_ENV", "bar")
+	defer os.Unsetenv("OFELIA_TEST_ENV")
+
+	job := &LocalJob{}
+	job.Command = `sh -c 'echo $FOO $OFELIA_TEST_ENV'`
+	job.Environment = []string{"FOO=foo"}
+
+	e := NewExecution()
+	c.Assert(job.Run(&Context{Execution: e}), IsNil)
+	c.Assert(e.OutputStream.String(), Equals, "foo\n")
+
+	job.InheritEnv = true
+	e = NewExecution()
+	c.Assert(job.Run(&Context{Execution: e}), IsNil)
+	c.Assert(e.OutputStream.String(), Equals, "foo bar\n")
+}
+
+func (s *SuiteLocalJob) TestRunExitCode(c *C) {
+	job := &LocalJob{}
+	job.Command = `exit 3`
+	job.Shell = "/bin/sh"
+
+	e := NewExecution()
+	c.Assert(job.Run(&Context{Execution: e}), NotNil)
+	c.Assert(e.ExitCode, Equals, 3)
+}
+
+func (s *SuiteLocalJob) TestRunTimeout(c *C) {
+	job := &LocalJob{}
+	job.Command = `sleep 10 & sleep 10; echo foo`
+	job.Shell = "/bin/sh"
+	job.Timeout = "100ms"
+
+	e := NewExecution()
+	start := time.Now()
+	c.Assert(job.Run(&Context{Execution: e}), Equals, ErrMaxTimeRunning)
+	c.Assert(time.Since(start) < time.Second*5, Equals, true)
+	c.Assert(e.OutputStream.String(), Equals, "")
+}
+
+func (s *SuiteLocalJob) TestKill(c *C) {
+	job := &LocalJob{}
+	job.Command = `sleep 10 & sleep 10; echo foo`
+	job.Shell = "/bin/sh"
+
+	done := make(chan error)
+	e := NewExecution()
+	start := time.Now()
+	go func() { done <- job.Run(&Context{Execution: e}) }()
+
+	for job.runningProcesses() == 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	job.Kill()
+	c.Assert(<-done, NotNil)
+	c.Assert(time.Since(start) < time.Second*5, Equals, true)
+	c.Assert(job.runningProcesses(), Equals, 0)
+}
+
+func (s *SuiteLocalJob) TestTerminate(c *C) {
+	job := &LocalJob{}
+	job.Command = `trap 'echo foo; exit 3' TERM; sleep 10 & wait`
+	job.Shell = "/bin/sh"
+
+	done := make(chan error)
+	e := NewExecution()
+	start := time.Now()
+	go func() { done <- job.Run(&Context{Execution: e}) }()
+
+	for job.runningProcesses() == 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	time.Sleep(100 * time.Millisecond)
+	job.Terminate()
+	c.Assert(<-done, ErrorMatches, ".*exit status 3")
+	c.Assert(time.Since(start) < time.Second*5, Equals, true)
+	c.Assert(e.OutputStream.String(), Equals, "foo\n")
+}
+
+func (s *SuiteLocalJob) TestRunRlimits(c *C) {
+	job := &LocalJob{}
+	job.Command = `ulimit -n; ulimit -t`
+	job.Shell = "/bin/sh"
+	job.RlimitNofile = "64"
+	job.RlimitCPU = "1m"
+
+	e := NewExecution()
+	c.Assert(job.Run(&Context{Execution: e}), IsNil)
+	c.Assert(e.OutputStream.String(), Matches, "(?s).*64\n.*60\n")
+	c.Assert(e.Usage, NotNil)
+	c.Assert(e.Usage.MaxRSS > 0, Equals, true)
+}
+
+func (s *SuiteLocalJob) TestBuildArgsInvalidRlimits(c *C) {
+	job := &LocalJob{}
+	job.Command = `echo foo`
+
+	job.RlimitNofile = "foo"
+	_, err := job.buildArgs("")
+	c.Assert(err, ErrorMatches, `invalid rlimit-nofile "foo"`)
+
+	job.RlimitNofile = ""
+	job.RlimitAS = "foo"
+	_, err = job.buildArgs("")
+	c.Assert(err, ErrorMatches, `invalid rlimit-as "foo".*`)
+}
+
+func (s *SuiteLocalJob) TestRunScript(c *C) {
+	job := &LocalJob{}
+	job.Script = "#!/bin/sh -e\nfoo=bar\necho $foo\necho $0 >&2\n"
+
+	e := NewExecution()
+	c.Assert(job.Run(&Context{Execution: e}), IsNil)
+	c.Assert(e.OutputStream.String(), Equals, "bar\n")
+
+	script := strings.TrimSpace(e.ErrorStream.String())
+	c.Assert(strings.HasPrefix(filepath.Base(script), "ofelia-script-"), Equals, true)
+
+	_, err := os.Stat(script)
+	c.Assert(os.IsNotExist(err), Equals, true)
+}
@@ -0,0 +1,102 @@
+//go:build !windows
+// +build !windows
+
+package core
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// buildSysProcAttr runs the process in its own process group, so the whole
+// tree can be killed, and with the configured user and group if any.
+func (j *LocalJob) buildSysProcAttr() (*syscall.SysProcAttr, error) {
+	attr := &syscall.SysProcAttr{Setpgid: true}
+	if j.User == "" && j.Group == "" {
+		return attr, nil
+	}
+
+	cred := &syscall.Credential{
+		Uid: uint32(os.Getuid()),
+		Gid: uint32(os.Getgid()),
+	}
+
+	if j.User != "" {
+		u, err := lookupUser(j.User)
+		if err != nil {
+			return nil, err
+		}
+
+		uid, _ := strconv.Atoi(u.Uid)
+		gid, _ := strconv.Atoi(u.Gid)
+		cred.Uid, cred.Gid = uint32(uid), uint32(gid)
+
+		groups, err := u.GroupIds()
+		if err != nil {
+			return nil, fmt.Errorf("error looking up groups of user %q: %s", j.User, err)
+		}
+
+		for _, g := range groups {
+			gid, _ := strconv.Atoi(g)
+			cred.Groups = append(cred.Groups, uint32(gid))
+		}
+	}
+
+	if j.Group != "" {
+		g, err := lookupGroup(j.Group)
+		if err != nil {
+			return nil, err
+		}
+
+		gid, _ := strconv.Atoi(g.Gid)
+		cred.Gid = uint32(gid)
+	}
+
+	attr.Credential = cred
+	return attr, nil
+}
+
+// chownScript gives the script file to the user running the job
+func (j *LocalJob) chownScript(f *os.File) error {
+	attr, err := j.buildSysProcAttr()
+	if err != nil {
+		return err
+	}
+
+	if attr.Credential == nil {
+		return nil
+	}
+
+	if err := f.Chown(int(attr.Credential.Uid), int(attr.Credential.Gid)); err != nil {
+		return fmt.Errorf("error changing script file owner: %s", err)
+	}
+
+	return nil
+}
+
+// wrapArgs wraps the command by a shell running the preamble before exec'ing it
+func wrapArgs(preamble, cmd []string) ([]string, error) {
+	wrapper := strings.Join(append(preamble, `exec "$@"`), " && ")
+	return append([]string{"/bin/sh", "-c", wrapper, "sh"}, cmd...), nil
+}
+
+// killProcessGroup kills the process and all its children
+func killProcessGroup(cmd *exec.Cmd) {
+	signalProcessGroup(cmd, syscall.SIGKILL)
+}
+
+func terminateProcessGroup(cmd *exec.Cmd) {
+	signalProcessGroup(cmd, syscall.SIGTERM)
+}
+
+func signalProcessGroup(cmd *exec.Cmd, sig syscall.Signal) {
+	if cmd.Process == nil {
+		return
+	}
+
+	syscall.Kill(-cmd.Process.Pid, sig)
+}
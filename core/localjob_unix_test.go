@@ -0,0 +1,23 @@
+//go:build !windows
+// +build !windows
+
+package core
+
+import (
+	"os/user"
+
+	. "gopkg.in/check.v1"
+)
+
+func (s *SuiteLocalJob) TestBuildSysProcAttrGroups(c *C) {
+	u, err := user.Current()
+	c.Assert(err, IsNil)
+
+	groups, err := u.GroupIds()
+	c.Assert(err, IsNil)
+
+	job := &LocalJob{User: u.Username}
+	attr, err := job.buildSysProcAttr()
+	c.Assert(err, IsNil)
+	c.Assert(attr.Credential.Groups, HasLen, len(groups))
+}
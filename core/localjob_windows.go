@@ -0,0 +1,63 @@
+package core
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+var errUnixOnly = errors.New("user, group, umask, rlimit-nofile, rlimit-as, rlimit-cpu, nice, ionice, memory-max and cpu-max are not supported on windows")
+
+func (j *LocalJob) createCgroup(e *Execution) (string, error) {
+	if j.MemoryMax != "" || j.CPUMax != "" {
+		return "", errUnixOnly
+	}
+
+	return "", nil
+}
+
+func useCgroup(cmd *exec.Cmd, dir string) (*os.File, error) { return nil, nil }
+
+func removeCgroup(ctx *Context, dir string) {}
+
+func (j *LocalJob) setPriority(pid int) error {
+	if j.Nice != 0 || j.IONice != "" {
+		return errUnixOnly
+	}
+
+	return nil
+}
+
+func (j *LocalJob) buildSysProcAttr() (*syscall.SysProcAttr, error) {
+	if j.User != "" || j.Group != "" {
+		return nil, errUnixOnly
+	}
+
+	return nil, nil
+}
+
+// wrapArgs fails, there is no shell to set the umask and rlimits on windows
+func wrapArgs(preamble, cmd []string) ([]string, error) {
+	return nil, errUnixOnly
+}
+
+func (j *LocalJob) chownScript(f *os.File) error {
+	return nil
+}
+
+// killProcessGroup kills the process, windows has no process groups
+func killProcessGroup(cmd *exec.Cmd) {
+	if cmd.Process != nil {
+		cmd.Process.Kill()
+	}
+}
+
+// terminateProcessGroup does nothing, windows has no SIGTERM, the process is
+// killed once the grace period is over
+func terminateProcessGroup(cmd *exec.Cmd) {}
+
+// maxRSS isn't reported on windows
+func maxRSS(ps *os.ProcessState) int64 {
+	return 0
+}
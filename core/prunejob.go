@@ -0,0 +1,260 @@
+package core
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	units "github.com/docker/go-units"
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+// Images pruned by PruneJob
+const (
+	PruneImagesDangling = "dangling"
+	PruneImagesAll      = "all"
+)
+
+// API versions required by some prune requests: the build cache prune is
+// available since 1.31, and the volume prune only removes the anonymous
+// volumes since 1.42, unless the all filter is set.
+var (
+	apiVersionBuildPrune  = docker.APIVersion{1, 31}
+	apiVersionVolumePrune = docker.APIVersion{1, 42}
+)
+
+var ErrNothingToPrune = errors.New("nothing to prune, enable at least one of containers, images, volumes, networks or build-cache")
+
+// PruneJob removes unused docker objects, similar to `docker system prune`
+type PruneJob struct {
+	BareJob     `mapstructure:",squash"`
+	Client      *docker.Client `json:"-"`
+	Containers  bool
+	Images      string
+	Volumes     bool
+	Networks    bool
+	BuildCache  bool `gcfg:"build-cache" mapstructure:"build-cache"`
+	Until       string
+	LabelFilter []string `gcfg:"label-filter" mapstructure:"label-filter"`
+}
+
+func NewPruneJob(c *docker.Client) *PruneJob {
+	return &PruneJob{Client: c}
+}
+
+// GetCommand returns the kind of objects pruned, used to describe the job
+func (j *PruneJob) GetCommand() string {
+	var objects []string
+	if j.Containers {
+		objects = append(objects, "containers")
+	}
+
+	if j.Images != "" {
+		objects = append(objects, j.Images+" images")
+	}
+
+	if j.Volumes {
+		objects = append(objects, "volumes")
+	}
+
+	if j.Networks {
+		objects = append(objects, "networks")
+	}
+
+	if j.BuildCache {
+		objects = append(objects, "build cache")
+	}
+
+	return "prune " + strings.Join(objects, ", ")
+}
+
+func (j *PruneJob) Run(ctx *Context) error {
+	if !j.Containers && j.Images == "" && !j.Volumes && !j.Networks && !j.BuildCache {
+		return ErrNothingToPrune
+	}
+
+	switch j.Images {
+	case "", PruneImagesDangling, PruneImagesAll:
+	default:
+		return fmt.Errorf("invalid images %q", j.Images)
+	}
+
+	r := &PruneResult{}
+	ctx.Execution.Prune = r
+
+	out := ctx.Execution.OutputStream
+	if j.Containers {
+		res, err := j.Client.PruneContainers(docker.PruneContainersOptions{
+			Filters: j.buildFilters(true, true),
+		})
+		if err != nil {
+			return fmt.Errorf("error pruning containers: %s", err)
+		}
+
+		r.ContainersDeleted = len(res.ContainersDeleted)
+		r.SpaceReclaimed += res.SpaceReclaimed
+		fmt.Fprintf(out, "Deleted containers: %d (%s)\n", r.ContainersDeleted, units.HumanSize(float64(res.SpaceReclaimed)))
+	}
+
+	if j.Images != "" {
+		filters := j.buildFilters(true, true)
+		filters["dangling"] = []string{fmt.Sprint(j.Images == PruneImagesDangling)}
+
+		res, err := j.Client.PruneImages(docker.PruneImagesOptions{Filters: filters})
+		if err != nil {
+			return fmt.Errorf("error pruning images: %s", err)
+		}
+
+		r.ImagesDeleted = len(res.ImagesDeleted)
+		r.SpaceReclaimed += res.SpaceReclaimed
+		fmt.Fprintf(out, "Deleted images: %d (%s)\n", r.ImagesDeleted, units.HumanSize(float64(res.SpaceReclaimed)))
+	}
+
+	var version docker.APIVersion
+	if j.Volumes || j.BuildCache {
+		var err error
+		if version, err = j.serverAPIVersion(); err != nil {
+			return err
+		}
+	}
+
+	if j.Volumes {
+		filters := j.buildFilters(false, true)
+		if version.GreaterThanOrEqualTo(apiVersionVolumePrune) {
+			filters["all"] = []string{"true"}
+		}
+
+		res, err := j.Client.PruneVolumes(docker.PruneVolumesOptions{Filters: filters})
+		if err != nil {
+			return fmt.Errorf("error pruning volumes: %s", err)
+		}
+
+		r.VolumesDeleted = len(res.VolumesDeleted)
+		r.SpaceReclaimed += res.SpaceReclaimed
+		fmt.Fprintf(out, "Deleted volumes: %d (%s)\n", r.VolumesDeleted, units.HumanSize(float64(res.SpaceReclaimed)))
+	}
+
+	if j.Networks {
+		res, err := j.Client.PruneNetworks(docker.PruneNetworksOptions{
+			Filters: j.buildFilters(true, true),
+		})
+		if err != nil {
+			return fmt.Errorf("error pruning networks: %s", err)
+		}
+
+		r.NetworksDeleted = len(res.NetworksDeleted)
+		fmt.Fprintf(out, "Deleted networks: %d\n", r.NetworksDeleted)
+	}
+
+	if j.BuildCache {
+		if version.LessThan(apiVersionBuildPrune) {
+			return fmt.Errorf("error pruning build cache: requires docker API %s, the server supports %s", apiVersionBuildPrune, version)
+		}
+
+		res, err := j.pruneBuildCache(j.buildFilters(true, false))
+		if err != nil {
+			return fmt.Errorf("error pruning build cache: %s", err)
+		}
+
+		r.BuildCacheDeleted = len(res.CachesDeleted)
+		r.SpaceReclaimed += res.SpaceReclaimed
+		fmt.Fprintf(out, "Deleted build cache objects: %d (%s)\n", r.BuildCacheDeleted, units.HumanSize(float64(res.SpaceReclaimed)))
+	}
+
+	fmt.Fprintf(out, "Total reclaimed space: %s\n", units.HumanSize(float64(r.SpaceReclaimed)))
+	return nil
+}
+
+// buildFilters returns the filters of the prune request, not every kind of
+// object supports the until and label filters.
+func (j *PruneJob) buildFilters(until, label bool) map[string][]string {
+	filters := make(map[string][]string)
+	if until && j.Until != "" {
+		filters["until"] = []string{j.Until}
+	}
+
+	if !label {
+		return filters
+	}
+
+	for _, l := range j.LabelFilter {
+		// like the docker cli, "label!=foo" excludes the objects with the label
+		if strings.Contains(l, "!=") {
+			filters["label!"] = append(filters["label!"], strings.Replace(l, "!=", "=", 1))
+			continue
+		}
+
+		filters["label"] = append(filters["label"], l)
+	}
+
+	return filters
+}
+
+// serverAPIVersion returns the API version of the docker daemon
+func (j *PruneJob) serverAPIVersion() (docker.APIVersion, error) {
+	env, err := j.Client.Version()
+	if err != nil {
+		return nil, fmt.Errorf("error getting docker version: %s", err)
+	}
+
+	version, err := docker.NewAPIVersion(env.Get("ApiVersion"))
+	if err != nil {
+		return nil, fmt.Errorf("error getting docker version: %s", err)
+	}
+
+	return version, nil
+}
+
+type pruneBuildCacheResults struct {
+	CachesDeleted  []string
+	SpaceReclaimed int64
+}
+
+// pruneBuildCache calls the build cache prune API, not supported by the docker
+// client, reusing its HTTP client and endpoint. The API is available since
+// version 1.31, with filters since 1.39.
+func (j *PruneJob) pruneBuildCache(filters map[string][]string) (*pruneBuildCacheResults, error) {
+	f, err := json.Marshal(filters)
+	if err != nil {
+		return nil, err
+	}
+
+	u, err := url.Parse(j.Client.Endpoint())
+	if err != nil {
+		return nil, err
+	}
+
+	switch u.Scheme {
+	case "unix", "npipe":
+		// the transport of the client dials the socket, whatever the host
+		u.Scheme, u.Host, u.Path = "http", "docker", ""
+	case "tcp":
+		u.Scheme = "http"
+		if j.Client.TLSConfig != nil {
+			u.Scheme = "https"
+		}
+	}
+
+	u.Path += "/build/prune"
+	u.RawQuery = url.Values{"filters": {string(f)}}.Encode()
+
+	resp, err := j.Client.HTTPClient.Post(u.String(), "plain/text", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+
+	var res pruneBuildCacheResults
+	if err := json.NewDecoder(resp.Body).Decode(&res); err != nil {
+		return nil, err
+	}
+
+	return &res, nil
+}
@@ -0,0 +1,146 @@
+package core
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+
+	docker "github.com/fsouza/go-dockerclient"
+	. "gopkg.in/check.v1"
+)
+
+type SuitePruneJob struct {
+	server     *httptest.Server
+	client     *docker.Client
+	filters    map[string]string
+	apiVersion string
+}
+
+var _ = Suite(&SuitePruneJob{})
+
+func (s *SuitePruneJob) SetUpTest(c *C) {
+	s.filters = make(map[string]string)
+	s.apiVersion = "1.41"
+
+	responses := map[string]interface{}{
+		"/containers/prune": map[string]interface{}{
+			"ContainersDeleted": []string{"foo", "bar"},
+			"SpaceReclaimed":    1000,
+		},
+		"/images/prune": map[string]interface{}{
+			"ImagesDeleted":  []map[string]string{{"Deleted": "sha256:foo"}},
+			"SpaceReclaimed": 2000,
+		},
+		"/volumes/prune": map[string]interface{}{
+			"VolumesDeleted": []string{},
+			"SpaceReclaimed": 0,
+		},
+		"/networks/prune": map[string]interface{}{
+			"NetworksDeleted": []string{"foo"},
+		},
+		"/build/prune": map[string]interface{}{
+			"CachesDeleted":  []string{"foo", "bar", "qux"},
+			"SpaceReclaimed": 3000,
+		},
+	}
+
+	s.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/version" {
+			json.NewEncoder(w).Encode(map[string]string{"ApiVersion": s.apiVersion})
+			return
+		}
+
+		res, ok := responses[r.URL.Path]
+		if !ok || r.Method != http.MethodPost {
+			http.NotFound(w, r)
+			return
+		}
+
+		s.filters[r.URL.Path] = r.URL.Query().Get("filters")
+		json.NewEncoder(w).Encode(res)
+	}))
+
+	var err error
+	s.client, err = docker.NewClient(s.server.URL)
+	c.Assert(err, IsNil)
+}
+
+func (s *SuitePruneJob) TearDownTest(c *C) {
+	s.server.Close()
+}
+
+func (s *SuitePruneJob) TestRun(c *C) {
+	job := &PruneJob{Client: s.client}
+	job.Containers = true
+	job.Images = PruneImagesAll
+	job.Volumes = true
+	job.Networks = true
+	job.BuildCache = true
+	job.Until = "24h"
+	job.LabelFilter = []string{"env=test", "keep!=true"}
+
+	e := NewExecution()
+	err := job.Run(&Context{Execution: e})
+	c.Assert(err, IsNil)
+	c.Assert(e.Prune, DeepEquals, &PruneResult{
+		ContainersDeleted: 2,
+		ImagesDeleted:     1,
+		VolumesDeleted:    0,
+		NetworksDeleted:   1,
+		BuildCacheDeleted: 3,
+		SpaceReclaimed:    6000,
+	})
+
+	c.Assert(e.OutputStream.String(), Equals, strings.Join([]string{
+		"Deleted containers: 2 (1kB)",
+		"Deleted images: 1 (2kB)",
+		"Deleted volumes: 0 (0B)",
+		"Deleted networks: 1",
+		"Deleted build cache objects: 3 (3kB)",
+		"Total reclaimed space: 6kB",
+		"",
+	}, "\n"))
+
+	c.Assert(s.filters["/images/prune"], Equals, `{"dangling":["false"],"label":["env=test"],"label!":["keep=true"],"until":["24h"]}`)
+	c.Assert(s.filters["/volumes/prune"], Equals, `{"label":["env=test"],"label!":["keep=true"]}`)
+	c.Assert(s.filters["/build/prune"], Equals, `{"until":["24h"]}`)
+}
+
+func (s *SuitePruneJob) TestRunVolumesAll(c *C) {
+	s.apiVersion = "1.43"
+
+	job := &PruneJob{Client: s.client}
+	job.Volumes = true
+
+	c.Assert(job.Run(&Context{Execution: NewExecution()}), IsNil)
+	c.Assert(s.filters["/volumes/prune"], Equals, `{"all":["true"]}`)
+}
+
+func (s *SuitePruneJob) TestRunBuildCacheUnsupported(c *C) {
+	s.apiVersion = "1.30"
+
+	job := &PruneJob{Client: s.client}
+	job.BuildCache = true
+
+	err := job.Run(&Context{Execution: NewExecution()})
+	c.Assert(err, ErrorMatches, "error pruning build cache: requires docker API 1.31, the server supports 1.30")
+}
+
+func (s *SuitePruneJob) TestRunNothingToPrune(c *C) {
+	job := &PruneJob{Client: s.client}
+	c.Assert(job.Run(&Context{Execution: NewExecution()}), Equals, ErrNothingToPrune)
+}
+
+func (s *SuitePruneJob) TestRunInvalidImages(c *C) {
+	job := &PruneJob{Client: s.client}
+	job.Images = "foo"
+
+	err := job.Run(&Context{Execution: NewExecution()})
+	c.Assert(err, ErrorMatches, `invalid images "foo"`)
+}
+
+func (s *SuitePruneJob) TestGetCommand(c *C) {
+	job := &PruneJob{Containers: true, Images: PruneImagesDangling, BuildCache: true}
+	c.Assert(job.GetCommand(), Equals, "prune containers, dangling images, build cache")
+}
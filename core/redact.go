@@ -0,0 +1,109 @@
+package core
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"regexp"
+)
+
+// redactMask replaces the redacted secrets
+const redactMask = "***"
+
+// redactMinLength is the length of the shortest value redacted, the shorter
+// ones would mask too much of the outputs
+const redactMinLength = 4
+
+// Redactor replaces the secrets in the outputs and the errors of the
+// executions, before any middleware sees them
+type Redactor struct {
+	patterns []*regexp.Regexp
+}
+
+// NewRedactor returns a Redactor replacing the matches of the patterns, and
+// the given values, ignoring the ones shorter than 4 bytes. If a pattern has
+// groups only the groups are replaced, e.g. `password=(\S+)` keeps
+// "password=".
+func NewRedactor(patterns, values []string) (*Redactor, error) {
+	r := &Redactor{}
+	for _, p := range patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %s", p, err)
+		}
+
+		r.patterns = append(r.patterns, re)
+	}
+
+	seen := make(map[string]bool)
+	for _, v := range values {
+		if len(v) >= redactMinLength && !seen[v] {
+			seen[v] = true
+			r.patterns = append(r.patterns, regexp.MustCompile(regexp.QuoteMeta(v)))
+		}
+	}
+
+	return r, nil
+}
+
+// IsEmpty returns if the redactor has nothing to replace
+func (r *Redactor) IsEmpty() bool {
+	return r == nil || len(r.patterns) == 0
+}
+
+// Redact returns the bytes with the secrets replaced
+func (r *Redactor) Redact(b []byte) []byte {
+	if r.IsEmpty() {
+		return b
+	}
+
+	for _, re := range r.patterns {
+		b = redactPattern(re, b)
+	}
+
+	return b
+}
+
+// RedactError returns the error with the secrets of its message replaced, the
+// skipped errors are returned unchanged
+func (r *Redactor) RedactError(err error) error {
+	if err == nil || r.IsEmpty() || IsSkipped(err) {
+		return err
+	}
+
+	msg := err.Error()
+	if redacted := string(r.Redact([]byte(msg))); redacted != msg {
+		return errors.New(redacted)
+	}
+
+	return err
+}
+
+func redactPattern(re *regexp.Regexp, b []byte) []byte {
+	matches := re.FindAllSubmatchIndex(b, -1)
+	if matches == nil {
+		return b
+	}
+
+	out := bytes.NewBuffer(make([]byte, 0, len(b)))
+	last := 0
+	for _, m := range matches {
+		spans := m[:2]
+		if re.NumSubexp() > 0 {
+			spans = m[2:]
+		}
+
+		for i := 0; i < len(spans); i += 2 {
+			if spans[i] < last || spans[i] == spans[i+1] {
+				continue
+			}
+
+			out.Write(b[last:spans[i]])
+			out.WriteString(redactMask)
+			last = spans[i+1]
+		}
+	}
+
+	out.Write(b[last:])
+	return out.Bytes()
+}
@@ -0,0 +1,89 @@
+package core
+
+import (
+	"errors"
+	"io/ioutil"
+
+	. "gopkg.in/check.v1"
+)
+
+type SuiteRedact struct{}
+
+var _ = Suite(&SuiteRedact{})
+
+func (s *SuiteRedact) TestRedact(c *C) {
+	r, err := NewRedactor([]string{`password=(\S+)`, `ghp_\w+`}, []string{"s3cr3t", "", "s3cr3t", "foo"})
+	c.Assert(err, IsNil)
+
+	c.Assert(string(r.Redact([]byte("user=foo password=bar token=ghp_abc123 key=s3cr3t"))), Equals,
+		"user=foo password=*** token=*** key=***")
+	c.Assert(string(r.Redact([]byte("nothing"))), Equals, "nothing")
+}
+
+func (s *SuiteRedact) TestRedactError(c *C) {
+	r, err := NewRedactor(nil, []string{"s3cr3t"})
+	c.Assert(err, IsNil)
+
+	c.Assert(r.RedactError(errors.New("invalid token s3cr3t")), ErrorMatches, `invalid token \*\*\*`)
+	c.Assert(r.RedactError(nil), IsNil)
+
+	skipped := &SkippedError{Reason: "s3cr3t"}
+	c.Assert(r.RedactError(skipped), Equals, skipped)
+
+	var empty *Redactor
+	c.Assert(empty.RedactError(errors.New("s3cr3t")), ErrorMatches, "s3cr3t")
+}
+
+func (s *SuiteRedact) TestInvalidPattern(c *C) {
+	_, err := NewRedactor([]string{"("}, nil)
+	c.Assert(err, ErrorMatches, `invalid pattern "\(": .*`)
+}
+
+func (s *SuiteRedact) TestStream(c *C) {
+	r, err := NewRedactor(nil, []string{"s3cr3t"})
+	c.Assert(err, IsNil)
+
+	st := NewStream(0)
+	st.SetRedactor(r)
+	f := st.Follow()
+
+	st.Write([]byte("foo s3"))
+	st.Write([]byte("cr3t\nbar "))
+	c.Assert(st.String(), Equals, "foo ***\n")
+
+	st.Write([]byte("s3cr3t"))
+	st.Close()
+	c.Assert(st.String(), Equals, "foo ***\nbar ***")
+
+	b, err := ioutil.ReadAll(f)
+	c.Assert(err, IsNil)
+	c.Assert(string(b), Equals, "foo ***\nbar ***")
+}
+
+func (s *SuiteRedact) TestRunJob(c *C) {
+	job := &secretJob{}
+	job.Name = "foo"
+	job.Schedule = "@hourly"
+
+	sc := NewScheduler(&TestLogger{})
+	sc.Redactor, _ = NewRedactor(nil, []string{"s3cr3t"})
+	c.Assert(sc.AddJob(job), IsNil)
+
+	e := NewExecution()
+	out := e.OutputStream.Follow()
+	c.Assert(sc.RunJob("foo", e), IsNil)
+	c.Assert(e.Error, ErrorMatches, `connecting with \*\*\*: refused`)
+
+	b, err := ioutil.ReadAll(out)
+	c.Assert(err, IsNil)
+	c.Assert(string(b), Equals, "password: ***\n")
+}
+
+type secretJob struct {
+	BareJob
+}
+
+func (j *secretJob) Run(ctx *Context) error {
+	ctx.Execution.OutputStream.Write([]byte("password: s3cr3t\n"))
+	return errors.New("connecting with s3cr3t: refused")
+}
@@ -1,6 +1,9 @@
 package core
 
 import (
+	"archive/tar"
+	"bytes"
+	"errors"
 	"fmt"
 	"strconv"
 	"time"
@@ -100,8 +103,18 @@ type RunJob struct {
 	Volume    []string
 	Volumes     string
 	Environment string         `default:""`
+	Script      string
+
+	// deleteFailed removes the container even if it failed, e.g. for the
+	// helper containers of other jobs
+	deleteFailed bool
 }
 
+// runJobScriptPath is where the script is injected in the container
+const runJobScriptPath = "/.ofelia-script"
+
+var ErrScriptWithContainer = errors.New("script can't be used to start an existing container")
+
 func NewRunJob(c *docker.Client) *RunJob {
 	return &RunJob{Client: c}
 }
@@ -157,6 +170,8 @@ func (j *RunJob) Run(ctx *Context) error {
 		if err != nil {
 			return err
 		}
+	} else if j.Script != "" {
+		return ErrScriptWithContainer
 	} else {
 		container, err = j.getContainer(j.Container)
 		if err != nil {
@@ -164,31 +179,46 @@ func (j *RunJob) Run(ctx *Context) error {
 		}
 	}
 
+	return j.runContainer(ctx, container, j.Container == "", j.deleteFailed)
+}
+
+// runContainer starts the given container, waits for it to finish collecting
+// its output, whatever the exit code, and removes it if requested and the
+// Delete option allows it. A failed container is kept for inspection unless
+// removeFailed is set.
+func (j *RunJob) runContainer(ctx *Context, container *docker.Container, remove, removeFailed bool) (err error) {
 	startTime := time.Now()
+	defer func() {
+		if logsErr := j.containerLogs(ctx.Execution, container.ID, startTime); logsErr != nil && err == nil {
+			err = logsErr
+		}
+
+		if !remove || (err != nil && !removeFailed) {
+			return
+		}
+
+		if deleteErr := j.deleteContainer(container.ID); deleteErr != nil && err == nil {
+			err = deleteErr
+		}
+	}()
+
 	if err := j.startContainer(ctx.Execution, container); err != nil {
 		return err
 	}
 
-	if err := j.watchContainer(container.ID); err != nil {
-		return err
-	}
+	return j.watchContainer(ctx.Execution, container.ID)
+}
 
-	if err := j.Client.Logs(docker.LogsOptions{
-		Container:    container.ID,
-		OutputStream: ctx.Execution.OutputStream,
-		ErrorStream:  ctx.Execution.ErrorStream,
+func (j *RunJob) containerLogs(e *Execution, containerID string, since time.Time) error {
+	return j.Client.Logs(docker.LogsOptions{
+		Container:    containerID,
+		OutputStream: e.OutputStream,
+		ErrorStream:  e.ErrorStream,
 		Stdout:       true,
 		Stderr:       true,
-		Since:        startTime.Unix(),
+		Since:        since.Unix(),
 		RawTerminal:  true,
-	}); err != nil {
-		return err
-	}
-
-	if j.Container == "" {
-		return j.deleteContainer(container.ID)
-	}
-	return nil
+	})
 }
 
 func (j *RunJob) searchLocalImage() error {
@@ -240,6 +270,11 @@ func (j *RunJob) buildContainer() (*docker.Container, error) {
 		environmentList = strings.Split(j.Environment, ";")
 	}
 
+	cmd := args.GetArgs(j.Command)
+	if j.Script != "" {
+		cmd = append(append([]string{}, scriptInterpreter(j.Script)...), runJobScriptPath)
+	}
+
 	c, err := j.Client.CreateContainer(docker.CreateContainerOptions{
 		Config: &docker.Config{
 			Image:        j.Image,
@@ -247,7 +282,7 @@ func (j *RunJob) buildContainer() (*docker.Container, error) {
 			AttachStdout: true,
 			AttachStderr: true,
 			Tty:          j.TTY,
-			Cmd:          args.GetArgs(j.Command),
+			Cmd:          cmd,
 			User:         j.User,
 			Env:          environmentList,
 		},
@@ -262,6 +297,12 @@ func (j *RunJob) buildContainer() (*docker.Container, error) {
 		return c, fmt.Errorf("error creating exec: %s", err)
 	}
 
+	if j.Script != "" {
+		if err := j.uploadScript(c.ID); err != nil {
+			return c, err
+		}
+	}
+
 	if j.Network != "" {
 		networkOpts := docker.NetworkFilterOpts{}
 		networkOpts["name"] = map[string]bool{}
@@ -280,6 +321,40 @@ func (j *RunJob) buildContainer() (*docker.Container, error) {
 	return c, nil
 }
 
+// uploadScript injects the script into the container, as a single file tar
+// archive extracted at the root of the filesystem.
+func (j *RunJob) uploadScript(containerID string) error {
+	buf := bytes.NewBuffer(nil)
+	tw := tar.NewWriter(buf)
+	if err := tw.WriteHeader(&tar.Header{
+		Name:    path.Base(runJobScriptPath),
+		Mode:    0755,
+		Size:    int64(len(j.Script)),
+		ModTime: time.Now(),
+	}); err != nil {
+		return err
+	}
+
+	if _, err := tw.Write([]byte(j.Script)); err != nil {
+		return err
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+
+	err := j.Client.UploadToContainer(containerID, docker.UploadToContainerOptions{
+		InputStream: buf,
+		Path:        path.Dir(runJobScriptPath),
+	})
+
+	if err != nil {
+		return fmt.Errorf("error uploading script: %s", err)
+	}
+
+	return nil
+}
+
 func (j *RunJob) startContainer(e *Execution, c *docker.Container) error {
 	err := j.Client.StartContainer(c.ID, &docker.HostConfig{})
 	if err != nil {
@@ -311,7 +386,7 @@ const (
 	maxProcessDuration = time.Hour * 24
 )
 
-func (j *RunJob) watchContainer(containerID string) error {
+func (j *RunJob) watchContainer(e *Execution, containerID string) error {
 	var s docker.State
 	var r time.Duration
 	for {
@@ -333,6 +408,7 @@ func (j *RunJob) watchContainer(containerID string) error {
 		}
 	}
 
+	e.ExitCode = s.ExitCode
 	switch s.ExitCode {
 	case 0:
 		return nil
@@ -76,6 +76,65 @@ func (s *SuiteRunJob) TestRun(c *C) {
 	c.Assert(containers, HasLen, 0)
 }
 
+func (s *SuiteRunJob) TestRunFailedKeepsContainer(c *C) {
+	job := &RunJob{Client: s.client}
+	job.Image = ImageFixture
+	job.Command = `echo foo`
+	job.Delete = "true"
+	job.Name = "test"
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+
+	go func() {
+		defer wg.Done()
+		time.Sleep(time.Millisecond * 200)
+
+		containers, err := s.client.ListContainers(docker.ListContainersOptions{})
+		c.Check(err, IsNil)
+		c.Check(containers, HasLen, 1)
+
+		err = s.server.MutateContainer(containers[0].ID, docker.State{ExitCode: 2})
+		c.Check(err, IsNil)
+	}()
+
+	ctx := &Context{Execution: NewExecution(), Logger: logging.MustGetLogger("ofelia"), Job: job}
+	err := job.Run(ctx)
+	c.Assert(err, ErrorMatches, "error non-zero exit code: 2")
+	wg.Wait()
+
+	containers, err := s.client.ListContainers(docker.ListContainersOptions{All: true})
+	c.Assert(err, IsNil)
+	c.Assert(containers, HasLen, 1)
+}
+
+func (s *SuiteRunJob) TestBuildContainerScript(c *C) {
+	job := &RunJob{Client: s.client}
+	job.Image = ImageFixture
+	job.Script = "#!/usr/bin/env python3\nprint('foo')\n"
+
+	container, err := job.buildContainer()
+	c.Assert(err, IsNil)
+
+	container, err = s.client.InspectContainer(container.ID)
+	c.Assert(err, IsNil)
+	c.Assert(container.Config.Cmd, DeepEquals, []string{"/usr/bin/env", "python3", runJobScriptPath})
+
+	err = s.client.DownloadFromContainer(container.ID, docker.DownloadFromContainerOptions{
+		Path:         runJobScriptPath,
+		OutputStream: bytes.NewBuffer(nil),
+	})
+	c.Assert(err, IsNil)
+}
+
+func (s *SuiteRunJob) TestRunScriptWithContainer(c *C) {
+	job := &RunJob{Client: s.client}
+	job.Container = "foo"
+	job.Script = "echo foo"
+
+	c.Assert(job.Run(&Context{Execution: NewExecution()}), Equals, ErrScriptWithContainer)
+}
+
 func (s *SuiteRunJob) TestBuildPullImageOptionsBareImage(c *C) {
 	o, _ := buildPullOptions("foo")
 	c.Assert(o.Repository, Equals, "foo")
@@ -1,14 +1,18 @@
 package core
 
 import (
+	"errors"
 	"fmt"
+	"path/filepath"
 	"strconv"
 	"strings"
-	"sync"
 	"time"
 
+	"github.com/docker/docker/api/types/mount"
 	"github.com/docker/docker/api/types/swarm"
+	units "github.com/docker/go-units"
 	docker "github.com/fsouza/go-dockerclient"
+	"github.com/gobs/args"
 )
 
 // Note: The ServiceJob is loosely inspired by https://github.com/alexellis/jaas/
@@ -25,6 +29,22 @@ type RunServiceJob struct {
 	Delete  string `default:"true"`
 	Image   string
 	Network string
+	Dir     string
+
+	Mode             string `default:"replicated"`
+	MaxConcurrent    uint64 `gcfg:"max-concurrent" mapstructure:"max-concurrent"`
+	TotalCompletions uint64 `gcfg:"total-completions" mapstructure:"total-completions"`
+
+	Environment []string
+	Volume      []string
+	Secret      []string
+	Config      []string
+	Constraint  []string
+
+	CPULimit          string `gcfg:"cpu-limit" mapstructure:"cpu-limit"`
+	CPUReservation    string `gcfg:"cpu-reservation" mapstructure:"cpu-reservation"`
+	MemoryLimit       string `gcfg:"memory-limit" mapstructure:"memory-limit"`
+	MemoryReservation string `gcfg:"memory-reservation" mapstructure:"memory-reservation"`
 }
 
 func NewRunServiceJob(c *docker.Client) *RunServiceJob {
@@ -44,11 +64,14 @@ func (j *RunServiceJob) Run(ctx *Context) error {
 
 	ctx.Logger.Noticef("Created service %s for job %s\n", svc.ID, j.Name)
 
-	if err := j.watchContainer(ctx, svc.ID); err != nil {
+	watchErr := j.watchContainer(ctx, svc.ID)
+	j.serviceLogs(ctx, svc.ID)
+
+	if err := j.deleteService(ctx, svc.ID); err != nil {
 		return err
 	}
 
-	return j.deleteService(ctx, svc.ID)
+	return watchErr
 }
 
 func (j *RunServiceJob) pullImage() error {
@@ -61,17 +84,46 @@ func (j *RunServiceJob) pullImage() error {
 }
 
 func (j *RunServiceJob) buildService() (*swarm.Service, error) {
-
-	//createOptions := types.ServiceCreateOptions{}
-
 	max := uint64(1)
 	createSvcOpts := docker.CreateServiceOptions{}
 
+	mode, err := j.buildMode()
+	if err != nil {
+		return nil, err
+	}
+
+	createSvcOpts.ServiceSpec.Mode = mode
+
+	// forward the registry credentials so the nodes are able to pull the image
+	_, createSvcOpts.Auth = buildPullOptions(j.Image)
+
+	mounts, err := buildServiceMounts(j.Volume)
+	if err != nil {
+		return nil, err
+	}
+
 	createSvcOpts.ServiceSpec.TaskTemplate.ContainerSpec =
 		&swarm.ContainerSpec{
-			Image: j.Image,
+			Image:  j.Image,
+			User:   j.User,
+			TTY:    j.TTY,
+			Dir:    j.Dir,
+			Env:    j.Environment,
+			Mounts: mounts,
 		}
 
+	if j.Command != "" {
+		createSvcOpts.ServiceSpec.TaskTemplate.ContainerSpec.Command = args.GetArgs(j.Command)
+	}
+
+	if err := j.buildSecrets(createSvcOpts.ServiceSpec.TaskTemplate.ContainerSpec); err != nil {
+		return nil, err
+	}
+
+	if err := j.buildConfigs(createSvcOpts.ServiceSpec.TaskTemplate.ContainerSpec); err != nil {
+		return nil, err
+	}
+
 	// Make the service run once and not restart
 	createSvcOpts.ServiceSpec.TaskTemplate.RestartPolicy =
 		&swarm.RestartPolicy{
@@ -79,6 +131,19 @@ func (j *RunServiceJob) buildService() (*swarm.Service, error) {
 			Condition:   swarm.RestartPolicyConditionNone,
 		}
 
+	if len(j.Constraint) != 0 {
+		createSvcOpts.ServiceSpec.TaskTemplate.Placement = &swarm.Placement{
+			Constraints: j.Constraint,
+		}
+	}
+
+	resources, err := j.buildResources()
+	if err != nil {
+		return nil, err
+	}
+
+	createSvcOpts.ServiceSpec.TaskTemplate.Resources = resources
+
 	// For a service to interact with other services in a stack,
 	// we need to attach it to the same network
 	if j.Network != "" {
@@ -89,10 +154,6 @@ func (j *RunServiceJob) buildService() (*swarm.Service, error) {
 		}
 	}
 
-	if j.Command != "" {
-		createSvcOpts.ServiceSpec.TaskTemplate.ContainerSpec.Command = strings.Split(j.Command, " ")
-	}
-
 	svc, err := j.Client.CreateService(createSvcOpts)
 	if err != nil {
 		return nil, err
@@ -101,6 +162,179 @@ func (j *RunServiceJob) buildService() (*swarm.Service, error) {
 	return svc, err
 }
 
+// buildServiceMounts converts `docker run -v` alike definitions into swarm
+// mounts, a source with a path is a bind mount otherwise is a named volume.
+func buildServiceMounts(volumes []string) ([]mount.Mount, error) {
+	var mounts []mount.Mount
+	for _, v := range volumes {
+		parts := strings.Split(v, ":")
+		if len(parts) < 2 || len(parts) > 3 {
+			return nil, fmt.Errorf("invalid volume definition %q", v)
+		}
+
+		m := mount.Mount{
+			Type:   mount.TypeVolume,
+			Source: parts[0],
+			Target: parts[1],
+		}
+
+		if filepath.IsAbs(parts[0]) {
+			m.Type = mount.TypeBind
+		}
+
+		if len(parts) == 3 {
+			switch parts[2] {
+			case "ro":
+				m.ReadOnly = true
+			case "rw":
+			default:
+				return nil, fmt.Errorf("invalid volume mode %q in %q", parts[2], v)
+			}
+		}
+
+		mounts = append(mounts, m)
+	}
+
+	return mounts, nil
+}
+
+// splitReference splits a `name[:target]` secret or config reference
+func splitReference(ref string) (string, string) {
+	parts := strings.SplitN(ref, ":", 2)
+	if len(parts) == 1 || parts[1] == "" {
+		return parts[0], parts[0]
+	}
+
+	return parts[0], parts[1]
+}
+
+func (j *RunServiceJob) buildSecrets(spec *swarm.ContainerSpec) error {
+	for _, ref := range j.Secret {
+		name, target := splitReference(ref)
+		secrets, err := j.Client.ListSecrets(docker.ListSecretsOptions{
+			Filters: map[string][]string{"name": {name}},
+		})
+		if err != nil {
+			return fmt.Errorf("error looking up secret %q: %s", name, err)
+		}
+
+		id := ""
+		for _, s := range secrets {
+			if s.Spec.Name == name {
+				id = s.ID
+			}
+		}
+
+		if id == "" {
+			return fmt.Errorf("secret %q not found", name)
+		}
+
+		spec.Secrets = append(spec.Secrets, &swarm.SecretReference{
+			SecretID:   id,
+			SecretName: name,
+			File: &swarm.SecretReferenceFileTarget{
+				Name: target,
+				UID:  "0",
+				GID:  "0",
+				Mode: 0444,
+			},
+		})
+	}
+
+	return nil
+}
+
+func (j *RunServiceJob) buildConfigs(spec *swarm.ContainerSpec) error {
+	for _, ref := range j.Config {
+		name, target := splitReference(ref)
+		configs, err := j.Client.ListConfigs(docker.ListConfigsOptions{
+			Filters: map[string][]string{"name": {name}},
+		})
+		if err != nil {
+			return fmt.Errorf("error looking up config %q: %s", name, err)
+		}
+
+		id := ""
+		for _, c := range configs {
+			if c.Spec.Name == name {
+				id = c.ID
+			}
+		}
+
+		if id == "" {
+			return fmt.Errorf("config %q not found", name)
+		}
+
+		spec.Configs = append(spec.Configs, &swarm.ConfigReference{
+			ConfigID:   id,
+			ConfigName: name,
+			File: &swarm.ConfigReferenceFileTarget{
+				Name: target,
+				UID:  "0",
+				GID:  "0",
+				Mode: 0444,
+			},
+		})
+	}
+
+	return nil
+}
+
+func (j *RunServiceJob) buildResources() (*swarm.ResourceRequirements, error) {
+	limits, err := buildResources(j.CPULimit, j.MemoryLimit)
+	if err != nil {
+		return nil, err
+	}
+
+	reservations, err := buildResources(j.CPUReservation, j.MemoryReservation)
+	if err != nil {
+		return nil, err
+	}
+
+	if limits == nil && reservations == nil {
+		return nil, nil
+	}
+
+	r := &swarm.ResourceRequirements{Reservations: reservations}
+	if limits != nil {
+		r.Limits = &swarm.Limit{
+			NanoCPUs:    limits.NanoCPUs,
+			MemoryBytes: limits.MemoryBytes,
+		}
+	}
+
+	return r, nil
+}
+
+// buildResources parses a CPU count (e.g. `0.5`) and a memory size (e.g.
+// `512m`), returns nil if both are empty.
+func buildResources(cpu, memory string) (*swarm.Resources, error) {
+	if cpu == "" && memory == "" {
+		return nil, nil
+	}
+
+	r := &swarm.Resources{}
+	if cpu != "" {
+		n, err := strconv.ParseFloat(cpu, 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cpu value %q: %s", cpu, err)
+		}
+
+		r.NanoCPUs = int64(n * 1e9)
+	}
+
+	if memory != "" {
+		n, err := units.RAMInBytes(memory)
+		if err != nil {
+			return nil, fmt.Errorf("invalid memory value %q: %s", memory, err)
+		}
+
+		r.MemoryBytes = n
+	}
+
+	return r, nil
+}
+
 const (
 
 	// TODO are these const defined somewhere in the docker API?
@@ -108,11 +342,121 @@ const (
 	timeoutError = -998
 )
 
-var svcChecker = time.NewTicker(watchDuration)
+// Service modes supported by RunServiceJob
+const (
+	ServiceModeReplicated    = "replicated"
+	ServiceModeReplicatedJob = "replicated-job"
+	ServiceModeGlobalJob     = "global-job"
+)
 
-func (j *RunServiceJob) watchContainer(ctx *Context, svcID string) error {
-	exitCode := swarmError
+func (j *RunServiceJob) buildMode() (swarm.ServiceMode, error) {
+	switch j.Mode {
+	case "", ServiceModeReplicated:
+		replicas := uint64(1)
+		return swarm.ServiceMode{
+			Replicated: &swarm.ReplicatedService{Replicas: &replicas},
+		}, nil
+	case ServiceModeReplicatedJob:
+		job := &swarm.ReplicatedJob{}
+		if j.MaxConcurrent != 0 {
+			job.MaxConcurrent = &j.MaxConcurrent
+		}
+
+		if j.TotalCompletions != 0 {
+			job.TotalCompletions = &j.TotalCompletions
+		}
+
+		return swarm.ServiceMode{ReplicatedJob: job}, nil
+	case ServiceModeGlobalJob:
+		return swarm.ServiceMode{GlobalJob: &swarm.GlobalJob{}}, nil
+	default:
+		return swarm.ServiceMode{}, fmt.Errorf("invalid service mode %q", j.Mode)
+	}
+}
 
+// completions returns the minimum number of tasks expected to finish before
+// considering the service completed, a global job runs a task per eligible
+// node.
+func (j *RunServiceJob) completions(nodes []swarm.Node) int {
+	switch j.Mode {
+	case ServiceModeReplicatedJob:
+		switch {
+		case j.TotalCompletions != 0:
+			return int(j.TotalCompletions)
+		case j.MaxConcurrent != 0:
+			return int(j.MaxConcurrent)
+		}
+	case ServiceModeGlobalJob:
+		var n int
+		for _, node := range nodes {
+			if nodeEligible(node, j.Constraint) {
+				n++
+			}
+		}
+
+		if n != 0 {
+			return n
+		}
+	}
+
+	return 1
+}
+
+// nodeEligible returns if a task may be scheduled on the node: the node is
+// ready, active and matches the placement constraints.
+func nodeEligible(n swarm.Node, constraints []string) bool {
+	if n.Status.State != swarm.NodeStateReady || n.Spec.Availability != swarm.NodeAvailabilityActive {
+		return false
+	}
+
+	for _, c := range constraints {
+		if !nodeMatches(n, c) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// nodeMatches returns if the node matches a `key==value` or `key!=value`
+// constraint, the unknown keys are considered as matching.
+func nodeMatches(n swarm.Node, constraint string) bool {
+	op, equal := "==", true
+	if strings.Contains(constraint, "!=") {
+		op, equal = "!=", false
+	}
+
+	parts := strings.SplitN(constraint, op, 2)
+	if len(parts) != 2 {
+		return true
+	}
+
+	key, value := strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1])
+
+	var actual string
+	switch {
+	case key == "node.id":
+		actual = n.ID
+	case key == "node.hostname":
+		actual = n.Description.Hostname
+	case key == "node.role":
+		actual = string(n.Spec.Role)
+	case key == "node.platform.os":
+		actual = n.Description.Platform.OS
+	case key == "node.platform.arch":
+		actual = n.Description.Platform.Architecture
+	case strings.HasPrefix(key, "node.labels."):
+		actual = n.Spec.Labels[strings.TrimPrefix(key, "node.labels.")]
+	case strings.HasPrefix(key, "engine.labels."):
+		actual = n.Description.Engine.Labels[strings.TrimPrefix(key, "engine.labels.")]
+	default:
+		return true
+	}
+
+	return strings.EqualFold(actual, value) == equal
+}
+
+func (j *RunServiceJob) watchContainer(ctx *Context, svcID string) error {
 	ctx.Logger.Noticef("Checking for service ID %s (%s) termination\n", svcID, j.Name)
 
 	svc, err := j.Client.InspectService(svcID)
@@ -120,35 +464,41 @@ func (j *RunServiceJob) watchContainer(ctx *Context, svcID string) error {
 		return fmt.Errorf("Failed to inspect service %s: %s", svcID, err.Error())
 	}
 
-	// On every tick, check if all the services have completed, or have error out
-	var wg sync.WaitGroup
-	wg.Add(1)
-
-	go func() {
-		defer wg.Done()
-		for _ = range svcChecker.C {
+	nodes := j.listNodes()
+	completions := j.completions(nodes)
 
-			if svc.CreatedAt.After(time.Now().Add(maxProcessDuration)) {
-				err = ErrMaxTimeRunning
-				return
-			}
+	start := time.Now()
+	ticker := time.NewTicker(watchDuration)
+	defer ticker.Stop()
 
-			taskExitCode, found := j.findtaskstatus(ctx, svc.ID)
+	// On every tick, check if all the tasks have completed, or have error out
+	var results []serviceTaskResult
+	for range ticker.C {
+		if time.Since(start) > maxProcessDuration {
+			ctx.Logger.Noticef("Service ID %s (%s) has completed with exit code %d\n", svcID, j.Name, timeoutError)
+			return ErrMaxTimeRunning
+		}
 
-			if found {
-				exitCode = taskExitCode
-				return
-			}
+		var found bool
+		if results, found = j.findtaskstatus(ctx, svc.ID, completions); found {
+			break
 		}
-	}()
+	}
 
-	wg.Wait()
+	names := nodeNames(nodes)
+	for _, r := range results {
+		ctx.Logger.Noticef(
+			"Service ID %s (%s) task %d on node %s has completed with state %s and exit code %d\n",
+			svcID, j.Name, r.Slot, nodeName(names, r.NodeID), r.State, r.ExitCode,
+		)
 
-	ctx.Logger.Noticef("Service ID %s (%s) has completed with exit code %d\n", svcID, j.Name, exitCode)
-	return err
+		ctx.Execution.AddTarget(nodeName(names, r.NodeID), r.ExitCode, r.err())
+	}
+
+	return serviceTasksError(results, names)
 }
 
-func (j *RunServiceJob) findtaskstatus(ctx *Context, taskID string) (int, bool) {
+func (j *RunServiceJob) findtaskstatus(ctx *Context, taskID string, completions int) ([]serviceTaskResult, bool) {
 	taskFilters := make(map[string][]string)
 	taskFilters["service"] = []string{taskID}
 
@@ -158,24 +508,52 @@ func (j *RunServiceJob) findtaskstatus(ctx *Context, taskID string) (int, bool)
 
 	if err != nil {
 		ctx.Logger.Errorf("Failed to find task ID %s. Considering the task terminated: %s\n", taskID, err.Error())
-		return 0, false
+		return nil, false
 	}
 
+	return serviceTasksStatus(tasks, completions)
+}
+
+// serviceTaskResult is the final state of a task from a service
+type serviceTaskResult struct {
+	NodeID   string
+	Slot     int
+	State    swarm.TaskState
+	ExitCode int
+	Error    string
+}
+
+// err returns the error of a task not completed, nil otherwise
+func (r serviceTaskResult) err() error {
+	switch {
+	case r.Error != "":
+		return errors.New(r.Error)
+	case r.State != swarm.TaskStateComplete:
+		return fmt.Errorf("task %s", r.State)
+	default:
+		return nil
+	}
+}
+
+// serviceTasksStatus returns the result of every task once all of them are
+// finished and at least the given number of tasks has been scheduled. With the
+// restart policy none the failed tasks are not replaced, so once every task is
+// finished and one of them failed, the missing completions never happen.
+func serviceTasksStatus(tasks []swarm.Task, completions int) ([]serviceTaskResult, bool) {
 	if len(tasks) == 0 {
 		// That task is gone now (maybe someone else removed it. Our work here is done
-		return 0, true
+		return nil, true
 	}
 
-	exitCode := 1
-	var done bool
 	stopStates := []swarm.TaskState{
 		swarm.TaskStateComplete,
 		swarm.TaskStateFailed,
 		swarm.TaskStateRejected,
 	}
 
+	var results []serviceTaskResult
+	var failed bool
 	for _, task := range tasks {
-
 		stop := false
 		for _, stopState := range stopStates {
 			if task.Status.State == stopState {
@@ -184,18 +562,104 @@ func (j *RunServiceJob) findtaskstatus(ctx *Context, taskID string) (int, bool)
 			}
 		}
 
-		if stop {
+		if !stop {
+			return nil, false
+		}
 
+		// a task rejected, or failed before starting, has no container
+		exitCode := 255
+		if task.Status.ContainerStatus != nil {
 			exitCode = task.Status.ContainerStatus.ExitCode
+		}
 
-			if exitCode == 0 && task.Status.State == swarm.TaskStateRejected {
-				exitCode = 255 // force non-zero exit for task rejected
-			}
-			done = true
-			break
+		if exitCode == 0 && task.Status.State != swarm.TaskStateComplete {
+			exitCode = 255 // force non-zero exit for task rejected or failed
+		}
+
+		failed = failed || exitCode != 0
+		results = append(results, serviceTaskResult{
+			NodeID:   task.NodeID,
+			Slot:     task.Slot,
+			State:    task.Status.State,
+			ExitCode: exitCode,
+			Error:    task.Status.Err,
+		})
+	}
+
+	if len(tasks) < completions && !failed {
+		return nil, false
+	}
+
+	return results, true
+}
+
+// serviceTasksError returns an error listing every failed task, if any
+func serviceTasksError(results []serviceTaskResult, nodes map[string]string) error {
+	var failed []string
+	for _, r := range results {
+		if r.ExitCode == 0 {
+			continue
 		}
+
+		failed = append(failed, fmt.Sprintf("%s (exit code %d)", nodeName(nodes, r.NodeID), r.ExitCode))
+	}
+
+	switch {
+	case len(failed) == 0:
+		return nil
+	case len(results) == 1 && results[0].ExitCode == -1:
+		return ErrUnexpected
+	case len(results) == 1:
+		return fmt.Errorf("error non-zero exit code: %d", results[0].ExitCode)
+	default:
+		return fmt.Errorf(
+			"error non-zero exit code on %d of %d tasks: %s",
+			len(failed), len(results), strings.Join(failed, ", "),
+		)
+	}
+}
+
+// listNodes returns the nodes of the swarm, best effort
+func (j *RunServiceJob) listNodes() []swarm.Node {
+	nodes, _ := j.Client.ListNodes(docker.ListNodesOptions{})
+	return nodes
+}
+
+// nodeNames returns the hostname of the swarm nodes indexed by ID
+func nodeNames(nodes []swarm.Node) map[string]string {
+	names := make(map[string]string)
+	for _, n := range nodes {
+		names[n.ID] = n.Description.Hostname
+	}
+
+	return names
+}
+
+func nodeName(nodes map[string]string, id string) string {
+	if name, ok := nodes[id]; ok && name != "" {
+		return name
+	}
+
+	return id
+}
+
+// serviceLogs collects the output of the service tasks into the execution
+// streams, a failure here doesn't fail the execution since the service may be
+// already gone. The service is created by the execution, so all its logs are
+// collected, a since filter has only a precision of seconds.
+func (j *RunServiceJob) serviceLogs(ctx *Context, svcID string) {
+	err := j.Client.GetServiceLogs(docker.LogsServiceOptions{
+		Service:      svcID,
+		OutputStream: ctx.Execution.OutputStream,
+		ErrorStream:  ctx.Execution.ErrorStream,
+		Stdout:       true,
+		Stderr:       true,
+		RawTerminal:  j.TTY,
+	})
+
+	if err != nil {
+		ctx.Logger.Warningf("Unable to retrieve logs of service %s: %s", svcID, err)
 	}
-	return exitCode, done
 }
 
 func (j *RunServiceJob) deleteService(ctx *Context, svcID string) error {
@@ -8,6 +8,7 @@ import (
 	"sync"
 	"time"
 
+	"github.com/docker/docker/api/types/mount"
 	"github.com/docker/docker/api/types/swarm"
 	docker "github.com/fsouza/go-dockerclient"
 	"github.com/fsouza/go-dockerclient/testing"
@@ -92,6 +93,164 @@ func (s *SuiteRunServiceJob) TestRun(c *C) {
 	c.Assert(containers, HasLen, 0)
 }
 
+func (s *SuiteRunServiceJob) TestBuildService(c *C) {
+	job := &RunServiceJob{Client: s.client}
+	job.Image = ServiceImageFixture
+	job.Command = `sh -c "echo foo bar"`
+	job.User = "foo"
+	job.TTY = true
+	job.Dir = "/tmp"
+	job.Environment = []string{"FOO=bar"}
+	job.Volume = []string{"/tmp/foo:/foo:ro", "data:/data"}
+	job.Constraint = []string{"node.role == worker"}
+	job.CPULimit = "0.5"
+	job.MemoryLimit = "64m"
+	job.MemoryReservation = "32m"
+
+	svc, err := job.buildService()
+	c.Assert(err, IsNil)
+
+	svc, err = s.client.InspectService(svc.ID)
+	c.Assert(err, IsNil)
+
+	spec := svc.Spec.TaskTemplate.ContainerSpec
+	c.Assert(spec.Command, DeepEquals, []string{"sh", "-c", "echo foo bar"})
+	c.Assert(spec.User, Equals, "foo")
+	c.Assert(spec.TTY, Equals, true)
+	c.Assert(spec.Dir, Equals, "/tmp")
+	c.Assert(spec.Env, DeepEquals, []string{"FOO=bar"})
+	c.Assert(spec.Mounts, HasLen, 2)
+	c.Assert(spec.Mounts[0].Type, Equals, mount.TypeBind)
+	c.Assert(spec.Mounts[0].ReadOnly, Equals, true)
+	c.Assert(spec.Mounts[1].Type, Equals, mount.TypeVolume)
+	c.Assert(spec.Mounts[1].Source, Equals, "data")
+
+	tmpl := svc.Spec.TaskTemplate
+	c.Assert(tmpl.Placement.Constraints, DeepEquals, []string{"node.role == worker"})
+	c.Assert(tmpl.Resources.Limits.NanoCPUs, Equals, int64(5e8))
+	c.Assert(tmpl.Resources.Limits.MemoryBytes, Equals, int64(64*1024*1024))
+	c.Assert(tmpl.Resources.Reservations.MemoryBytes, Equals, int64(32*1024*1024))
+}
+
+func (s *SuiteRunServiceJob) TestBuildServiceMountsInvalid(c *C) {
+	_, err := buildServiceMounts([]string{"/tmp"})
+	c.Assert(err, NotNil)
+
+	_, err = buildServiceMounts([]string{"/tmp:/tmp:foo"})
+	c.Assert(err, NotNil)
+}
+
+func (s *SuiteRunServiceJob) TestBuildServiceReplicatedJob(c *C) {
+	job := &RunServiceJob{Client: s.client}
+	job.Image = ServiceImageFixture
+	job.Mode = ServiceModeReplicatedJob
+	job.MaxConcurrent = 2
+	job.TotalCompletions = 4
+
+	svc, err := job.buildService()
+	c.Assert(err, IsNil)
+
+	svc, err = s.client.InspectService(svc.ID)
+	c.Assert(err, IsNil)
+	c.Assert(*svc.Spec.Mode.ReplicatedJob.MaxConcurrent, Equals, uint64(2))
+	c.Assert(*svc.Spec.Mode.ReplicatedJob.TotalCompletions, Equals, uint64(4))
+	c.Assert(job.completions(nil), Equals, 4)
+}
+
+func (s *SuiteRunServiceJob) TestBuildServiceInvalidMode(c *C) {
+	job := &RunServiceJob{Client: s.client}
+	job.Image = ServiceImageFixture
+	job.Mode = "foo"
+
+	_, err := job.buildService()
+	c.Assert(err, NotNil)
+}
+
+func (s *SuiteRunServiceJob) TestServiceTasksStatus(c *C) {
+	task := func(node string, state swarm.TaskState, code int) swarm.Task {
+		t := swarm.Task{NodeID: node}
+		t.Status.State = state
+		t.Status.ContainerStatus = &swarm.ContainerStatus{ExitCode: code}
+		return t
+	}
+
+	_, done := serviceTasksStatus([]swarm.Task{
+		task("a", swarm.TaskStateComplete, 0),
+		task("b", swarm.TaskStateRunning, 0),
+	}, 1)
+	c.Assert(done, Equals, false)
+
+	_, done = serviceTasksStatus([]swarm.Task{
+		task("a", swarm.TaskStateComplete, 0),
+	}, 2)
+	c.Assert(done, Equals, false)
+
+	// the failed task is not replaced, the third completion never happens
+	results, done := serviceTasksStatus([]swarm.Task{
+		task("a", swarm.TaskStateComplete, 0),
+		task("b", swarm.TaskStateFailed, 1),
+	}, 3)
+	c.Assert(done, Equals, true)
+	c.Assert(results, HasLen, 2)
+
+	_, done = serviceTasksStatus([]swarm.Task{
+		task("a", swarm.TaskStateFailed, 1),
+		task("b", swarm.TaskStateRunning, 0),
+	}, 3)
+	c.Assert(done, Equals, false)
+
+	results, done = serviceTasksStatus([]swarm.Task{
+		task("a", swarm.TaskStateComplete, 0),
+		task("b", swarm.TaskStateFailed, 2),
+		task("c", swarm.TaskStateRejected, 0),
+	}, 2)
+	c.Assert(done, Equals, true)
+	c.Assert(results, HasLen, 3)
+
+	err := serviceTasksError(results, map[string]string{"b": "node-b"})
+	c.Assert(err, ErrorMatches, "error non-zero exit code on 2 of 3 tasks: node-b \\(exit code 2\\), c \\(exit code 255\\)")
+
+	c.Assert(serviceTasksError(results[:1], nil), IsNil)
+
+	rejected := swarm.Task{NodeID: "d"}
+	rejected.Status.State = swarm.TaskStateRejected
+	rejected.Status.Err = "no suitable node"
+
+	results, done = serviceTasksStatus([]swarm.Task{rejected}, 1)
+	c.Assert(done, Equals, true)
+	c.Assert(results[0].ExitCode, Equals, 255)
+	c.Assert(results[0].err(), ErrorMatches, "no suitable node")
+}
+
+func (s *SuiteRunServiceJob) TestCompletionsGlobalJob(c *C) {
+	node := func(id string, role swarm.NodeRole, state swarm.NodeState, availability swarm.NodeAvailability) swarm.Node {
+		n := swarm.Node{ID: id}
+		n.Spec.Role = role
+		n.Spec.Availability = availability
+		n.Spec.Labels = map[string]string{"disk": "ssd"}
+		n.Status.State = state
+		return n
+	}
+
+	nodes := []swarm.Node{
+		node("a", swarm.NodeRoleManager, swarm.NodeStateReady, swarm.NodeAvailabilityActive),
+		node("b", swarm.NodeRoleWorker, swarm.NodeStateReady, swarm.NodeAvailabilityActive),
+		node("c", swarm.NodeRoleWorker, swarm.NodeStateReady, swarm.NodeAvailabilityActive),
+		node("d", swarm.NodeRoleWorker, swarm.NodeStateDown, swarm.NodeAvailabilityActive),
+		node("e", swarm.NodeRoleWorker, swarm.NodeStateReady, swarm.NodeAvailabilityDrain),
+	}
+
+	job := &RunServiceJob{Mode: ServiceModeGlobalJob}
+	c.Assert(job.completions(nodes), Equals, 3)
+	c.Assert(job.completions(nil), Equals, 1)
+
+	job.Constraint = []string{"node.role == worker", "node.labels.disk==ssd", "node.id != c"}
+	c.Assert(job.completions(nodes), Equals, 1)
+
+	job.Mode = ServiceModeReplicated
+	c.Assert(job.completions(nodes), Equals, 1)
+}
+
 func (s *SuiteRunServiceJob) TestBuildPullImageOptionsBareImage(c *C) {
 	o, _ := buildPullOptions("foo")
 	c.Assert(o.Repository, Equals, "foo")
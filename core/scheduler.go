@@ -1,9 +1,13 @@
 package core
 
 import (
+	"bufio"
 	"errors"
 	"fmt"
+	"io"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/robfig/cron"
 )
@@ -16,11 +20,28 @@ var (
 type Scheduler struct {
 	Jobs   []Job
 	Logger Logger
+	// Store records the executions of the jobs, if any
+	Store ExecutionStore
+	// LogOutputLines logs the output of the executions line by line, instead
+	// of as a single message
+	LogOutputLines bool
+	// LogOutputMaxSize is the number of bytes logged of each output, the last
+	// ones, or the first ones when logged live, all of them if zero
+	LogOutputMaxSize int
+	// LogOutputLive logs the output line by line while the job runs, instead
+	// of once finished
+	LogOutputLive bool
+	// Redactor replaces the secrets in the outputs and the errors of the
+	// executions, if any
+	Redactor *Redactor
 
 	middlewareContainer
 	cron      *cron.Cron
 	wg        sync.WaitGroup
 	isRunning bool
+
+	mu      sync.Mutex
+	running map[string]*Execution
 }
 
 func NewScheduler(l Logger) *Scheduler {
@@ -64,20 +85,103 @@ func (s *Scheduler) Start() error {
 	return nil
 }
 
+// RunJob runs the job with the given name once, now, using the given
+// execution, so its output can be followed. It returns once the execution is
+// finished.
+func (s *Scheduler) RunJob(name string, e *Execution) error {
+	for _, j := range s.Jobs {
+		if j.GetName() == name {
+			s.mergeMiddlewares()
+			(&jobWrapper{s, j}).run(e)
+			return nil
+		}
+	}
+
+	return fmt.Errorf("unknown job %q", name)
+}
+
+// RunningExecution returns the execution of the job with the given ID, nil if
+// it isn't running
+func (s *Scheduler) RunningExecution(job, id string) *Execution {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.running[job+"/"+id]
+}
+
+func (s *Scheduler) track(j Job, e *Execution) func() {
+	key := j.GetName() + "/" + e.ID
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.running == nil {
+		s.running = make(map[string]*Execution)
+	}
+
+	s.running[key] = e
+	return func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		delete(s.running, key)
+	}
+}
+
 func (s *Scheduler) mergeMiddlewares() {
 	for _, j := range s.Jobs {
 		j.Use(s.Middlewares()...)
 	}
 }
 
+// Stop stops scheduling the jobs and waits for the running executions
 func (s *Scheduler) Stop() error {
-	s.wg.Wait()
 	s.cron.Stop()
+	s.wg.Wait()
 	s.isRunning = false
 
 	return nil
 }
 
+// Kill stops scheduling the jobs, kills the running executions of the jobs
+// implementing Killer and waits for them
+func (s *Scheduler) Kill() error {
+	s.cron.Stop()
+	for _, j := range s.Jobs {
+		if k, ok := j.(Killer); ok {
+			k.Kill()
+		}
+	}
+
+	return s.Stop()
+}
+
+// Shutdown stops scheduling the jobs, asks the jobs implementing Killer to
+// terminate their running executions and waits for them, killing them once the
+// grace period is over
+func (s *Scheduler) Shutdown(grace time.Duration) error {
+	s.cron.Stop()
+	for _, j := range s.Jobs {
+		if k, ok := j.(Killer); ok {
+			k.Terminate()
+		}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return s.Stop()
+	case <-time.After(grace):
+		s.Logger.Warningf("Killing running jobs.")
+		return s.Kill()
+	}
+}
+
 func (s *Scheduler) IsRunning() bool {
 	return s.isRunning
 }
@@ -88,15 +192,36 @@ type jobWrapper struct {
 }
 
 func (w *jobWrapper) Run() {
+	w.run(NewExecution())
+}
+
+func (w *jobWrapper) run(e *Execution) {
 	w.s.wg.Add(1)
 	defer w.s.wg.Done()
+	defer w.s.track(w.j, e)()
+
+	if size := w.j.GetMaxOutputSize(); size != 0 {
+		e.SetMaxOutputSize(size)
+	}
+
+	e.SetRedactor(w.s.Redactor)
 
-	e := NewExecution()
 	ctx := NewContext(w.s, w.j, e)
 
+	var live sync.WaitGroup
 	w.start(ctx)
+	if w.s.LogOutputLive {
+		w.followOutput(ctx, &live)
+	}
+
 	err := ctx.Next()
+	ctx.Stop(err)
+	live.Wait()
 	w.stop(ctx, err)
+
+	// the outputs may be large, they are kept on disk until every middleware
+	// and the store are done with them
+	e.Release()
 }
 
 func (w *jobWrapper) start(ctx *Context) {
@@ -112,21 +237,97 @@ func (w *jobWrapper) stop(ctx *Context, err error) {
 		errText = ctx.Execution.Error.Error()
 	}
 
-	output := ctx.Execution.OutputStream.Bytes()
-
-	if len(output) > 0 {
-		ctx.Log("Output: " + string(output))
-	}
-
-	errors := ctx.Execution.ErrorStream.Bytes()
-	if len(output) > 0 {
-		ctx.Log("Stderr: " + string(errors))
+	e := ctx.Execution
+	if !w.s.LogOutputLive {
+		w.logOutput(ctx, "Output", e.OutputStream.Bytes())
+		w.logOutput(ctx, "Stderr", e.ErrorStream.Bytes())
 	}
 
 	msg := fmt.Sprintf(
 		"Finished in %q, failed: %t, skipped: %t, error: %s",
-		ctx.Execution.Duration, ctx.Execution.Failed, ctx.Execution.Skipped, errText,
+		e.Duration, e.Failed, e.Skipped, errText,
 	)
 
-	ctx.Log(msg)
+	ctx.LogFields(msg, Fields{
+		"duration":  e.Duration.String(),
+		"status":    e.Status(),
+		"exit_code": e.ExitCode,
+	})
+
+	if w.s.Store != nil {
+		if err := w.s.Store.Save(NewExecutionRecord(ctx.Job, ctx.Execution)); err != nil {
+			ctx.Logger.Errorf("Error saving execution %s of job %q: %s", ctx.Execution.ID, ctx.Job.GetName(), err)
+		}
+	}
+}
+
+// logOutput logs an output of the execution, as a single message or line by
+// line, prefixed by the name of the stream
+func (w *jobWrapper) logOutput(ctx *Context, stream string, output []byte) {
+	if len(output) == 0 {
+		return
+	}
+
+	if max := w.s.LogOutputMaxSize; max > 0 && len(output) > max {
+		ctx.Log(fmt.Sprintf("%s truncated, first %d bytes omitted", stream, len(output)-max))
+		output = output[len(output)-max:]
+	}
+
+	if !w.s.LogOutputLines {
+		ctx.Log(stream + ": " + string(output))
+		return
+	}
+
+	fields := Fields{"stream": strings.ToLower(stream)}
+	for _, line := range strings.Split(strings.TrimRight(string(output), "\n"), "\n") {
+		ctx.LogFields(stream+": "+line, fields)
+	}
+}
+
+// followOutput logs the lines of the outputs as they are written, until the
+// execution is stopped
+func (w *jobWrapper) followOutput(ctx *Context, wg *sync.WaitGroup) {
+	streams := map[string]*Stream{
+		"Output": ctx.Execution.OutputStream,
+		"Stderr": ctx.Execution.ErrorStream,
+	}
+
+	for name, stream := range streams {
+		wg.Add(1)
+		go func(name string, r io.Reader) {
+			defer wg.Done()
+			w.logLines(ctx, name, r)
+		}(name, stream.Follow())
+	}
+}
+
+// logLines logs the lines read, until the max size is reached. The context
+// isn't used to log, the execution is updated while the lines are read.
+func (w *jobWrapper) logLines(ctx *Context, stream string, r io.Reader) {
+	l := ctx.Logger
+	if fl, ok := l.(FieldLogger); ok {
+		l = fl.WithFields(Fields{"stream": strings.ToLower(stream)})
+	}
+
+	prefix := fmt.Sprintf("[Job %q (%s %s)] %s: ", ctx.Job.GetName(), ctx.Job.GetLabel(), ctx.Execution.ID, stream)
+	max := w.s.LogOutputMaxSize
+
+	var size, logged int
+	br := bufio.NewReader(r)
+	for {
+		line, err := br.ReadString('\n')
+		if line != "" && (max <= 0 || size < max) {
+			l.Noticef("%s%s", prefix, strings.TrimSuffix(line, "\n"))
+			logged += len(line)
+		}
+
+		size += len(line)
+		if err != nil {
+			break
+		}
+	}
+
+	if logged < size {
+		l.Noticef("%s truncated, last %d bytes omitted", prefix[:len(prefix)-2], size-logged)
+	}
 }
@@ -1,6 +1,12 @@
 package core
 
 import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
 	"time"
 
 	. "gopkg.in/check.v1"
@@ -32,7 +38,7 @@ func (s *SuiteScheduler) TestStartStop(c *C) {
 	err := sc.AddJob(job)
 	c.Assert(err, IsNil)
 
-	sc.Start(true)
+	sc.Start()
 	c.Assert(sc.IsRunning(), Equals, true)
 
 	time.Sleep(time.Second * 2)
@@ -41,6 +47,169 @@ func (s *SuiteScheduler) TestStartStop(c *C) {
 	c.Assert(sc.IsRunning(), Equals, false)
 }
 
+func (s *SuiteScheduler) TestShutdown(c *C) {
+	job := &LocalJob{}
+	job.Name = "foo"
+	job.Schedule = "@hourly"
+	job.Command = `trap '' TERM; sleep 10`
+	job.Shell = "/bin/sh"
+
+	sc := NewScheduler(&TestLogger{})
+	c.Assert(sc.AddJob(job), IsNil)
+
+	e := NewExecution()
+	go sc.RunJob("foo", e)
+	for job.runningProcesses() == 0 {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	start := time.Now()
+	c.Assert(sc.Shutdown(200*time.Millisecond), IsNil)
+	c.Assert(time.Since(start) >= 200*time.Millisecond, Equals, true)
+	c.Assert(time.Since(start) < time.Second*5, Equals, true)
+	c.Assert(e.IsRunning, Equals, false)
+}
+
+func (s *SuiteScheduler) TestStore(c *C) {
+	dir, err := ioutil.TempDir("", "store")
+	c.Assert(err, IsNil)
+	defer os.RemoveAll(dir)
+
+	store, err := NewBoltStore(filepath.Join(dir, "ofelia.db"))
+	c.Assert(err, IsNil)
+	defer store.Close()
+
+	job := &TestJob{}
+	job.Name = "foo"
+	job.Schedule = "@hourly"
+
+	sc := NewScheduler(&TestLogger{})
+	sc.Store = store
+	c.Assert(sc.AddJob(job), IsNil)
+
+	sc.cron.Entries()[0].Job.Run()
+
+	records, err := store.Query(ExecutionQuery{Job: "foo"})
+	c.Assert(err, IsNil)
+	c.Assert(records, HasLen, 1)
+	c.Assert(records[0].Status, Equals, ExecutionStatusSuccessful)
+}
+
+func (s *SuiteScheduler) TestMaxOutputSize(c *C) {
+	dir, err := ioutil.TempDir("", "store")
+	c.Assert(err, IsNil)
+	defer os.RemoveAll(dir)
+
+	store, err := NewBoltStore(filepath.Join(dir, "ofelia.db"))
+	c.Assert(err, IsNil)
+	defer store.Close()
+
+	job := &outputJob{}
+	job.Name = "foo"
+	job.Schedule = "@hourly"
+	job.MaxOutputSize = 8
+
+	sc := NewScheduler(&TestLogger{})
+	sc.Store = store
+	c.Assert(sc.AddJob(job), IsNil)
+
+	e := NewExecution()
+	c.Assert(sc.RunJob("foo", e), IsNil)
+	c.Assert(e.OutputStream.Bytes(), IsNil)
+
+	records, err := store.Query(ExecutionQuery{Job: "foo"})
+	c.Assert(err, IsNil)
+	c.Assert(records, HasLen, 1)
+	c.Assert(records[0].Output, Equals, "foo\n\n[... 8 bytes truncated ...]\nqux\n")
+	c.Assert(records[0].Truncated, Equals, true)
+}
+
+func (s *SuiteScheduler) TestLogOutput(c *C) {
+	job := &outputJob{}
+	job.Name = "foo"
+	job.Schedule = "@hourly"
+	job.LogLevel = "warning"
+
+	l := &testFieldLogger{}
+	sc := NewScheduler(l)
+	sc.LogOutputLines = true
+	sc.LogOutputMaxSize = 8
+	c.Assert(sc.AddJob(job), IsNil)
+
+	sc.cron.Entries()[0].Job.Run()
+
+	c.Assert(l.lines, HasLen, 5)
+	c.Assert(l.lines[0].msg, Matches, `New job registered "foo".*`)
+
+	id := l.lines[1].fields["execution"].(string)
+	c.Assert(l.lines[1], DeepEquals, testLogLine{
+		level:  "error",
+		msg:    `[Job "foo" ( ` + id + `)] Output truncated, first 8 bytes omitted`,
+		fields: Fields{"job": "foo", "execution": id},
+	})
+
+	c.Assert(l.lines[2].msg, Matches, `.*\] Output: baz`)
+	c.Assert(l.lines[2].fields["stream"], Equals, "output")
+	c.Assert(l.lines[3].msg, Matches, `.*\] Output: qux`)
+	c.Assert(l.lines[4].msg, Matches, `.*\] Finished in .*`)
+	c.Assert(l.lines[4].fields["status"], Equals, ExecutionStatusFailed)
+	c.Assert(l.lines[4].fields["exit_code"], Equals, 1)
+}
+
+func (s *SuiteScheduler) TestRunJob(c *C) {
+	job := &outputJob{}
+	job.Name = "foo"
+	job.Schedule = "@hourly"
+
+	l := &testFieldLogger{}
+	sc := NewScheduler(l)
+	sc.LogOutputLive = true
+	c.Assert(sc.AddJob(job), IsNil)
+
+	e := NewExecution()
+	output := e.OutputStream.Follow()
+	c.Assert(sc.RunJob("foo", e), IsNil)
+	c.Assert(sc.RunningExecution("foo", e.ID), IsNil)
+	c.Assert(e.Failed, Equals, true)
+
+	b, err := ioutil.ReadAll(output)
+	c.Assert(err, IsNil)
+	c.Assert(string(b), Equals, "foo\nbar\nbaz\nqux\n")
+
+	var lines []string
+	for _, l := range l.lines {
+		if l.fields["stream"] == "output" {
+			lines = append(lines, l.msg[strings.Index(l.msg, "] ")+2:])
+		}
+	}
+
+	c.Assert(lines, DeepEquals, []string{"Output: foo", "Output: bar", "Output: baz", "Output: qux"})
+	c.Assert(sc.RunJob("bar", NewExecution()), ErrorMatches, `unknown job "bar"`)
+}
+
+func (s *SuiteScheduler) TestRunningExecution(c *C) {
+	job := &TestJob{}
+	job.Name = "foo"
+	job.Schedule = "@hourly"
+
+	sc := NewScheduler(&TestLogger{})
+	c.Assert(sc.AddJob(job), IsNil)
+
+	e := NewExecution()
+	done := make(chan struct{})
+	go func() {
+		sc.RunJob("foo", e)
+		close(done)
+	}()
+
+	time.Sleep(100 * time.Millisecond)
+	c.Assert(sc.RunningExecution("foo", e.ID), Equals, e)
+	c.Assert(sc.RunningExecution("bar", e.ID), IsNil)
+
+	<-done
+	c.Assert(sc.RunningExecution("foo", e.ID), IsNil)
+}
+
 func (s *SuiteScheduler) TestMergeMiddlewaresSame(c *C) {
 	mA, mB, mC := &TestMiddleware{}, &TestMiddleware{}, &TestMiddleware{}
 
@@ -57,3 +226,90 @@ func (s *SuiteScheduler) TestMergeMiddlewaresSame(c *C) {
 	c.Assert(m, HasLen, 1)
 	c.Assert(m[0], Equals, mB)
 }
+
+type outputJob struct {
+	BareJob
+}
+
+func (j *outputJob) Run(ctx *Context) error {
+	ctx.Execution.OutputStream.Write([]byte("foo\nbar\nbaz\nqux\n"))
+	ctx.Execution.ExitCode = 1
+
+	return errors.New("failed")
+}
+
+type testLogLine struct {
+	level  string
+	msg    string
+	fields Fields
+}
+
+// testFieldLogger records the lines logged at or above its level, all of them
+// if not set
+type testFieldLogger struct {
+	lines  []testLogLine
+	level  string
+	fields Fields
+	parent *testFieldLogger
+}
+
+var testLogLevels = map[string]int{"critical": 0, "error": 1, "warning": 2, "notice": 3, "debug": 4}
+
+func (l *testFieldLogger) WithFields(fields Fields) FieldLogger {
+	c := *l
+	c.fields = Fields{}
+	for k, v := range l.fields {
+		c.fields[k] = v
+	}
+
+	for k, v := range fields {
+		c.fields[k] = v
+	}
+
+	c.parent = l.root()
+	return &c
+}
+
+func (l *testFieldLogger) WithLevel(level string) FieldLogger {
+	c := *l
+	c.level = level
+	c.parent = l.root()
+	return &c
+}
+
+func (l *testFieldLogger) root() *testFieldLogger {
+	if l.parent != nil {
+		return l.parent
+	}
+
+	return l
+}
+
+func (l *testFieldLogger) log(level, format string, args ...interface{}) {
+	if l.level != "" && testLogLevels[level] > testLogLevels[l.level] {
+		return
+	}
+
+	r := l.root()
+	r.lines = append(r.lines, testLogLine{level, fmt.Sprintf(format, args...), l.fields})
+}
+
+func (l *testFieldLogger) Criticalf(format string, args ...interface{}) {
+	l.log("critical", format, args...)
+}
+
+func (l *testFieldLogger) Debugf(format string, args ...interface{}) {
+	l.log("debug", format, args...)
+}
+
+func (l *testFieldLogger) Errorf(format string, args ...interface{}) {
+	l.log("error", format, args...)
+}
+
+func (l *testFieldLogger) Noticef(format string, args ...interface{}) {
+	l.log("notice", format, args...)
+}
+
+func (l *testFieldLogger) Warningf(format string, args ...interface{}) {
+	l.log("warning", format, args...)
+}
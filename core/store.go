@@ -0,0 +1,116 @@
+package core
+
+import (
+	"time"
+)
+
+// Statuses of the executions recorded by an ExecutionStore
+const (
+	ExecutionStatusSuccessful = "successful"
+	ExecutionStatusFailed     = "failed"
+	ExecutionStatusSkipped    = "skipped"
+)
+
+// ExecutionStore records the executions of the jobs, so the history survives
+// the restarts of the daemon and the rebuilds of the scheduler.
+type ExecutionStore interface {
+	// Save records a finished execution
+	Save(*ExecutionRecord) error
+	// Query returns the executions matching the query, newest first
+	Query(ExecutionQuery) ([]*ExecutionRecord, error)
+	// Prune deletes the executions not kept by the policy, returning how many
+	// were deleted
+	Prune(RetentionPolicy) (int, error)
+	Close() error
+}
+
+// ExecutionRecord is the metadata and the output of an execution, as recorded
+// by an ExecutionStore
+type ExecutionRecord struct {
+	Job       string
+	ID        string
+	Date      time.Time
+	Duration  time.Duration
+	Status    string
+	ExitCode  int
+	Error     string `json:",omitempty"`
+	Output    string `json:",omitempty"`
+	Stderr    string `json:",omitempty"`
+	Truncated bool   `json:",omitempty"`
+}
+
+// NewExecutionRecord returns the record of the execution of a job
+func NewExecutionRecord(j Job, e *Execution) *ExecutionRecord {
+	r := &ExecutionRecord{
+		Job:      j.GetName(),
+		ID:       e.ID,
+		Date:     e.Date,
+		Duration: e.Duration,
+		Status:   e.Status(),
+		ExitCode: e.ExitCode,
+		Output:   e.OutputStream.String(),
+		Stderr:   e.ErrorStream.String(),
+	}
+
+	if e.Error != nil {
+		r.Error = e.Error.Error()
+	}
+
+	r.Truncated = e.OutputStream.Truncated() || e.ErrorStream.Truncated()
+
+	return r
+}
+
+// Truncate keeps the last size bytes of each output
+func (r *ExecutionRecord) Truncate(size int) {
+	if len(r.Output) > size {
+		r.Output = r.Output[len(r.Output)-size:]
+		r.Truncated = true
+	}
+
+	if len(r.Stderr) > size {
+		r.Stderr = r.Stderr[len(r.Stderr)-size:]
+		r.Truncated = true
+	}
+}
+
+// ExecutionQuery filters the executions returned by an ExecutionStore, the
+// empty fields match every execution
+type ExecutionQuery struct {
+	Job    string
+	Status string
+	Since  time.Time
+	Until  time.Time
+	// Limit is the maximum number of executions returned
+	Limit int
+}
+
+// Match returns if the record matches the query
+func (q *ExecutionQuery) Match(r *ExecutionRecord) bool {
+	switch {
+	case q.Job != "" && r.Job != q.Job:
+		return false
+	case q.Status != "" && r.Status != q.Status:
+		return false
+	case !q.Since.IsZero() && r.Date.Before(q.Since):
+		return false
+	case !q.Until.IsZero() && !r.Date.Before(q.Until):
+		return false
+	}
+
+	return true
+}
+
+// RetentionPolicy defines the executions kept by an ExecutionStore, the zero
+// values keep every execution
+type RetentionPolicy struct {
+	// MaxAge deletes the executions older than this age
+	MaxAge time.Duration
+	// MaxExecutions is the number of executions kept per job
+	MaxExecutions int
+}
+
+// IsZero returns if the policy keeps every execution
+func (p RetentionPolicy) IsZero() bool {
+	return p.MaxAge <= 0 && p.MaxExecutions <= 0
+}
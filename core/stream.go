@@ -0,0 +1,374 @@
+package core
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sync"
+)
+
+var (
+	// streamMemorySize is the number of bytes of a stream kept in memory, the
+	// output is moved to a temporary file past it
+	streamMemorySize = 1024 * 1024
+	// streamTempDir is the directory of the temporary files, the default one
+	// of the system if empty
+	streamTempDir = ""
+	// streamMaxLine is the number of bytes of a line held to be redacted, the
+	// longer lines are redacted in pieces
+	streamMaxLine = 64 * 1024
+)
+
+// Stream is an output of an execution. It keeps the first and the last bytes
+// written, up to its max size, in a buffer growing as needed and moved to a
+// temporary file once large. The written bytes are fanned out to the readers
+// following the stream, so the output can be tailed while the job runs.
+//
+// Once the max size is exceeded, the first half of it is kept and the second
+// half is a circular buffer with the last bytes, the bytes in between are
+// dropped and replaced by a marker.
+type Stream struct {
+	mu   sync.Mutex
+	cond *sync.Cond
+
+	max   int64
+	total int64
+	mem   []byte
+	file  *os.File
+	err   error
+
+	redactor *Redactor
+	pending  []byte
+
+	closed   bool
+	released bool
+	freed    bool
+	readers  int
+}
+
+// NewStream returns a Stream keeping up to size bytes, all of them if zero
+func NewStream(size int64) *Stream {
+	s := &Stream{max: size}
+	s.cond = sync.NewCond(&s.mu)
+	return s
+}
+
+// SetMaxSize changes the number of bytes kept, it must be called before the
+// first write
+func (s *Stream) SetMaxSize(size int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.total == 0 {
+		s.max = size
+	}
+}
+
+// SetRedactor redacts the bytes written, line by line, before storing them,
+// it must be called before the first write
+func (s *Stream) SetRedactor(r *Redactor) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.redactor = r
+}
+
+// Write stores the bytes and wakes up the readers, it never blocks on a slow
+// reader. When redacted, the bytes are stored once their line is complete.
+func (s *Stream) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.err != nil {
+		return 0, s.err
+	}
+
+	if s.redactor.IsEmpty() {
+		return len(p), s.write(p)
+	}
+
+	s.pending = append(s.pending, p...)
+	if i := bytes.LastIndexByte(s.pending, '\n'); i >= 0 || len(s.pending) > streamMaxLine {
+		end := len(s.pending)
+		if i >= 0 {
+			end = i + 1
+		}
+
+		if err := s.write(s.redactor.Redact(s.pending[:end])); err != nil {
+			return 0, err
+		}
+
+		s.pending = append([]byte(nil), s.pending[end:]...)
+	}
+
+	return len(p), nil
+}
+
+// flush stores the pending bytes, the last line without line break
+func (s *Stream) flush() {
+	if len(s.pending) > 0 && s.err == nil {
+		s.write(s.redactor.Redact(s.pending))
+	}
+
+	s.pending = nil
+}
+
+func (s *Stream) write(p []byte) error {
+	for written := 0; written < len(p); {
+		off, n := s.position(s.total, int64(len(p)-written))
+		if err := s.writeAt(p[written:written+int(n)], off); err != nil {
+			s.err = err
+			return err
+		}
+
+		written += int(n)
+		s.total += n
+	}
+
+	s.cond.Broadcast()
+	return nil
+}
+
+// position returns where the byte at the given offset of the output is
+// stored, and how many of the following bytes are stored contiguously
+func (s *Stream) position(off, n int64) (int64, int64) {
+	if s.max <= 0 {
+		return off, n
+	}
+
+	head := s.max / 2
+	if off < head {
+		return off, min64(n, head-off)
+	}
+
+	tail := s.max - head
+	pos := (off - head) % tail
+	return head + pos, min64(n, tail-pos)
+}
+
+func (s *Stream) writeAt(p []byte, off int64) error {
+	end := off + int64(len(p))
+	if s.file == nil && end > int64(streamMemorySize) {
+		if err := s.spill(); err != nil {
+			return err
+		}
+	}
+
+	if s.file != nil {
+		_, err := s.file.WriteAt(p, off)
+		return err
+	}
+
+	if end > int64(len(s.mem)) {
+		s.mem = append(s.mem, make([]byte, end-int64(len(s.mem)))...)
+	}
+
+	copy(s.mem[off:], p)
+	return nil
+}
+
+// spill moves the bytes in memory to a temporary file
+func (s *Stream) spill() error {
+	f, err := ioutil.TempFile(streamTempDir, "ofelia-output")
+	if err != nil {
+		return fmt.Errorf("error creating output file: %s", err)
+	}
+
+	if _, err := f.Write(s.mem); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return fmt.Errorf("error writing output file: %s", err)
+	}
+
+	s.file, s.mem = f, nil
+	return nil
+}
+
+func (s *Stream) readAt(p []byte, off int64) error {
+	if s.file != nil {
+		_, err := s.file.ReadAt(p, off)
+		return err
+	}
+
+	copy(p, s.mem[off:])
+	return nil
+}
+
+// Close marks the end of the stream, the readers return io.EOF once they
+// have read everything
+func (s *Stream) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.flush()
+	s.closed = true
+	s.cond.Broadcast()
+	return nil
+}
+
+// Release frees the memory and the temporary file of the stream, once the
+// readers following it are done. The stream is empty afterwards.
+func (s *Stream) Release() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.flush()
+	s.closed, s.released = true, true
+	s.cond.Broadcast()
+	return s.free()
+}
+
+func (s *Stream) free() error {
+	if !s.released || s.readers > 0 || s.freed {
+		return nil
+	}
+
+	s.freed, s.mem = true, nil
+	if s.file == nil {
+		return nil
+	}
+
+	f := s.file
+	s.file = nil
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+// Bytes returns the bytes kept, with a marker replacing the dropped ones
+func (s *Stream) Bytes() []byte {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.freed {
+		return nil
+	}
+
+	if !s.truncated() {
+		b := make([]byte, s.total)
+		s.read(b, 0)
+		return b
+	}
+
+	head := s.max / 2
+	tail := s.max - head
+	marker := fmt.Sprintf("\n[... %d bytes truncated ...]\n", s.total-s.max)
+
+	b := make([]byte, 0, s.max+int64(len(marker)))
+	b = b[:head]
+	s.read(b, 0)
+	b = append(b, marker...)
+	b = b[:int64(len(b))+tail]
+	s.read(b[int64(len(b))-tail:], s.total-tail)
+	return b
+}
+
+// read reads the bytes of the output from the given offset, the bytes must
+// be kept
+func (s *Stream) read(p []byte, off int64) {
+	for len(p) > 0 {
+		pos, n := s.position(off, int64(len(p)))
+		if err := s.readAt(p[:n], pos); err != nil {
+			s.err = err
+			return
+		}
+
+		p, off = p[n:], off+n
+	}
+}
+
+func (s *Stream) String() string {
+	return string(s.Bytes())
+}
+
+// TotalWritten returns the number of bytes written, including the ones not
+// kept
+func (s *Stream) TotalWritten() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.total
+}
+
+// Truncated returns if some bytes were dropped
+func (s *Stream) Truncated() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.truncated()
+}
+
+func (s *Stream) truncated() bool {
+	return s.max > 0 && s.total > s.max
+}
+
+// Follow returns a reader of the stream, from the first byte kept until the
+// stream is closed. The dropped bytes, and the ones overwritten before being
+// read, are skipped.
+func (s *Stream) Follow() *StreamReader {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.readers++
+	return &StreamReader{s: s}
+}
+
+// StreamReader reads a Stream, blocking until new bytes are written
+type StreamReader struct {
+	s      *Stream
+	pos    int64
+	closed bool
+}
+
+func (r *StreamReader) Read(p []byte) (int, error) {
+	s := r.s
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for !r.closed && !s.closed && r.pos >= s.total {
+		s.cond.Wait()
+	}
+
+	if r.closed || r.pos >= s.total || s.err != nil || s.freed {
+		r.close()
+		return 0, io.EOF
+	}
+
+	if head := s.max / 2; s.truncated() && r.pos >= head && r.pos < s.total-(s.max-head) {
+		r.pos = s.total - (s.max - head)
+	}
+
+	_, n := s.position(r.pos, min64(int64(len(p)), s.total-r.pos))
+	s.read(p[:n], r.pos)
+	r.pos += n
+	return int(n), nil
+}
+
+// Close stops the reader, unblocking a pending Read
+func (r *StreamReader) Close() error {
+	r.s.mu.Lock()
+	defer r.s.mu.Unlock()
+
+	r.close()
+	r.s.cond.Broadcast()
+	return nil
+}
+
+func (r *StreamReader) close() {
+	if r.closed {
+		return
+	}
+
+	r.closed = true
+	r.s.readers--
+	r.s.free()
+}
+
+func min64(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+
+	return b
+}
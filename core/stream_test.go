@@ -0,0 +1,117 @@
+package core
+
+import (
+	"io/ioutil"
+	"os"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+type SuiteStream struct{}
+
+var _ = Suite(&SuiteStream{})
+
+func (s *SuiteStream) TestFollow(c *C) {
+	st := NewStream(1000)
+	st.Write([]byte("foo\n"))
+
+	r := st.Follow()
+	done := make(chan string)
+	go func() {
+		b, err := ioutil.ReadAll(r)
+		c.Check(err, IsNil)
+		done <- string(b)
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	st.Write([]byte("bar\n"))
+	st.Close()
+
+	c.Assert(<-done, Equals, "foo\nbar\n")
+	c.Assert(st.String(), Equals, "foo\nbar\n")
+	c.Assert(st.TotalWritten(), Equals, int64(8))
+}
+
+func (s *SuiteStream) TestTruncated(c *C) {
+	st := NewStream(8)
+	r := st.Follow()
+
+	st.Write([]byte("foo\nbar\nbaz\nqux\n"))
+	st.Close()
+
+	c.Assert(st.Truncated(), Equals, true)
+	c.Assert(st.TotalWritten(), Equals, int64(16))
+	c.Assert(st.String(), Equals, "foo\n\n[... 8 bytes truncated ...]\nqux\n")
+
+	b, err := ioutil.ReadAll(r)
+	c.Assert(err, IsNil)
+	c.Assert(string(b), Equals, "foo\nqux\n")
+}
+
+func (s *SuiteStream) TestTruncatedWrites(c *C) {
+	st := NewStream(6)
+	for _, w := range []string{"a", "bc", "def", "ghij", "k"} {
+		st.Write([]byte(w))
+	}
+
+	c.Assert(st.String(), Equals, "abc\n[... 5 bytes truncated ...]\nijk")
+}
+
+func (s *SuiteStream) TestSpill(c *C) {
+	defer func(size int) { streamMemorySize = size }(streamMemorySize)
+	streamMemorySize = 4
+
+	dir, err := ioutil.TempDir("", "stream")
+	c.Assert(err, IsNil)
+	defer os.RemoveAll(dir)
+
+	defer func(dir string) { streamTempDir = dir }(streamTempDir)
+	streamTempDir = dir
+
+	st := NewStream(0)
+	st.Write([]byte("foo"))
+	c.Assert(st.file, IsNil)
+
+	st.Write([]byte("bar\n"))
+	c.Assert(st.file, NotNil)
+	c.Assert(st.String(), Equals, "foobar\n")
+
+	r := st.Follow()
+	st.Release()
+
+	files, _ := ioutil.ReadDir(dir)
+	c.Assert(files, HasLen, 1)
+
+	b, err := ioutil.ReadAll(r)
+	c.Assert(err, IsNil)
+	c.Assert(string(b), Equals, "foobar\n")
+
+	files, _ = ioutil.ReadDir(dir)
+	c.Assert(files, HasLen, 0)
+	c.Assert(st.Bytes(), IsNil)
+}
+
+func (s *SuiteStream) TestLazy(c *C) {
+	st := NewStream(10 * 1024 * 1024)
+	c.Assert(st.mem, IsNil)
+
+	st.Write([]byte("foo"))
+	c.Assert(cap(st.mem) < 1024, Equals, true)
+}
+
+func (s *SuiteStream) TestReaderClose(c *C) {
+	st := NewStream(1000)
+	r := st.Follow()
+
+	done := make(chan error)
+	go func() {
+		_, err := r.Read(make([]byte, 10))
+		done <- err
+	}()
+
+	time.Sleep(10 * time.Millisecond)
+	r.Close()
+
+	c.Assert(<-done, NotNil)
+}
@@ -0,0 +1,381 @@
+package core
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	units "github.com/docker/go-units"
+	docker "github.com/fsouza/go-dockerclient"
+)
+
+// Compressions supported by VolumeBackupJob
+const (
+	BackupCompressionGzip = "gzip"
+	BackupCompressionZstd = "zstd"
+)
+
+const (
+	backupSourceDir  = "/source"
+	backupTargetDir  = "/target"
+	backupTimeFormat = "20060102-150405"
+
+	// prefixes of the lines printed by the helper container, parsed to know
+	// the archive created and the existing ones
+	backupArchiveLine = "ofelia-archive "
+	backupFileLine    = "ofelia-file "
+)
+
+var (
+	ErrNoBackupVolume = errors.New("at least one volume is required")
+	ErrNoBackupTarget = errors.New("target is required")
+	ErrNoBackupReport = errors.New("couldn't find the created archive in the output of the helper container")
+)
+
+// VolumeBackupJob archives docker volumes from a helper container, mounting
+// them read-only, and enforces a retention policy on the archives.
+type VolumeBackupJob struct {
+	BareJob       `mapstructure:",squash"`
+	Client        *docker.Client `json:"-"`
+	Image         string         `default:"alpine:latest"`
+	Volume        []string
+	Target        string
+	Prefix        string
+	Compression   string   `default:"gzip"`
+	PauseSelector []string `gcfg:"pause-selector" mapstructure:"pause-selector"`
+	StopSelector  []string `gcfg:"stop-selector" mapstructure:"stop-selector"`
+	KeepLast      int      `gcfg:"keep-last" mapstructure:"keep-last"`
+	KeepDaily     int      `gcfg:"keep-daily" mapstructure:"keep-daily"`
+	KeepWeekly    int      `gcfg:"keep-weekly" mapstructure:"keep-weekly"`
+}
+
+func NewVolumeBackupJob(c *docker.Client) *VolumeBackupJob {
+	return &VolumeBackupJob{Client: c}
+}
+
+// GetCommand returns the volumes backed up, used to describe the job
+func (j *VolumeBackupJob) GetCommand() string {
+	return "backup " + strings.Join(j.Volume, ",") + " to " + j.Target
+}
+
+func (j *VolumeBackupJob) Run(ctx *Context) error {
+	if len(j.Volume) == 0 {
+		return ErrNoBackupVolume
+	}
+
+	if j.Target == "" {
+		return ErrNoBackupTarget
+	}
+
+	ext, err := j.extension()
+	if err != nil {
+		return err
+	}
+
+	archive := fmt.Sprintf("%s-%s%s", j.prefix(), time.Now().UTC().Format(backupTimeFormat), ext)
+	report, err := j.snapshot(ctx, archive)
+	if err != nil {
+		return err
+	}
+
+	archives, size, err := parseBackupReport(report, archive)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(ctx.Execution.OutputStream, "Created archive %s (%s)\n", archive, units.HumanSize(float64(size)))
+
+	expired := expiredBackups(archives, j.prefix(), ext, j.KeepLast, j.KeepDaily, j.KeepWeekly)
+	if len(expired) == 0 {
+		return nil
+	}
+
+	if _, err := j.runHelper(ctx, buildDeleteScript(expired), false); err != nil {
+		return fmt.Errorf("error deleting expired archives: %s", err)
+	}
+
+	for _, name := range expired {
+		fmt.Fprintf(ctx.Execution.OutputStream, "Deleted archive %s\n", name)
+	}
+
+	return nil
+}
+
+func (j *VolumeBackupJob) prefix() string {
+	if j.Prefix != "" {
+		return j.Prefix
+	}
+
+	return j.Name
+}
+
+func (j *VolumeBackupJob) extension() (string, error) {
+	switch j.Compression {
+	case "", BackupCompressionGzip:
+		return ".tar.gz", nil
+	case BackupCompressionZstd:
+		return ".tar.zst", nil
+	default:
+		return "", fmt.Errorf("invalid compression %q", j.Compression)
+	}
+}
+
+// snapshot creates the archive while the selected containers are paused or
+// stopped, returning the output of the helper container.
+func (j *VolumeBackupJob) snapshot(ctx *Context, archive string) (string, error) {
+	resume, err := j.suspendContainers(ctx.Execution)
+	defer resume()
+	if err != nil {
+		return "", err
+	}
+
+	return j.runHelper(ctx, j.buildArchiveScript(archive), true)
+}
+
+// suspendContainers pauses and stops the running containers matching the
+// selectors, returning a function resuming them.
+func (j *VolumeBackupJob) suspendContainers(e *Execution) (func(), error) {
+	var resumes []func()
+	resume := func() {
+		for i := len(resumes) - 1; i >= 0; i-- {
+			resumes[i]()
+		}
+	}
+
+	suspend := func(selector []string, action, revert string, do, undo func(string) error) error {
+		if len(selector) == 0 {
+			return nil
+		}
+
+		containers, err := findContainers(j.Client, selector, false)
+		if err != nil {
+			return err
+		}
+
+		for _, c := range containers {
+			if err := do(c); err != nil {
+				return fmt.Errorf("error on %s %s: %s", action, c, err)
+			}
+
+			fmt.Fprintf(e.OutputStream, "%s %s: done\n", action, c)
+
+			c := c
+			resumes = append(resumes, func() {
+				if err := undo(c); err != nil {
+					fmt.Fprintf(e.ErrorStream, "error on %s %s: %s\n", revert, c, err)
+					return
+				}
+
+				fmt.Fprintf(e.OutputStream, "%s %s: done\n", revert, c)
+			})
+		}
+
+		return nil
+	}
+
+	stop := func(c string) error { return j.Client.StopContainer(c, 10) }
+	start := func(c string) error { return j.Client.StartContainer(c, nil) }
+	if err := suspend(j.StopSelector, ContainerActionStop, ContainerActionStart, stop, start); err != nil {
+		return resume, err
+	}
+
+	err := suspend(j.PauseSelector, ContainerActionPause, ContainerActionUnpause, j.Client.PauseContainer, j.Client.UnpauseContainer)
+	return resume, err
+}
+
+// runHelper runs the script in a helper container, returning its output
+func (j *VolumeBackupJob) runHelper(ctx *Context, script string, volumes bool) (string, error) {
+	helper := j.buildHelper(script, volumes)
+
+	// the output of the helper may be spilled to a temporary file
+	e := NewExecution()
+	defer e.Release()
+
+	err := helper.Run(&Context{
+		Scheduler: ctx.Scheduler,
+		Logger:    ctx.Logger,
+		Job:       ctx.Job,
+		Execution: e,
+	})
+
+	if err != nil {
+		ctx.Execution.ErrorStream.Write(e.OutputStream.Bytes())
+		ctx.Execution.ErrorStream.Write(e.ErrorStream.Bytes())
+		return "", err
+	}
+
+	return e.OutputStream.String(), nil
+}
+
+// buildHelper returns the job running the script with the target mounted, and
+// the volumes if requested.
+func (j *VolumeBackupJob) buildHelper(script string, volumes bool) *RunJob {
+	binds := []string{j.Target + ":" + backupTargetDir}
+	if volumes {
+		for _, v := range j.Volume {
+			binds = append(binds, fmt.Sprintf("%s:%s/%s:ro", v, backupSourceDir, v))
+		}
+	}
+
+	helper := &RunJob{
+		Client: j.Client,
+		User:   "root",
+		TTY:    true,
+		Delete: "true",
+		Pull:   "false",
+		Image:  j.Image,
+		Volume: binds,
+		Script: script,
+
+		deleteFailed: true,
+	}
+
+	helper.Name = j.Name
+	return helper
+}
+
+func (j *VolumeBackupJob) buildArchiveScript(archive string) string {
+	tmp := shellQuote(archive + ".part")
+
+	create := fmt.Sprintf("tar -czf %s -C %s .", tmp, backupSourceDir)
+	if j.Compression == BackupCompressionZstd {
+		// the shell of the image may lack pipefail, so a failure of tar is
+		// recorded in a file, set -e only sees the exit code of zstd
+		failed := shellQuote(archive + ".failed")
+		create = strings.Join([]string{
+			fmt.Sprintf("{ tar -cf - -C %s . || : > %s; } | zstd -q -o %s", backupSourceDir, failed, tmp),
+			fmt.Sprintf("if [ -e %s ]; then rm -f %s %s; echo error creating the archive >&2; exit 1; fi", failed, failed, tmp),
+		}, "\n")
+	}
+
+	ext, _ := j.extension()
+	return strings.Join([]string{
+		"set -e",
+		"cd " + backupTargetDir,
+		create,
+		fmt.Sprintf("mv %s %s", tmp, shellQuote(archive)),
+		fmt.Sprintf("echo %s%s $(wc -c < %s)", backupArchiveLine, shellQuote(archive), shellQuote(archive)),
+		fmt.Sprintf("for f in %s-*%s; do", shellQuote(j.prefix()), shellQuote(ext)),
+		fmt.Sprintf(`  if [ -e "$f" ]; then echo %s"$f"; fi`, backupFileLine),
+		"done",
+	}, "\n")
+}
+
+func buildDeleteScript(names []string) string {
+	quoted := make([]string, len(names))
+	for i, name := range names {
+		quoted[i] = shellQuote(name)
+	}
+
+	return strings.Join([]string{
+		"set -e",
+		"cd " + backupTargetDir,
+		"rm -f -- " + strings.Join(quoted, " "),
+	}, "\n")
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// parseBackupReport returns the archives found in the target and the size of
+// the created archive, from the output of the helper container.
+func parseBackupReport(report, archive string) ([]string, int64, error) {
+	var size int64 = -1
+	seen := make(map[string]bool)
+	var archives []string
+
+	scanner := bufio.NewScanner(strings.NewReader(report))
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		switch {
+		case strings.HasPrefix(line, backupArchiveLine+archive+" "):
+			s := strings.TrimSpace(strings.TrimPrefix(line, backupArchiveLine+archive+" "))
+			size, _ = strconv.ParseInt(s, 10, 64)
+		case strings.HasPrefix(line, backupFileLine):
+			name := strings.TrimPrefix(line, backupFileLine)
+			if !seen[name] {
+				seen[name] = true
+				archives = append(archives, name)
+			}
+		}
+	}
+
+	if size < 0 {
+		return nil, 0, ErrNoBackupReport
+	}
+
+	return archives, size, nil
+}
+
+// expiredBackups returns the archives not kept by any of the retention rules:
+// the last ones, and the last one of each day and week. All of them are kept
+// when no rule is set, and the names not matching the pattern are ignored.
+func expiredBackups(names []string, prefix, ext string, last, daily, weekly int) []string {
+	if last <= 0 && daily <= 0 && weekly <= 0 {
+		return nil
+	}
+
+	type backup struct {
+		name string
+		date time.Time
+	}
+
+	var backups []backup
+	for _, name := range names {
+		if !strings.HasPrefix(name, prefix+"-") || !strings.HasSuffix(name, ext) {
+			continue
+		}
+
+		ts := strings.TrimSuffix(strings.TrimPrefix(name, prefix+"-"), ext)
+		date, err := time.Parse(backupTimeFormat, ts)
+		if err != nil {
+			continue
+		}
+
+		backups = append(backups, backup{name, date})
+	}
+
+	sort.Slice(backups, func(i, k int) bool {
+		return backups[i].date.After(backups[k].date)
+	})
+
+	keep := make(map[string]bool)
+	keepPeriods := func(count int, period func(time.Time) string) {
+		seen := make(map[string]bool)
+		for _, b := range backups {
+			if len(seen) >= count {
+				return
+			}
+
+			p := period(b.date)
+			if !seen[p] {
+				seen[p] = true
+				keep[b.name] = true
+			}
+		}
+	}
+
+	for i := 0; i < last && i < len(backups); i++ {
+		keep[backups[i].name] = true
+	}
+
+	keepPeriods(daily, func(t time.Time) string { return t.Format("2006-01-02") })
+	keepPeriods(weekly, func(t time.Time) string {
+		year, week := t.ISOWeek()
+		return fmt.Sprintf("%d-%d", year, week)
+	})
+
+	var expired []string
+	for _, b := range backups {
+		if !keep[b.name] {
+			expired = append(expired, b.name)
+		}
+	}
+
+	return expired
+}
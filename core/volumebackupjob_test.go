@@ -0,0 +1,266 @@
+package core
+
+import (
+	"archive/tar"
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	docker "github.com/fsouza/go-dockerclient"
+	"github.com/fsouza/go-dockerclient/testing"
+	logging "github.com/op/go-logging"
+	. "gopkg.in/check.v1"
+)
+
+type SuiteVolumeBackupJob struct {
+	server *testing.DockerServer
+	client *docker.Client
+}
+
+var _ = Suite(&SuiteVolumeBackupJob{})
+
+func (s *SuiteVolumeBackupJob) SetUpTest(c *C) {
+	var err error
+	s.server, err = testing.NewServer("127.0.0.1:0", nil, nil)
+	c.Assert(err, IsNil)
+
+	s.client, err = docker.NewClient(s.server.URL())
+	c.Assert(err, IsNil)
+
+	s.buildImage(c)
+}
+
+func (s *SuiteVolumeBackupJob) TestRun(c *C) {
+	db, err := s.client.CreateContainer(docker.CreateContainerOptions{
+		Name: "db",
+		Config: &docker.Config{
+			Image:  ImageFixture,
+			Labels: map[string]string{"backup": "pause"},
+		},
+	})
+	c.Assert(err, IsNil)
+	c.Assert(s.client.StartContainer(db.ID, nil), IsNil)
+
+	job := &VolumeBackupJob{Client: s.client}
+	job.Name = "backup"
+	job.Image = ImageFixture
+	job.Volume = []string{"data", "config"}
+	job.Target = "/backups"
+	job.PauseSelector = []string{"backup=pause"}
+
+	ctx := &Context{Job: job, Execution: NewExecution()}
+	ctx.Logger = logging.MustGetLogger("ofelia")
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+
+	go func() {
+		defer wg.Done()
+		time.Sleep(time.Millisecond * 200)
+
+		db, err := s.client.InspectContainer("db")
+		c.Assert(err, IsNil)
+		c.Assert(db.State.Paused, Equals, true)
+
+		containers, err := s.client.ListContainers(docker.ListContainersOptions{})
+		c.Assert(err, IsNil)
+
+		for _, container := range containers {
+			if container.ID != db.ID {
+				c.Assert(s.client.StopContainer(container.ID, 0), IsNil)
+			}
+		}
+	}()
+
+	// the fake server doesn't run the script, so there is no report
+	err = job.Run(ctx)
+	c.Assert(err, Equals, ErrNoBackupReport)
+	wg.Wait()
+
+	db, err = s.client.InspectContainer("db")
+	c.Assert(err, IsNil)
+	c.Assert(db.State.Paused, Equals, false)
+	c.Assert(ctx.Execution.OutputStream.String(), Equals, "pause db: done\nunpause db: done\n")
+}
+
+func (s *SuiteVolumeBackupJob) TestRunHelperFailed(c *C) {
+	job := &VolumeBackupJob{Client: s.client}
+	job.Name = "backup"
+	job.Image = ImageFixture
+	job.Volume = []string{"data"}
+	job.Target = "/backups"
+
+	ctx := &Context{Job: job, Execution: NewExecution()}
+	ctx.Logger = logging.MustGetLogger("ofelia")
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+
+	go func() {
+		defer wg.Done()
+		time.Sleep(time.Millisecond * 200)
+
+		containers, err := s.client.ListContainers(docker.ListContainersOptions{})
+		c.Check(err, IsNil)
+		c.Check(containers, HasLen, 1)
+
+		for _, container := range containers {
+			c.Check(s.server.MutateContainer(container.ID, docker.State{ExitCode: 1}), IsNil)
+		}
+	}()
+
+	err := job.Run(ctx)
+	c.Assert(err, ErrorMatches, "error non-zero exit code: 1")
+	wg.Wait()
+
+	containers, err := s.client.ListContainers(docker.ListContainersOptions{All: true})
+	c.Assert(err, IsNil)
+	c.Assert(containers, HasLen, 0)
+}
+
+func (s *SuiteVolumeBackupJob) TestRunInvalid(c *C) {
+	job := &VolumeBackupJob{Client: s.client}
+	c.Assert(job.Run(&Context{Execution: NewExecution()}), Equals, ErrNoBackupVolume)
+
+	job.Volume = []string{"data"}
+	c.Assert(job.Run(&Context{Execution: NewExecution()}), Equals, ErrNoBackupTarget)
+
+	job.Target = "/backups"
+	job.Compression = "foo"
+	err := job.Run(&Context{Execution: NewExecution()})
+	c.Assert(err, ErrorMatches, `invalid compression "foo"`)
+}
+
+func (s *SuiteVolumeBackupJob) TestBuildHelper(c *C) {
+	job := &VolumeBackupJob{Client: s.client}
+	job.Image = ImageFixture
+	job.Volume = []string{"data", "config"}
+	job.Target = "/backups"
+
+	helper := job.buildHelper("echo foo", true)
+	c.Assert(helper.Image, Equals, ImageFixture)
+	c.Assert(helper.Script, Equals, "echo foo")
+	c.Assert(helper.Volume, DeepEquals, []string{
+		"/backups:/target", "data:/source/data:ro", "config:/source/config:ro",
+	})
+
+	helper = job.buildHelper("echo foo", false)
+	c.Assert(helper.Volume, DeepEquals, []string{"/backups:/target"})
+}
+
+func (s *SuiteVolumeBackupJob) TestBuildArchiveScript(c *C) {
+	job := &VolumeBackupJob{Prefix: "data", Compression: BackupCompressionZstd}
+
+	c.Assert(job.buildArchiveScript("data-20210102-030405.tar.zst"), Equals, `set -e
+cd /target
+{ tar -cf - -C /source . || : > 'data-20210102-030405.tar.zst.failed'; } | zstd -q -o 'data-20210102-030405.tar.zst.part'
+if [ -e 'data-20210102-030405.tar.zst.failed' ]; then rm -f 'data-20210102-030405.tar.zst.failed' 'data-20210102-030405.tar.zst.part'; echo error creating the archive >&2; exit 1; fi
+mv 'data-20210102-030405.tar.zst.part' 'data-20210102-030405.tar.zst'
+echo ofelia-archive 'data-20210102-030405.tar.zst' $(wc -c < 'data-20210102-030405.tar.zst')
+for f in 'data'-*'.tar.zst'; do
+  if [ -e "$f" ]; then echo ofelia-file "$f"; fi
+done`)
+}
+
+func (s *SuiteVolumeBackupJob) TestArchiveScriptTarFailed(c *C) {
+	dir, err := ioutil.TempDir("", "backup")
+	c.Assert(err, IsNil)
+	defer os.RemoveAll(dir)
+
+	bin := filepath.Join(dir, "bin")
+	target := filepath.Join(dir, "target")
+	c.Assert(os.MkdirAll(bin, 0755), IsNil)
+	c.Assert(os.MkdirAll(target, 0755), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(bin, "tar"), []byte("#!/bin/sh\necho foo\nexit 2\n"), 0755), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(bin, "zstd"), []byte("#!/bin/sh\ncat > \"$3\"\n"), 0755), IsNil)
+	c.Assert(ioutil.WriteFile(filepath.Join(target, "data-20210101-030405.tar.zst"), nil, 0644), IsNil)
+
+	job := &VolumeBackupJob{Prefix: "data", Compression: BackupCompressionZstd}
+	script := job.buildArchiveScript("data-20210102-030405.tar.zst")
+	script = strings.Replace(script, "cd "+backupTargetDir, "cd "+target, 1)
+
+	cmd := exec.Command("/bin/sh", "-c", script)
+	cmd.Env = []string{"PATH=" + bin + ":/usr/bin:/bin"}
+	output, err := cmd.Output()
+	c.Assert(err, NotNil)
+
+	// without the report of the archives, no expired archive is deleted
+	_, _, err = parseBackupReport(string(output), "data-20210102-030405.tar.zst")
+	c.Assert(err, NotNil)
+
+	files, err := ioutil.ReadDir(target)
+	c.Assert(err, IsNil)
+	c.Assert(files, HasLen, 1)
+	c.Assert(files[0].Name(), Equals, "data-20210101-030405.tar.zst")
+}
+
+func (s *SuiteVolumeBackupJob) TestParseBackupReport(c *C) {
+	report := "ofelia-archive data-2.tar.gz 1024\r\n" +
+		"ofelia-file data-1.tar.gz\r\n" +
+		"ofelia-file data-2.tar.gz\r\n" +
+		"ofelia-file data-1.tar.gz\r\n"
+
+	archives, size, err := parseBackupReport(report, "data-2.tar.gz")
+	c.Assert(err, IsNil)
+	c.Assert(size, Equals, int64(1024))
+	c.Assert(archives, DeepEquals, []string{"data-1.tar.gz", "data-2.tar.gz"})
+
+	_, _, err = parseBackupReport("ofelia-file data-1.tar.gz\n", "data-2.tar.gz")
+	c.Assert(err, Equals, ErrNoBackupReport)
+}
+
+func (s *SuiteVolumeBackupJob) TestExpiredBackups(c *C) {
+	names := []string{
+		"data-20210110-000000.tar.gz",
+		"data-20210109-120000.tar.gz",
+		"data-20210109-000000.tar.gz",
+		"data-20210108-000000.tar.gz",
+		"data-20210104-000000.tar.gz",
+		"data-20210101-000000.tar.gz",
+		"data-foo.tar.gz",
+		"other-20210101-000000.tar.gz",
+	}
+
+	c.Assert(expiredBackups(names, "data", ".tar.gz", 0, 0, 0), HasLen, 0)
+	c.Assert(expiredBackups(names, "data", ".tar.gz", 2, 0, 0), DeepEquals, []string{
+		"data-20210109-000000.tar.gz",
+		"data-20210108-000000.tar.gz",
+		"data-20210104-000000.tar.gz",
+		"data-20210101-000000.tar.gz",
+	})
+
+	c.Assert(expiredBackups(names, "data", ".tar.gz", 1, 2, 0), DeepEquals, []string{
+		"data-20210109-000000.tar.gz",
+		"data-20210108-000000.tar.gz",
+		"data-20210104-000000.tar.gz",
+		"data-20210101-000000.tar.gz",
+	})
+
+	// 2021-01-04 to 2021-01-10 is the first week of 2021
+	c.Assert(expiredBackups(names, "data", ".tar.gz", 0, 1, 3), DeepEquals, []string{
+		"data-20210109-120000.tar.gz",
+		"data-20210109-000000.tar.gz",
+		"data-20210108-000000.tar.gz",
+		"data-20210104-000000.tar.gz",
+	})
+}
+
+func (s *SuiteVolumeBackupJob) buildImage(c *C) {
+	inputbuf := bytes.NewBuffer(nil)
+	tr := tar.NewWriter(inputbuf)
+	tr.WriteHeader(&tar.Header{Name: "Dockerfile"})
+	tr.Write([]byte("FROM base\n"))
+	tr.Close()
+
+	err := s.client.BuildImage(docker.BuildImageOptions{
+		Name:         ImageFixture,
+		InputStream:  inputbuf,
+		OutputStream: bytes.NewBuffer(nil),
+	})
+	c.Assert(err, IsNil)
+}
@@ -3,11 +3,11 @@ module github.com/vigasin/ofelia
 go 1.11
 
 require (
-	github.com/armon/circbuf v0.0.0-20190214190532-5111143e8da2
 	github.com/bradfitz/go-smtpd v0.0.0-20170404230938-deb6d6237625
 	github.com/containerd/containerd v1.5.0-beta.4 // indirect
 	github.com/containerd/continuity v0.0.0-20210315143101-93e15499afd5 // indirect
 	github.com/docker/docker v20.10.5+incompatible
+	github.com/docker/go-units v0.4.0
 	github.com/fsouza/go-dockerclient v1.7.2
 	github.com/gobs/args v0.0.0-20210311043657-b8c0b223be93
 	github.com/golang/protobuf v1.5.1 // indirect
@@ -17,6 +17,7 @@ require (
 	github.com/op/go-logging v0.0.0-20160315200505-970db520ece7
 	github.com/robfig/cron v1.2.0
 	github.com/sirupsen/logrus v1.8.1 // indirect
+	go.etcd.io/bbolt v1.3.5
 	go.opencensus.io v0.23.0 // indirect
 	golang.org/x/sync v0.0.0-20210220032951-036812b2e83c // indirect
 	golang.org/x/sys v0.0.0-20210317225723-c4fcb01b228e // indirect
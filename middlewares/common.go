@@ -1,6 +1,11 @@
 package middlewares
 
-import "reflect"
+import (
+	"encoding/json"
+	"reflect"
+
+	"github.com/vigasin/ofelia/core"
+)
 
 func IsEmpty(i interface{}) bool {
 	t := reflect.TypeOf(i).Elem()
@@ -8,3 +13,20 @@ func IsEmpty(i interface{}) bool {
 
 	return reflect.DeepEqual(i, e)
 }
+
+// isTrue returns if an optional flag is set to true
+func isTrue(b *bool) bool {
+	return b != nil && *b
+}
+
+// marshalRecord encodes the record of an execution saved or attached by the
+// middlewares, the credentials are never encoded and the other secrets, e.g.
+// of the environment, are redacted
+func marshalRecord(ctx *core.Context, record map[string]interface{}) []byte {
+	js, _ := json.MarshalIndent(record, "", "  ")
+	if ctx.Scheduler != nil {
+		js = ctx.Scheduler.Redactor.Redact(js)
+	}
+
+	return js
+}
@@ -1,6 +1,7 @@
 package middlewares
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/vigasin/ofelia/core"
@@ -27,6 +28,16 @@ func (s *SuiteCommon) TestIsEmpty(c *C) {
 	c.Assert(IsEmpty(config), Equals, false)
 }
 
+func (s *SuiteCommon) TestIsTrue(c *C) {
+	c.Assert(isTrue(nil), Equals, false)
+	c.Assert(isTrue(newBool(false)), Equals, false)
+	c.Assert(isTrue(newBool(true)), Equals, true)
+}
+
+func newBool(b bool) *bool {
+	return &b
+}
+
 type BaseSuite struct {
 	ctx *core.Context
 	job *TestJob
@@ -54,6 +65,47 @@ func (j *TestJob) Run(ctx *core.Context) error {
 	return nil
 }
 
+// SecretTestJob is a job configured with the secrets of the middlewares, and
+// with a secret to redact in its environment
+type SecretTestJob struct {
+	TestJob
+	MailConfig
+	SlackConfig
+	WebhookConfig
+	PingConfig
+	Environment []string
+}
+
+var testSecrets = []string{
+	"smtp-secret", "slack-secret", "webhook-secret", "header-secret", "ping-secret", "env-secret",
+}
+
+// setSecretTestJob replaces the job of the context by a SecretTestJob
+func (s *BaseSuite) setSecretTestJob(c *C) {
+	j := &SecretTestJob{Environment: []string{"TOKEN=env-secret"}}
+	j.Name = "foo"
+	j.SMTPPassword = "smtp-secret"
+	j.SlackToken = "slack-secret"
+	j.SlackWebhook = "https://hooks.slack.com/slack-secret"
+	j.WebhookURL = "https://example.com/webhook-secret"
+	j.WebhookHeaders = []string{"Authorization: header-secret"}
+	j.PingURL = "https://hc-ping.com/ping-secret"
+
+	r, err := core.NewRedactor(nil, []string{"env-secret"})
+	c.Assert(err, IsNil)
+
+	s.ctx.Job = j
+	s.ctx.Scheduler.Redactor = r
+}
+
+// assertNoSecret checks the data contains the job but none of its secrets
+func assertNoSecret(c *C, data []byte) {
+	c.Assert(strings.Contains(string(data), `"Name": "foo"`), Equals, true)
+	for _, secret := range testSecrets {
+		c.Check(strings.Contains(string(data), secret), Equals, false, Commentf("secret %q", secret))
+	}
+}
+
 type TestLogger struct{}
 
 func (*TestLogger) Criticalf(format string, args ...interface{}) {}
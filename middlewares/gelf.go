@@ -0,0 +1,201 @@
+package middlewares
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	"github.com/vigasin/ofelia/core"
+)
+
+var (
+	gelfTimeout    = 10 * time.Second
+	gelfOutputSize = 30000
+	// gelfChunkSize is the size of the UDP datagrams, the larger messages are
+	// split in chunks
+	gelfChunkSize = 1420
+)
+
+const gelfMaxChunks = 128
+
+// GELFConfig configuration for the GELF middleware
+type GELFConfig struct {
+	GELFAddress  string `gcfg:"gelf-address" mapstructure:"gelf-address"`
+	GELFFacility string `gcfg:"gelf-facility" mapstructure:"gelf-facility"`
+	GELFTag      string `gcfg:"gelf-tag" mapstructure:"gelf-tag"`
+}
+
+// Inherit fills the empty fields of a job configuration with the global one,
+// so a job only needs to set e.g. its own tag
+func (c *GELFConfig) Inherit(g *GELFConfig) {
+	if IsEmpty(c) {
+		return
+	}
+
+	inherit := func(v *string, global string) {
+		if *v == "" {
+			*v = global
+		}
+	}
+
+	inherit(&c.GELFAddress, g.GELFAddress)
+	inherit(&c.GELFFacility, g.GELFFacility)
+	inherit(&c.GELFTag, g.GELFTag)
+}
+
+// Validate returns an error if the address is missing or invalid
+func (c *GELFConfig) Validate() error {
+	if c.GELFAddress == "" {
+		return errors.New("gelf-address is required")
+	}
+
+	if network, _, err := parseAddress(c.GELFAddress, "udp"); err != nil || network == "unix" {
+		return fmt.Errorf("invalid gelf-address %q", c.GELFAddress)
+	}
+
+	return nil
+}
+
+// NewGELF returns a GELF middleware if the given configuration is not empty
+func NewGELF(c *GELFConfig) core.Middleware {
+	var m core.Middleware
+	if !IsEmpty(c) {
+		m = &GELF{*c}
+	}
+
+	return m
+}
+
+// GELF middleware sends a GELF message per execution to Graylog, over UDP,
+// chunked if needed, or TCP
+type GELF struct {
+	GELFConfig
+}
+
+// ContinueOnStop return allways true, we want always report the final status
+func (m *GELF) ContinueOnStop() bool {
+	return true
+}
+
+// Run sends the message after the execution
+func (m *GELF) Run(ctx *core.Context) error {
+	err := ctx.Next()
+	ctx.Stop(err)
+
+	if err := m.send(ctx); err != nil {
+		ctx.Logger.Errorf("GELF error: %s", err)
+	}
+
+	return err
+}
+
+func (m *GELF) send(ctx *core.Context) error {
+	network, addr, err := parseAddress(m.GELFAddress, "udp")
+	if err != nil || network == "unix" {
+		return fmt.Errorf("invalid gelf-address %q", m.GELFAddress)
+	}
+
+	msg, err := json.Marshal(m.message(ctx, time.Now()))
+	if err != nil {
+		return err
+	}
+
+	conn, err := net.DialTimeout(network, addr, gelfTimeout)
+	if err != nil {
+		return err
+	}
+
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(gelfTimeout))
+
+	if network == "tcp" {
+		// the messages are delimited by a null byte over TCP
+		_, err = conn.Write(append(msg, 0))
+		return err
+	}
+
+	return gelfWriteChunks(conn, msg)
+}
+
+// message returns the GELF 1.1 message of the execution, the execution is
+// sent in additional fields
+func (m *GELF) message(ctx *core.Context, now time.Time) map[string]interface{} {
+	e := ctx.Execution
+	hostname, _ := os.Hostname()
+
+	msg := map[string]interface{}{
+		"version":       "1.1",
+		"host":          hostname,
+		"short_message": executionSummary(ctx),
+		"timestamp":     float64(now.UnixNano()/int64(time.Millisecond)) / 1000,
+		"level":         severity(e),
+		"_job":          ctx.Job.GetName(),
+		"_execution":    e.ID,
+		"_status":       e.Status(),
+		"_duration":     e.Duration.Seconds(),
+		"_exit_code":    e.ExitCode,
+	}
+
+	if e.Error != nil {
+		msg["_error"] = e.Error.Error()
+	}
+
+	if output := e.OutputStream.Bytes(); len(output) > 0 {
+		msg["full_message"] = tail(output, gelfOutputSize)
+	}
+
+	if stderr := e.ErrorStream.Bytes(); len(stderr) > 0 {
+		msg["_stderr"] = tail(stderr, gelfOutputSize)
+	}
+
+	if m.GELFFacility != "" {
+		msg["_facility"] = m.GELFFacility
+	}
+
+	if m.GELFTag != "" {
+		msg["_tag"] = m.GELFTag
+	}
+
+	return msg
+}
+
+// gelfWriteChunks writes the message in a datagram, or in chunks sharing a
+// random ID if it's larger than a datagram
+func gelfWriteChunks(conn net.Conn, msg []byte) error {
+	if len(msg) <= gelfChunkSize {
+		_, err := conn.Write(msg)
+		return err
+	}
+
+	// every chunk has a 12 bytes header: magic bytes, ID, sequence and count
+	size := gelfChunkSize - 12
+	count := (len(msg) + size - 1) / size
+	if count > gelfMaxChunks {
+		return fmt.Errorf("message too large, %d bytes", len(msg))
+	}
+
+	id := make([]byte, 8)
+	if _, err := rand.Read(id); err != nil {
+		return err
+	}
+
+	for i := 0; i < count; i++ {
+		end := (i + 1) * size
+		if end > len(msg) {
+			end = len(msg)
+		}
+
+		chunk := append([]byte{0x1e, 0x0f}, id...)
+		chunk = append(chunk, byte(i), byte(count))
+		chunk = append(chunk, msg[i*size:end]...)
+		if _, err := conn.Write(chunk); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,152 @@
+package middlewares
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"net"
+	"strings"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+type SuiteGELF struct {
+	BaseSuite
+}
+
+var _ = Suite(&SuiteGELF{})
+
+func (s *SuiteGELF) SetUpTest(c *C) {
+	s.BaseSuite.SetUpTest(c)
+	s.job.Name = "foo"
+}
+
+func (s *SuiteGELF) TestNewGELFEmpty(c *C) {
+	c.Assert(NewGELF(&GELFConfig{}), IsNil)
+}
+
+func (s *SuiteGELF) TestRunUDP(c *C) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	c.Assert(err, IsNil)
+	defer conn.Close()
+
+	s.ctx.Start()
+	s.ctx.Execution.OutputStream.Write([]byte("bar\n"))
+	s.ctx.Execution.ErrorStream.Write([]byte("baz\n"))
+	s.ctx.Stop(errors.New("qux"))
+	s.ctx.Execution.ExitCode = 2
+
+	m := NewGELF(&GELFConfig{
+		GELFAddress:  "udp://" + conn.LocalAddr().String(),
+		GELFFacility: "cron",
+		GELFTag:      "backups",
+	})
+
+	c.Assert(m.Run(s.ctx), IsNil)
+
+	var msg map[string]interface{}
+	c.Assert(json.Unmarshal([]byte(readPacket(c, conn)), &msg), IsNil)
+	c.Assert(msg["version"], Equals, "1.1")
+	c.Assert(msg["short_message"], Matches, `Job "foo" failed in \S+: qux`)
+	c.Assert(msg["full_message"], Equals, "bar\n")
+	c.Assert(msg["level"], Equals, float64(3))
+	c.Assert(msg["_job"], Equals, "foo")
+	c.Assert(msg["_execution"], Equals, s.ctx.Execution.ID)
+	c.Assert(msg["_status"], Equals, "failed")
+	c.Assert(msg["_exit_code"], Equals, float64(2))
+	c.Assert(msg["_error"], Equals, "qux")
+	c.Assert(msg["_stderr"], Equals, "baz\n")
+	c.Assert(msg["_facility"], Equals, "cron")
+	c.Assert(msg["_tag"], Equals, "backups")
+}
+
+func (s *SuiteGELF) TestRunUDPChunked(c *C) {
+	defer func(size int) { gelfChunkSize = size }(gelfChunkSize)
+	gelfChunkSize = 100
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	c.Assert(err, IsNil)
+	defer conn.Close()
+
+	s.ctx.Start()
+	s.ctx.Execution.OutputStream.Write([]byte(strings.Repeat("bar", 100)))
+	s.ctx.Stop(nil)
+
+	m := NewGELF(&GELFConfig{GELFAddress: conn.LocalAddr().String()})
+	c.Assert(m.Run(s.ctx), IsNil)
+
+	var chunks []string
+	var id string
+	for {
+		chunk := readPacket(c, conn)
+		c.Assert(len(chunk) <= gelfChunkSize, Equals, true)
+		c.Assert(chunk[:2], Equals, "\x1e\x0f")
+		c.Assert(int(chunk[10]), Equals, len(chunks))
+		if id == "" {
+			id = chunk[2:10]
+		}
+
+		c.Assert(chunk[2:10], Equals, id)
+		chunks = append(chunks, chunk[12:])
+		if len(chunks) == int(chunk[11]) {
+			break
+		}
+	}
+
+	var msg map[string]interface{}
+	c.Assert(json.Unmarshal([]byte(strings.Join(chunks, "")), &msg), IsNil)
+	c.Assert(msg["full_message"], Equals, strings.Repeat("bar", 100))
+	c.Assert(msg["_status"], Equals, "successful")
+}
+
+func (s *SuiteGELF) TestRunTCP(c *C) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, IsNil)
+	defer l.Close()
+
+	s.ctx.Start()
+	s.ctx.Stop(nil)
+
+	m := NewGELF(&GELFConfig{GELFAddress: "tcp://" + l.Addr().String()})
+	c.Assert(m.Run(s.ctx), IsNil)
+
+	conn, err := l.Accept()
+	c.Assert(err, IsNil)
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+
+	b, err := bufio.NewReader(conn).ReadBytes(0)
+	c.Assert(err, IsNil)
+
+	var msg map[string]interface{}
+	c.Assert(json.Unmarshal(b[:len(b)-1], &msg), IsNil)
+	c.Assert(msg["_job"], Equals, "foo")
+	c.Assert(msg["level"], Equals, float64(5))
+}
+
+func (s *SuiteGELF) TestInvalidAddress(c *C) {
+	s.ctx.Start()
+	s.ctx.Stop(nil)
+
+	m := &GELF{GELFConfig{GELFAddress: "unix:///dev/log"}}
+	c.Assert(m.send(s.ctx), ErrorMatches, `invalid gelf-address "unix:///dev/log"`)
+}
+
+func (s *SuiteGELF) TestValidate(c *C) {
+	c.Assert((&GELFConfig{GELFTag: "foo"}).Validate(), ErrorMatches, "gelf-address is required")
+	c.Assert((&GELFConfig{GELFAddress: "unix:///dev/log"}).Validate(), ErrorMatches, `invalid gelf-address "unix:///dev/log"`)
+	c.Assert((&GELFConfig{GELFAddress: "tcp://graylog:12201"}).Validate(), IsNil)
+}
+
+func (s *SuiteGELF) TestInherit(c *C) {
+	global := &GELFConfig{GELFAddress: "graylog:12201", GELFFacility: "ofelia"}
+
+	job := &GELFConfig{}
+	job.Inherit(global)
+	c.Assert(IsEmpty(job), Equals, true)
+
+	job = &GELFConfig{GELFTag: "foo"}
+	job.Inherit(global)
+	c.Assert(job, DeepEquals, &GELFConfig{GELFAddress: "graylog:12201", GELFFacility: "ofelia", GELFTag: "foo"})
+}
@@ -2,27 +2,101 @@ package middlewares
 
 import (
 	"bytes"
-	"encoding/json"
+	"crypto/tls"
 	"fmt"
 	"html/template"
 	"io"
+	"io/ioutil"
+	"net"
+	"net/smtp"
 	"os"
+	"strconv"
 	"strings"
+	texttemplate "text/template"
+	"time"
 
 	"gopkg.in/gomail.v2"
 
 	"github.com/vigasin/ofelia/core"
 )
 
+// SMTP TLS modes supported by the Mail middleware
+const (
+	SMTPTLSStartTLS = "starttls"
+	SMTPTLSImplicit = "tls"
+	SMTPTLSNone     = "none"
+)
+
+// Mail body formats
+const (
+	MailFormatHTML = "html"
+	MailFormatText = "text"
+)
+
+var (
+	mailDialTimeout = 10 * time.Second
+	mailOutputSize  = 10000
+)
+
 // MailConfig configuration for the Mail middleware
 type MailConfig struct {
-	SMTPHost        string `gcfg:"smtp-host" mapstructure:"smtp-host"`
-	SMTPPort        int    `gcfg:"smtp-port" mapstructure:"smtp-port"`
-	SMTPUser        string `gcfg:"smtp-user" mapstructure:"smtp-user"`
-	SMTPPassword    string `gcfg:"smtp-password" mapstructure:"smtp-password"`
-	EmailTo         string `gcfg:"email-to" mapstructure:"email-to"`
-	EmailFrom       string `gcfg:"email-from" mapstructure:"email-from"`
-	MailOnlyOnError bool   `gcfg:"mail-only-on-error" mapstructure:"mail-only-on-error"`
+	SMTPHost            string `gcfg:"smtp-host" mapstructure:"smtp-host"`
+	SMTPPort            int    `gcfg:"smtp-port" mapstructure:"smtp-port"`
+	SMTPUser            string `gcfg:"smtp-user" mapstructure:"smtp-user"`
+	SMTPPassword        string `gcfg:"smtp-password" mapstructure:"smtp-password" json:"-"`
+	SMTPTLS             string `gcfg:"smtp-tls" mapstructure:"smtp-tls"`
+	SMTPTLSSkipVerify   *bool  `gcfg:"smtp-tls-skip-verify" mapstructure:"smtp-tls-skip-verify"`
+	EmailTo             string `gcfg:"email-to" mapstructure:"email-to"`
+	EmailCC             string `gcfg:"email-cc" mapstructure:"email-cc"`
+	EmailBCC            string `gcfg:"email-bcc" mapstructure:"email-bcc"`
+	EmailFrom           string `gcfg:"email-from" mapstructure:"email-from"`
+	MailOnlyOnError     *bool  `gcfg:"mail-only-on-error" mapstructure:"mail-only-on-error"`
+	MailFormat          string `gcfg:"mail-format" mapstructure:"mail-format"`
+	MailSubjectTemplate string `gcfg:"mail-subject-template" mapstructure:"mail-subject-template"`
+	MailBodyTemplate    string `gcfg:"mail-body-template" mapstructure:"mail-body-template"`
+	MailInlineOutput    *bool  `gcfg:"mail-inline-output" mapstructure:"mail-inline-output"`
+}
+
+// Inherit fills the empty fields of a job configuration with the global one,
+// so a job only needs to set e.g. its own recipients. An empty configuration
+// is left untouched, the global middleware is used instead.
+func (c *MailConfig) Inherit(g *MailConfig) {
+	if IsEmpty(c) {
+		return
+	}
+
+	inherit := func(v *string, global string) {
+		if *v == "" {
+			*v = global
+		}
+	}
+
+	inherit(&c.SMTPHost, g.SMTPHost)
+	inherit(&c.SMTPUser, g.SMTPUser)
+	inherit(&c.SMTPPassword, g.SMTPPassword)
+	inherit(&c.SMTPTLS, g.SMTPTLS)
+	inherit(&c.EmailTo, g.EmailTo)
+	inherit(&c.EmailCC, g.EmailCC)
+	inherit(&c.EmailBCC, g.EmailBCC)
+	inherit(&c.EmailFrom, g.EmailFrom)
+	inherit(&c.MailFormat, g.MailFormat)
+	inherit(&c.MailSubjectTemplate, g.MailSubjectTemplate)
+	inherit(&c.MailBodyTemplate, g.MailBodyTemplate)
+
+	if c.SMTPPort == 0 {
+		c.SMTPPort = g.SMTPPort
+	}
+
+	// the flags set in the job, even to false, override the global ones
+	inheritBool := func(v **bool, global *bool) {
+		if *v == nil {
+			*v = global
+		}
+	}
+
+	inheritBool(&c.SMTPTLSSkipVerify, g.SMTPTLSSkipVerify)
+	inheritBool(&c.MailOnlyOnError, g.MailOnlyOnError)
+	inheritBool(&c.MailInlineOutput, g.MailInlineOutput)
 }
 
 // NewMail returns a Mail middleware if the given configuration is not empty
@@ -51,7 +125,7 @@ func (m *Mail) Run(ctx *core.Context) error {
 	err := ctx.Next()
 	ctx.Stop(err)
 
-	if ctx.Execution.Failed || !m.MailOnlyOnError {
+	if shouldNotify(ctx, isTrue(m.MailOnlyOnError)) {
 		err := m.sendMail(ctx)
 		if err != nil {
 			ctx.Logger.Errorf("Mail error: %q", err)
@@ -62,39 +136,185 @@ func (m *Mail) Run(ctx *core.Context) error {
 }
 
 func (m *Mail) sendMail(ctx *core.Context) error {
+	msg, err := m.buildMessage(ctx)
+	if err != nil {
+		return err
+	}
+
+	switch m.SMTPTLS {
+	case "", SMTPTLSImplicit:
+		return m.dialer().DialAndSend(msg)
+	case SMTPTLSStartTLS:
+		return m.sendSMTP(msg, true)
+	case SMTPTLSNone:
+		return m.sendSMTP(msg, false)
+	default:
+		return fmt.Errorf("invalid smtp-tls %q", m.SMTPTLS)
+	}
+}
+
+// Validate returns an error if the smtp-tls or mail-format are unknown, or if
+// the templates can't be read or parsed
+func (c *MailConfig) Validate() error {
+	switch c.SMTPTLS {
+	case "", SMTPTLSImplicit, SMTPTLSStartTLS, SMTPTLSNone:
+	default:
+		return fmt.Errorf("invalid smtp-tls %q", c.SMTPTLS)
+	}
+
+	m := &Mail{*c}
+	if _, err := m.subjectTemplate(); err != nil {
+		return err
+	}
+
+	_, _, err := m.bodyTemplate()
+	return err
+}
+
+func (m *Mail) buildMessage(ctx *core.Context) (*gomail.Message, error) {
+	data := newMailData(ctx, isTrue(m.MailInlineOutput))
+
+	subject, err := m.subject(data)
+	if err != nil {
+		return nil, err
+	}
+
+	contentType, body, err := m.body(data)
+	if err != nil {
+		return nil, err
+	}
+
 	msg := gomail.NewMessage()
 	msg.SetHeader("From", m.from())
-	msg.SetHeader("To", strings.Split(m.EmailTo, ",")...)
-	msg.SetHeader("Subject", m.subject(ctx))
-	msg.SetBody("text/html", m.body(ctx))
+	msg.SetHeader("To", splitAddresses(m.EmailTo)...)
+	if m.EmailCC != "" {
+		msg.SetHeader("Cc", splitAddresses(m.EmailCC)...)
+	}
 
-	base := fmt.Sprintf("%s_%s", ctx.Job.GetName(), ctx.Execution.ID)
-	msg.Attach(base+".stdout.log", gomail.SetCopyFunc(func(w io.Writer) error {
-		_, err := w.Write(ctx.Execution.OutputStream.Bytes())
-		return err
-	}))
+	if m.EmailBCC != "" {
+		msg.SetHeader("Bcc", splitAddresses(m.EmailBCC)...)
+	}
 
-	msg.Attach(base+".stderr.log", gomail.SetCopyFunc(func(w io.Writer) error {
-		_, err := w.Write(ctx.Execution.ErrorStream.Bytes())
-		return err
-	}))
+	msg.SetHeader("Subject", subject)
+	msg.SetBody(contentType, body)
+
+	base := fmt.Sprintf("%s_%s", ctx.Job.GetName(), ctx.Execution.ID)
+	if !isTrue(m.MailInlineOutput) {
+		msg.Attach(base+".stdout.log", gomail.SetCopyFunc(func(w io.Writer) error {
+			_, err := w.Write(ctx.Execution.OutputStream.Bytes())
+			return err
+		}))
+
+		msg.Attach(base+".stderr.log", gomail.SetCopyFunc(func(w io.Writer) error {
+			_, err := w.Write(ctx.Execution.ErrorStream.Bytes())
+			return err
+		}))
+	}
 
-	msg.Attach(base+".stderr.json", gomail.SetCopyFunc(func(w io.Writer) error {
-		js, _ := json.MarshalIndent(map[string]interface{}{
+	msg.Attach(base+".json", gomail.SetCopyFunc(func(w io.Writer) error {
+		_, err := w.Write(marshalRecord(ctx, map[string]interface{}{
 			"Job":       ctx.Job,
 			"Execution": ctx.Execution,
-		}, "", "  ")
-
-		_, err := w.Write(js)
+		}))
 		return err
 	}))
 
+	return msg, nil
+}
+
+// dialer returns the dialer for the default and tls modes, by default the
+// connection is upgraded when the server supports it
+func (m *Mail) dialer() *gomail.Dialer {
 	d := gomail.NewPlainDialer(m.SMTPHost, m.SMTPPort, m.SMTPUser, m.SMTPPassword)
-	if err := d.DialAndSend(msg); err != nil {
+	d.SSL = m.SMTPTLS == SMTPTLSImplicit || (m.SMTPTLS == "" && m.SMTPPort == 465)
+	d.TLSConfig = m.tlsConfig()
+
+	return d
+}
+
+func (m *Mail) tlsConfig() *tls.Config {
+	return &tls.Config{
+		ServerName:         m.SMTPHost,
+		InsecureSkipVerify: isTrue(m.SMTPTLSSkipVerify),
+	}
+}
+
+// sendSMTP sends the message over a connection upgraded with STARTTLS, failing
+// if the server doesn't support it, or without any encryption. The dialer of
+// gomail only upgrades the connection when the server supports it.
+func (m *Mail) sendSMTP(msg *gomail.Message, startTLS bool) error {
+	addr := net.JoinHostPort(m.SMTPHost, strconv.Itoa(m.SMTPPort))
+	conn, err := net.DialTimeout("tcp", addr, mailDialTimeout)
+	if err != nil {
+		return err
+	}
+
+	c, err := smtp.NewClient(conn, m.SMTPHost)
+	if err != nil {
+		conn.Close()
 		return err
 	}
 
-	return nil
+	defer c.Close()
+	var auth smtp.Auth = &plainAuth{smtp.PlainAuth("", m.SMTPUser, m.SMTPPassword, m.SMTPHost)}
+	if startTLS {
+		if ok, _ := c.Extension("STARTTLS"); !ok {
+			return fmt.Errorf("smtp server %s doesn't support STARTTLS", m.SMTPHost)
+		}
+
+		if err := c.StartTLS(m.tlsConfig()); err != nil {
+			return err
+		}
+
+		auth = smtp.PlainAuth("", m.SMTPUser, m.SMTPPassword, m.SMTPHost)
+	}
+
+	if m.SMTPUser != "" {
+		if err := c.Auth(auth); err != nil {
+			return err
+		}
+	}
+
+	return gomail.Send(gomail.SendFunc(func(from string, to []string, msg io.WriterTo) error {
+		if err := c.Mail(from); err != nil {
+			return err
+		}
+
+		for _, addr := range to {
+			if err := c.Rcpt(addr); err != nil {
+				return err
+			}
+		}
+
+		w, err := c.Data()
+		if err != nil {
+			return err
+		}
+
+		if _, err := msg.WriteTo(w); err != nil {
+			w.Close()
+			return err
+		}
+
+		if err := w.Close(); err != nil {
+			return err
+		}
+
+		return c.Quit()
+	}), msg)
+}
+
+// plainAuth allows the PLAIN authentication over an unencrypted connection,
+// refused by net/smtp except for localhost
+type plainAuth struct {
+	smtp.Auth
+}
+
+func (a *plainAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	s := *server
+	s.TLS = true
+
+	return a.Auth.Start(&s)
 }
 
 func (m *Mail) from() string {
@@ -106,42 +326,194 @@ func (m *Mail) from() string {
 	return fmt.Sprintf(m.EmailFrom, hostname)
 }
 
-func (m *Mail) subject(ctx *core.Context) string {
+func (m *Mail) subjectTemplate() (*texttemplate.Template, error) {
+	if m.MailSubjectTemplate == "" {
+		return mailSubjectTemplate, nil
+	}
+
+	text, err := readTemplate(m.MailSubjectTemplate)
+	if err != nil {
+		return nil, fmt.Errorf("error reading mail-subject-template: %s", err)
+	}
+
+	t, err := texttemplate.New("mail-subject").Funcs(mailFuncs).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid mail-subject-template: %s", err)
+	}
+
+	return t, nil
+}
+
+func (m *Mail) subject(data *mailData) (string, error) {
+	t, err := m.subjectTemplate()
+	if err != nil {
+		return "", err
+	}
+
 	buf := bytes.NewBuffer(nil)
-	mailSubjectTemplate.Execute(buf, ctx)
+	if err := t.Execute(buf, data); err != nil {
+		return "", fmt.Errorf("error executing mail-subject-template: %s", err)
+	}
+
+	// a subject spans a single line
+	return strings.Join(strings.Fields(buf.String()), " "), nil
+}
+
+// mailTemplate is implemented by both the html and the text templates
+type mailTemplate interface {
+	Execute(io.Writer, interface{}) error
+}
+
+// bodyTemplate returns the content type and the template of the body, html
+// templates escape the values, while text ones are used as is
+func (m *Mail) bodyTemplate() (string, mailTemplate, error) {
+	var text string
+	if m.MailBodyTemplate != "" {
+		var err error
+		if text, err = readTemplate(m.MailBodyTemplate); err != nil {
+			return "", nil, fmt.Errorf("error reading mail-body-template: %s", err)
+		}
+	}
+
+	switch m.MailFormat {
+	case "", MailFormatHTML:
+		if text == "" {
+			return "text/html", mailBodyTemplate, nil
+		}
+
+		t, err := template.New("mail-body").Funcs(mailFuncs).Parse(text)
+		if err != nil {
+			return "", nil, fmt.Errorf("invalid mail-body-template: %s", err)
+		}
+
+		return "text/html", t, nil
+	case MailFormatText:
+		if text == "" {
+			return "text/plain", mailTextBodyTemplate, nil
+		}
 
-	return buf.String()
+		t, err := texttemplate.New("mail-body").Funcs(mailFuncs).Parse(text)
+		if err != nil {
+			return "", nil, fmt.Errorf("invalid mail-body-template: %s", err)
+		}
+
+		return "text/plain", t, nil
+	default:
+		return "", nil, fmt.Errorf("invalid mail-format %q", m.MailFormat)
+	}
 }
 
-func (m *Mail) body(ctx *core.Context) string {
+// body returns the content type and the body of the mail
+func (m *Mail) body(data *mailData) (string, string, error) {
+	contentType, t, err := m.bodyTemplate()
+	if err != nil {
+		return "", "", err
+	}
+
 	buf := bytes.NewBuffer(nil)
-	mailBodyTemplate.Execute(buf, ctx)
+	if err := t.Execute(buf, data); err != nil {
+		return "", "", fmt.Errorf("error executing mail-body-template: %s", err)
+	}
 
-	return buf.String()
+	return contentType, buf.String(), nil
 }
 
-var mailBodyTemplate, mailSubjectTemplate *template.Template
+// readTemplate returns the content of the file when the template is the path
+// of an existing file, or looks like a path, a single word with a slash, so a
+// missing file is reported. Otherwise it returns the template itself.
+func readTemplate(t string) (string, error) {
+	if strings.Contains(t, "{{") {
+		return t, nil
+	}
 
-func init() {
-	f := map[string]interface{}{
-		"status": executionLabel,
+	isPath := strings.ContainsAny(t, `/\`) && len(strings.Fields(t)) == 1
+	if _, err := os.Stat(t); err != nil && !isPath {
+		return t, nil
 	}
 
-	mailBodyTemplate = template.New("mail-body")
-	mailSubjectTemplate = template.New("mail-subject")
-	mailBodyTemplate.Funcs(f)
-	mailSubjectTemplate.Funcs(f)
+	b, err := ioutil.ReadFile(t)
+	return string(b), err
+}
+
+func splitAddresses(s string) []string {
+	var addrs []string
+	for _, a := range strings.Split(s, ",") {
+		if a = strings.TrimSpace(a); a != "" {
+			addrs = append(addrs, a)
+		}
+	}
+
+	return addrs
+}
 
-	template.Must(mailBodyTemplate.Parse(`
+// mailData is the data given to the templates, the output is only filled when
+// it is inlined, truncated to its last bytes
+type mailData struct {
+	Job       core.Job
+	Execution *core.Execution
+	Status    string
+	Error     string
+	Output    string
+	Stderr    string
+}
+
+func newMailData(ctx *core.Context, inline bool) *mailData {
+	d := &mailData{
+		Job:       ctx.Job,
+		Execution: ctx.Execution,
+		Status:    notifyStatus(ctx),
+	}
+
+	if ctx.Execution.Error != nil {
+		d.Error = ctx.Execution.Error.Error()
+	}
+
+	if inline {
+		d.Output = tail(ctx.Execution.OutputStream.Bytes(), mailOutputSize)
+		d.Stderr = tail(ctx.Execution.ErrorStream.Bytes(), mailOutputSize)
+	}
+
+	return d
+}
+
+var mailFuncs = map[string]interface{}{
+	"status": executionLabel,
+}
+
+var (
+	mailBodyTemplate     *template.Template
+	mailTextBodyTemplate *texttemplate.Template
+	mailSubjectTemplate  *texttemplate.Template
+)
+
+func init() {
+	mailBodyTemplate = template.Must(template.New("mail-body").Funcs(mailFuncs).Parse(`
 		<p>
 			Job ​<b>{{.Job.GetName}}</b>,
-			Execution <b>{{status .Execution}}</b> in ​<b>{{.Execution.Duration}}</b>​,
+			Execution <b>{{.Status}}</b> in ​<b>{{.Execution.Duration}}</b>​,
 			command: ​<pre>{{.Job.GetCommand}}</pre>​
 		</p>
+		{{- if .Error}}
+		<p>Error: <pre>{{.Error}}</pre></p>
+		{{- end}}
+		{{- if .Output}}
+		<p>Output: <pre>{{.Output}}</pre></p>
+		{{- end}}
+		{{- if .Stderr}}
+		<p>Stderr: <pre>{{.Stderr}}</pre></p>
+		{{- end}}
   `))
 
-	template.Must(mailSubjectTemplate.Parse(
-		"[Execution {{status .Execution}}] Job {{.Job.GetName}} finished in {{.Execution.Duration}}",
+	mailTextBodyTemplate = texttemplate.Must(texttemplate.New("mail-body").Funcs(mailFuncs).Parse(
+		"Job {{.Job.GetName}}, execution {{.Status}} in {{.Execution.Duration}}, " +
+			"command: {{.Job.GetCommand}}\n" +
+			"{{if .Error}}\nError: {{.Error}}\n{{end}}" +
+			"{{if .Output}}\nOutput:\n{{.Output}}\n{{end}}" +
+			"{{if .Stderr}}\nStderr:\n{{.Stderr}}\n{{end}}",
+	))
+
+	mailSubjectTemplate = texttemplate.Must(texttemplate.New("mail-subject").Funcs(mailFuncs).Parse(
+		"[Execution {{.Status}}] Job {{.Job.GetName}} finished in {{.Execution.Duration}}",
 	))
 }
 
@@ -1,12 +1,22 @@
 package middlewares
 
 import (
+	"encoding/base64"
+	"errors"
+	"io/ioutil"
+	"mime"
+	"mime/multipart"
 	"net"
+	"net/mail"
+	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	"github.com/bradfitz/go-smtpd/smtpd"
+	"github.com/vigasin/ofelia/core"
 
 	. "gopkg.in/check.v1"
 )
@@ -33,8 +43,9 @@ func (s *MailSuite) SetUpTest(c *C) {
 	c.Assert(err, IsNil)
 
 	s.l = ln
+	srv := s.smtpd
 	go func() {
-		err := s.smtpd.Serve(ln)
+		err := srv.Serve(ln)
 		c.Assert(err, IsNil)
 	}()
 
@@ -77,3 +88,247 @@ func (s *MailSuite) TestRunSuccess(c *C) {
 
 	wg.Wait()
 }
+
+func (s *MailSuite) TestRunRecipients(c *C) {
+	s.ctx.Start()
+	s.ctx.Stop(nil)
+
+	m := NewMail(&MailConfig{
+		SMTPHost:  s.smtpdHost,
+		SMTPPort:  s.smtpdPort,
+		SMTPTLS:   SMTPTLSNone,
+		EmailTo:   "foo@foo.com, bar@bar.com",
+		EmailCC:   "baz@baz.com",
+		EmailBCC:  "qux@qux.com",
+		EmailFrom: "qux@qux.com",
+	})
+
+	e := s.receive(c, m)
+	c.Assert(e.rcpts, DeepEquals, []string{"foo@foo.com", "bar@bar.com", "baz@baz.com", "qux@qux.com"})
+	c.Assert(strings.Contains(e.data, "Cc: baz@baz.com"), Equals, true)
+	c.Assert(strings.Contains(e.data, "qux@qux.com\r\nSubject"), Equals, false)
+}
+
+func (s *MailSuite) TestRunTemplates(c *C) {
+	s.job.Name = "foo"
+	s.ctx.Start()
+	s.ctx.Execution.OutputStream.Write([]byte("<foo>"))
+	s.ctx.Stop(errors.New("bar"))
+
+	dir, err := ioutil.TempDir("", "mail")
+	c.Assert(err, IsNil)
+	defer os.RemoveAll(dir)
+
+	body := filepath.Join(dir, "body.tmpl")
+	err = ioutil.WriteFile(body, []byte("{{.Status}}: {{.Error}} {{.Output}}"), 0644)
+	c.Assert(err, IsNil)
+
+	m := NewMail(&MailConfig{
+		SMTPHost:            s.smtpdHost,
+		SMTPPort:            s.smtpdPort,
+		EmailTo:             "foo@foo.com",
+		EmailFrom:           "qux@qux.com",
+		MailFormat:          MailFormatText,
+		MailSubjectTemplate: "{{.Job.GetName}} {{status .Execution}}",
+		MailBodyTemplate:    body,
+		MailInlineOutput:    newBool(true),
+	})
+
+	e := s.receive(c, m)
+	c.Assert(strings.Contains(e.data, "Subject: foo failed"), Equals, true)
+	c.Assert(strings.Contains(e.data, "Content-Type: text/plain"), Equals, true)
+	c.Assert(strings.Contains(e.data, "failed: bar <foo>"), Equals, true)
+	c.Assert(strings.Contains(e.data, ".stdout.log"), Equals, false)
+	c.Assert(strings.Contains(e.data, ".json"), Equals, true)
+}
+
+func (s *MailSuite) TestRunNoSecret(c *C) {
+	s.setSecretTestJob(c)
+	s.ctx.Start()
+	s.ctx.Stop(nil)
+
+	m := NewMail(&MailConfig{
+		SMTPHost:  s.smtpdHost,
+		SMTPPort:  s.smtpdPort,
+		SMTPTLS:   SMTPTLSNone,
+		EmailTo:   "foo@foo.com",
+		EmailFrom: "qux@qux.com",
+	})
+
+	e := s.receive(c, m)
+	assertNoSecret(c, e.attachment(c, "foo_"+s.ctx.Execution.ID+".json"))
+}
+
+func (s *MailSuite) TestBodyHTML(c *C) {
+	s.ctx.Start()
+	s.ctx.Execution.OutputStream.Write([]byte("<foo>"))
+	s.ctx.Stop(nil)
+
+	m := &Mail{MailConfig{MailInlineOutput: newBool(true)}}
+	contentType, body, err := m.body(newMailData(s.ctx, true))
+	c.Assert(err, IsNil)
+	c.Assert(contentType, Equals, "text/html")
+	c.Assert(strings.Contains(body, "<pre>&lt;foo&gt;</pre>"), Equals, true)
+}
+
+func (s *MailSuite) TestRunStartTLSUnsupported(c *C) {
+	s.ctx.Start()
+	s.ctx.Stop(nil)
+
+	m := &Mail{MailConfig{
+		SMTPHost:  s.smtpdHost,
+		SMTPPort:  s.smtpdPort,
+		SMTPTLS:   SMTPTLSStartTLS,
+		EmailTo:   "foo@foo.com",
+		EmailFrom: "qux@qux.com",
+	}}
+
+	c.Assert(m.sendMail(s.ctx), ErrorMatches, "smtp server .* doesn't support STARTTLS")
+}
+
+func (s *MailSuite) TestInvalidConfig(c *C) {
+	s.ctx.Start()
+	s.ctx.Stop(nil)
+
+	m := &Mail{MailConfig{SMTPTLS: "foo"}}
+	c.Assert(m.sendMail(s.ctx), ErrorMatches, `invalid smtp-tls "foo"`)
+
+	m = &Mail{MailConfig{MailFormat: "foo"}}
+	c.Assert(m.sendMail(s.ctx), ErrorMatches, `invalid mail-format "foo"`)
+
+	m = &Mail{MailConfig{MailBodyTemplate: "{{"}}
+	c.Assert(m.sendMail(s.ctx), ErrorMatches, "invalid mail-body-template: .*")
+
+	m = &Mail{MailConfig{MailSubjectTemplate: "{{"}}
+	c.Assert(m.sendMail(s.ctx), ErrorMatches, "invalid mail-subject-template: .*")
+}
+
+func (s *MailSuite) TestDialer(c *C) {
+	m := &Mail{MailConfig{SMTPHost: "foo", SMTPPort: 465}}
+	c.Assert(m.dialer().SSL, Equals, true)
+
+	m = &Mail{MailConfig{SMTPHost: "foo", SMTPPort: 25, SMTPTLS: SMTPTLSImplicit, SMTPTLSSkipVerify: newBool(true)}}
+	d := m.dialer()
+	c.Assert(d.SSL, Equals, true)
+	c.Assert(d.TLSConfig.ServerName, Equals, "foo")
+	c.Assert(d.TLSConfig.InsecureSkipVerify, Equals, true)
+
+	m = &Mail{MailConfig{SMTPHost: "foo", SMTPPort: 465, SMTPTLS: SMTPTLSStartTLS}}
+	c.Assert(m.dialer().SSL, Equals, false)
+}
+
+func (s *MailSuite) TestInherit(c *C) {
+	global := &MailConfig{
+		SMTPHost:        "foo",
+		SMTPPort:        25,
+		EmailTo:         "foo@foo.com",
+		EmailFrom:       "bar@bar.com",
+		MailOnlyOnError: newBool(true),
+	}
+
+	job := &MailConfig{}
+	job.Inherit(global)
+	c.Assert(IsEmpty(job), Equals, true)
+
+	job = &MailConfig{EmailTo: "qux@qux.com", EmailCC: "baz@baz.com"}
+	job.Inherit(global)
+	c.Assert(job, DeepEquals, &MailConfig{
+		SMTPHost:        "foo",
+		SMTPPort:        25,
+		EmailTo:         "qux@qux.com",
+		EmailCC:         "baz@baz.com",
+		EmailFrom:       "bar@bar.com",
+		MailOnlyOnError: newBool(true),
+	})
+
+	job = &MailConfig{EmailTo: "qux@qux.com", MailOnlyOnError: newBool(false)}
+	job.Inherit(global)
+	c.Assert(*job.MailOnlyOnError, Equals, false)
+}
+
+func (s *MailSuite) TestReadTemplate(c *C) {
+	t, err := readTemplate("{{.Status}}")
+	c.Assert(err, IsNil)
+	c.Assert(t, Equals, "{{.Status}}")
+
+	t, err = readTemplate("Backup of foo/bar")
+	c.Assert(err, IsNil)
+	c.Assert(t, Equals, "Backup of foo/bar")
+
+	_, err = readTemplate("/foo/bar")
+	c.Assert(err, NotNil)
+}
+
+func (s *MailSuite) TestValidate(c *C) {
+	c.Assert((&MailConfig{SMTPTLS: SMTPTLSNone, MailFormat: MailFormatText}).Validate(), IsNil)
+
+	c.Assert((&MailConfig{SMTPTLS: "foo"}).Validate(), ErrorMatches, `invalid smtp-tls "foo"`)
+	c.Assert((&MailConfig{MailFormat: "foo"}).Validate(), ErrorMatches, `invalid mail-format "foo"`)
+	c.Assert((&MailConfig{MailBodyTemplate: "{{"}).Validate(), ErrorMatches, "invalid mail-body-template: .*")
+	c.Assert((&MailConfig{MailSubjectTemplate: "{{"}).Validate(), ErrorMatches, "invalid mail-subject-template: .*")
+	c.Assert((&MailConfig{MailBodyTemplate: "/foo/bar.html"}).Validate(), ErrorMatches, "error reading mail-body-template: .*")
+}
+
+// receive runs the middleware and returns the mail received by the server
+func (s *MailSuite) receive(c *C, m core.Middleware) *testEnvelope {
+	e := &testEnvelope{done: make(chan struct{})}
+	s.smtpd.OnNewMail = func(_ smtpd.Connection, from smtpd.MailAddress) (smtpd.Envelope, error) {
+		return e, nil
+	}
+
+	go m.Run(s.ctx)
+	select {
+	case <-e.done:
+	case <-time.After(5 * time.Second):
+		c.Fatal("no mail received")
+	}
+
+	return e
+}
+
+type testEnvelope struct {
+	rcpts []string
+	data  string
+	done  chan struct{}
+}
+
+// attachment returns the decoded content of the attached file
+func (e *testEnvelope) attachment(c *C, name string) []byte {
+	msg, err := mail.ReadMessage(strings.NewReader(e.data))
+	c.Assert(err, IsNil)
+
+	_, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	c.Assert(err, IsNil)
+
+	r := multipart.NewReader(msg.Body, params["boundary"])
+	for {
+		p, err := r.NextPart()
+		c.Assert(err, IsNil)
+		if p.FileName() != name {
+			continue
+		}
+
+		data, err := ioutil.ReadAll(base64.NewDecoder(base64.StdEncoding, p))
+		c.Assert(err, IsNil)
+		return data
+	}
+}
+
+func (e *testEnvelope) AddRecipient(rcpt smtpd.MailAddress) error {
+	e.rcpts = append(e.rcpts, rcpt.Email())
+	return nil
+}
+
+func (e *testEnvelope) BeginData() error {
+	return nil
+}
+
+func (e *testEnvelope) Write(line []byte) error {
+	e.data += string(line)
+	return nil
+}
+
+func (e *testEnvelope) Close() error {
+	close(e.done)
+	return nil
+}
@@ -0,0 +1,333 @@
+package middlewares
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/vigasin/ofelia/core"
+)
+
+// Notification policies supported by the Notify middleware
+const (
+	NotifyOnAlways  = "always"
+	NotifyOnFailure = "failure"
+	NotifyOnChange  = "change"
+)
+
+// NotifyConfig configuration for the Notify middleware
+type NotifyConfig struct {
+	NotifyOn        string `gcfg:"notify-on" mapstructure:"notify-on"`
+	NotifyInterval  string `gcfg:"notify-interval" mapstructure:"notify-interval"`
+	NotifyThreshold int    `gcfg:"notify-threshold" mapstructure:"notify-threshold"`
+	NotifyStateFile string `gcfg:"notify-state-file" mapstructure:"notify-state-file"`
+}
+
+// Inherit fills the empty fields of a job configuration with the global one.
+// An empty configuration is left untouched, the global middleware is used
+// instead.
+func (c *NotifyConfig) Inherit(g *NotifyConfig) {
+	if IsEmpty(c) {
+		return
+	}
+
+	if c.NotifyOn == "" {
+		c.NotifyOn = g.NotifyOn
+	}
+
+	if c.NotifyInterval == "" {
+		c.NotifyInterval = g.NotifyInterval
+	}
+
+	if c.NotifyThreshold == 0 {
+		c.NotifyThreshold = g.NotifyThreshold
+	}
+
+	if c.NotifyStateFile == "" {
+		c.NotifyStateFile = g.NotifyStateFile
+	}
+}
+
+// Validate returns an error if the notify-on or notify-interval are invalid
+func (c *NotifyConfig) Validate() error {
+	_, err := (&Notify{*c}).interval()
+	return err
+}
+
+// NewNotify returns a Notify middleware if the given configuration is not empty
+func NewNotify(c *NotifyConfig) core.Middleware {
+	var m core.Middleware
+	if !IsEmpty(c) {
+		m = &Notify{*c}
+	}
+
+	return m
+}
+
+// Notify middleware holds the notification policy of a job, followed by the
+// Slack, Mail and Webhook middlewares. It doesn't do anything by itself, the
+// notifiers look it up in the middlewares of the job, whatever their order.
+type Notify struct {
+	NotifyConfig
+}
+
+// ContinueOnStop return allways true, the policy applies to every execution
+func (m *Notify) ContinueOnStop() bool {
+	return true
+}
+
+// Run just runs the next middleware
+func (m *Notify) Run(ctx *core.Context) error {
+	return ctx.Next()
+}
+
+// notification is the decision taken for an execution, shared by all the
+// notifiers
+type notification struct {
+	send      bool
+	recovered bool
+}
+
+// jobState is the status of a job persisted between the executions
+type jobState struct {
+	Failures  int       `json:"failures"`
+	Alerted   bool      `json:"alerted"`
+	LastAlert time.Time `json:"last_alert"`
+
+	// SlackThreads are the threads of the job by slack channel
+	SlackThreads map[string]string `json:"slack_threads,omitempty"`
+
+	execution    string
+	notification notification
+}
+
+// decide returns the notification of the execution, updating the state of the
+// job only once per execution
+func (m *Notify) decide(ctx *core.Context) (notification, error) {
+	interval, err := m.interval()
+	if err != nil {
+		return notification{send: true}, err
+	}
+
+	store, err := getNotifyStore(m.NotifyStateFile)
+	if err != nil {
+		return notification{send: true}, err
+	}
+
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	s := store.get(jobKey(ctx.Job))
+	if s.execution == ctx.Execution.ID {
+		return s.notification, nil
+	}
+
+	e := ctx.Execution
+	n := notification{}
+	switch {
+	case e.Skipped:
+		n.send = m.NotifyOn == "" || m.NotifyOn == NotifyOnAlways
+	case e.Failed:
+		s.Failures++
+		if s.Failures >= m.threshold() {
+			first := !s.Alerted
+			n.send = first || (m.NotifyOn != NotifyOnChange && time.Since(s.LastAlert) >= interval)
+		}
+
+		if n.send {
+			s.Alerted = true
+			s.LastAlert = time.Now()
+		}
+	default:
+		n.recovered = s.Alerted
+		n.send = n.recovered || m.NotifyOn == "" || m.NotifyOn == NotifyOnAlways
+		s.Failures = 0
+		s.Alerted = false
+	}
+
+	s.execution = e.ID
+	s.notification = n
+
+	return n, store.save()
+}
+
+func (m *Notify) interval() (time.Duration, error) {
+	switch m.NotifyOn {
+	case "", NotifyOnAlways, NotifyOnFailure, NotifyOnChange:
+	default:
+		return 0, fmt.Errorf("invalid notify-on %q", m.NotifyOn)
+	}
+
+	if m.NotifyInterval == "" {
+		return 0, nil
+	}
+
+	d, err := time.ParseDuration(m.NotifyInterval)
+	if err != nil {
+		return 0, fmt.Errorf("invalid notify-interval %q: %s", m.NotifyInterval, err)
+	}
+
+	return d, nil
+}
+
+func (m *Notify) threshold() int {
+	if m.NotifyThreshold < 1 {
+		return 1
+	}
+
+	return m.NotifyThreshold
+}
+
+// notifyStore keeps the state of the jobs, saved to a file if any
+type notifyStore struct {
+	mu   sync.Mutex
+	file string
+	jobs map[string]*jobState
+}
+
+var (
+	notifyStoresMu sync.Mutex
+	notifyStores   = make(map[string]*notifyStore)
+)
+
+// getNotifyStore returns the store of the given file, loading it the first
+// time, the state is kept in memory when no file is given
+func getNotifyStore(file string) (*notifyStore, error) {
+	notifyStoresMu.Lock()
+	defer notifyStoresMu.Unlock()
+
+	if s, ok := notifyStores[file]; ok {
+		return s, nil
+	}
+
+	s := &notifyStore{file: file, jobs: make(map[string]*jobState)}
+	if file != "" {
+		b, err := ioutil.ReadFile(file)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+
+		if len(b) > 0 {
+			if err := json.Unmarshal(b, &s.jobs); err != nil {
+				return nil, fmt.Errorf("error reading notify-state-file %q: %s", file, err)
+			}
+		}
+	}
+
+	notifyStores[file] = s
+	return s, nil
+}
+
+// jobKey identifies the job in the state, the jobs of different types may
+// share a name
+func jobKey(j core.Job) string {
+	t := reflect.TypeOf(j)
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	return t.Name() + "/" + j.GetName()
+}
+
+func (s *notifyStore) get(job string) *jobState {
+	if s.jobs[job] == nil {
+		s.jobs[job] = &jobState{}
+	}
+
+	return s.jobs[job]
+}
+
+// save writes the state to a temporary file renamed over the previous one, so
+// the file is never left half-written
+func (s *notifyStore) save() error {
+	if s.file == "" {
+		return nil
+	}
+
+	b, err := json.MarshalIndent(s.jobs, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.file), 0755); err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(filepath.Dir(s.file), filepath.Base(s.file)+".tmp")
+	if err != nil {
+		return err
+	}
+
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	return os.Rename(tmp.Name(), s.file)
+}
+
+// jobStore returns the store of the state of the job, the one of its policy if
+// any
+func jobStore(ctx *core.Context) (*notifyStore, error) {
+	file := ""
+	for _, m := range ctx.Job.Middlewares() {
+		if policy, ok := m.(*Notify); ok {
+			file = policy.NotifyStateFile
+			break
+		}
+	}
+
+	return getNotifyStore(file)
+}
+
+// notify returns the notification of the execution following the policy of
+// the job, without any policy every execution is notified
+func notify(ctx *core.Context) notification {
+	for _, m := range ctx.Job.Middlewares() {
+		policy, ok := m.(*Notify)
+		if !ok {
+			continue
+		}
+
+		n, err := policy.decide(ctx)
+		if err != nil {
+			ctx.Logger.Errorf("Notify error: %s", err)
+		}
+
+		return n
+	}
+
+	return notification{send: true}
+}
+
+// shouldNotify returns if a notifier should report the execution, the ones
+// reporting only errors also report the recoveries
+func shouldNotify(ctx *core.Context, onlyOnError bool) bool {
+	n := notify(ctx)
+	if !n.send {
+		return false
+	}
+
+	return ctx.Execution.Failed || n.recovered || !onlyOnError
+}
+
+// notifyStatus returns the status of the execution, "recovered" for a
+// successful one after notified failures
+func notifyStatus(ctx *core.Context) string {
+	if !ctx.Execution.Failed && !ctx.Execution.Skipped && notify(ctx).recovered {
+		return "recovered"
+	}
+
+	return executionLabel(ctx.Execution)
+}
@@ -0,0 +1,167 @@
+package middlewares
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/vigasin/ofelia/core"
+	. "gopkg.in/check.v1"
+)
+
+type SuiteNotify struct {
+	BaseSuite
+}
+
+var _ = Suite(&SuiteNotify{})
+
+func (s *SuiteNotify) SetUpTest(c *C) {
+	s.BaseSuite.SetUpTest(c)
+	s.job.Name = c.TestName()
+	notifyStores = make(map[string]*notifyStore)
+}
+
+func (s *SuiteNotify) TestNewNotifyEmpty(c *C) {
+	c.Assert(NewNotify(&NotifyConfig{}), IsNil)
+}
+
+func (s *SuiteNotify) TestWithoutPolicy(c *C) {
+	c.Assert(s.execute(errors.New("foo"), true), Equals, true)
+	c.Assert(s.execute(nil, true), Equals, false)
+	c.Assert(s.execute(nil, false), Equals, true)
+}
+
+func (s *SuiteNotify) TestNotifyOnFailure(c *C) {
+	s.job.Use(NewNotify(&NotifyConfig{NotifyOn: NotifyOnFailure}))
+
+	c.Assert(s.execute(nil, false), Equals, false)
+	c.Assert(s.execute(errors.New("foo"), false), Equals, true)
+	c.Assert(s.execute(errors.New("foo"), false), Equals, true)
+
+	c.Assert(s.execute(nil, true), Equals, true)
+	c.Assert(notifyStatus(s.ctx), Equals, "recovered")
+
+	c.Assert(s.execute(nil, false), Equals, false)
+	c.Assert(notifyStatus(s.ctx), Equals, "successful")
+}
+
+func (s *SuiteNotify) TestNotifyOnChange(c *C) {
+	s.job.Use(NewNotify(&NotifyConfig{NotifyOn: NotifyOnChange}))
+
+	c.Assert(s.execute(errors.New("foo"), true), Equals, true)
+	c.Assert(s.execute(errors.New("foo"), true), Equals, false)
+	c.Assert(s.execute(nil, true), Equals, true)
+	c.Assert(s.execute(nil, false), Equals, false)
+}
+
+func (s *SuiteNotify) TestInterval(c *C) {
+	s.job.Use(NewNotify(&NotifyConfig{NotifyOn: NotifyOnFailure, NotifyInterval: "1h"}))
+
+	c.Assert(s.execute(errors.New("foo"), true), Equals, true)
+	c.Assert(s.execute(errors.New("foo"), true), Equals, false)
+
+	store, _ := getNotifyStore("")
+	store.get(jobKey(s.job)).LastAlert = time.Now().Add(-2 * time.Hour)
+	c.Assert(s.execute(errors.New("foo"), true), Equals, true)
+}
+
+func (s *SuiteNotify) TestThreshold(c *C) {
+	s.job.Use(NewNotify(&NotifyConfig{NotifyOn: NotifyOnFailure, NotifyThreshold: 3}))
+
+	c.Assert(s.execute(errors.New("foo"), true), Equals, false)
+	c.Assert(s.execute(errors.New("foo"), true), Equals, false)
+
+	// the failures weren't notified, there is nothing to recover from
+	c.Assert(s.execute(nil, true), Equals, false)
+
+	c.Assert(s.execute(errors.New("foo"), true), Equals, false)
+	c.Assert(s.execute(errors.New("foo"), true), Equals, false)
+	c.Assert(s.execute(errors.New("foo"), true), Equals, true)
+}
+
+func (s *SuiteNotify) TestDecideOncePerExecution(c *C) {
+	s.job.Use(NewNotify(&NotifyConfig{NotifyOn: NotifyOnChange}))
+
+	c.Assert(s.execute(errors.New("foo"), true), Equals, true)
+	c.Assert(shouldNotify(s.ctx, true), Equals, true)
+	c.Assert(shouldNotify(s.ctx, false), Equals, true)
+}
+
+func (s *SuiteNotify) TestStateFile(c *C) {
+	dir, err := ioutil.TempDir("", "notify")
+	c.Assert(err, IsNil)
+	defer os.RemoveAll(dir)
+
+	file := filepath.Join(dir, "state.json")
+	s.job.Use(NewNotify(&NotifyConfig{NotifyOn: NotifyOnChange, NotifyStateFile: file}))
+
+	c.Assert(s.execute(errors.New("foo"), true), Equals, true)
+
+	// a restart doesn't alert again of the same failure
+	notifyStores = make(map[string]*notifyStore)
+	c.Assert(s.execute(errors.New("foo"), true), Equals, false)
+	c.Assert(s.execute(nil, true), Equals, true)
+
+	files, err := ioutil.ReadDir(dir)
+	c.Assert(err, IsNil)
+	c.Assert(files, HasLen, 1)
+}
+
+func (s *SuiteNotify) TestJobsOfDifferentTypes(c *C) {
+	s.job.Use(NewNotify(&NotifyConfig{NotifyOn: NotifyOnChange}))
+	c.Assert(s.execute(errors.New("foo"), true), Equals, true)
+
+	// a job of another type with the same name has its own state
+	other := &otherTestJob{}
+	other.Name = s.job.Name
+	other.Use(NewNotify(&NotifyConfig{NotifyOn: NotifyOnChange}))
+
+	ctx := core.NewContext(s.ctx.Scheduler, other, core.NewExecution())
+	ctx.Start()
+	ctx.Stop(nil)
+	c.Assert(shouldNotify(ctx, true), Equals, false)
+
+	c.Assert(jobKey(s.job), Not(Equals), jobKey(other))
+}
+
+func (s *SuiteNotify) TestInvalidConfig(c *C) {
+	m := &Notify{NotifyConfig{NotifyOn: "foo"}}
+	_, err := m.decide(s.ctx)
+	c.Assert(err, ErrorMatches, `invalid notify-on "foo"`)
+
+	m = &Notify{NotifyConfig{NotifyInterval: "foo"}}
+	_, err = m.decide(s.ctx)
+	c.Assert(err, ErrorMatches, `invalid notify-interval "foo": .*`)
+}
+
+func (s *SuiteNotify) TestValidate(c *C) {
+	c.Assert((&NotifyConfig{NotifyOn: NotifyOnFailure, NotifyInterval: "1h"}).Validate(), IsNil)
+	c.Assert((&NotifyConfig{NotifyOn: "foo"}).Validate(), ErrorMatches, `invalid notify-on "foo"`)
+	c.Assert((&NotifyConfig{NotifyInterval: "foo"}).Validate(), ErrorMatches, `invalid notify-interval "foo": .*`)
+}
+
+func (s *SuiteNotify) TestInherit(c *C) {
+	job := &NotifyConfig{}
+	job.Inherit(&NotifyConfig{NotifyOn: NotifyOnChange})
+	c.Assert(IsEmpty(job), Equals, true)
+
+	job = &NotifyConfig{NotifyThreshold: 2}
+	job.Inherit(&NotifyConfig{NotifyOn: NotifyOnChange, NotifyThreshold: 3, NotifyStateFile: "foo"})
+	c.Assert(job, DeepEquals, &NotifyConfig{NotifyOn: NotifyOnChange, NotifyThreshold: 2, NotifyStateFile: "foo"})
+}
+
+// execute runs a new execution of the job, returning if a notifier would
+// report it
+func (s *SuiteNotify) execute(err error, onlyOnError bool) bool {
+	s.ctx = core.NewContext(s.ctx.Scheduler, s.job, core.NewExecution())
+	s.ctx.Start()
+	s.ctx.Stop(err)
+
+	return shouldNotify(s.ctx, onlyOnError)
+}
+
+type otherTestJob struct {
+	TestJob
+}
@@ -0,0 +1,188 @@
+package middlewares
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/vigasin/ofelia/core"
+)
+
+var (
+	pingTimeout    = 10 * time.Second
+	pingOutputSize = 10000
+)
+
+// PingConfig configuration for the Ping middleware
+type PingConfig struct {
+	PingURL        string `gcfg:"ping-url" mapstructure:"ping-url" json:"-"`
+	PingStartURL   string `gcfg:"ping-start-url" mapstructure:"ping-start-url" json:"-"`
+	PingSuccessURL string `gcfg:"ping-success-url" mapstructure:"ping-success-url" json:"-"`
+	PingFailURL    string `gcfg:"ping-fail-url" mapstructure:"ping-fail-url" json:"-"`
+	PingMethod     string `gcfg:"ping-method" mapstructure:"ping-method"`
+	PingTimeout    string `gcfg:"ping-timeout" mapstructure:"ping-timeout"`
+}
+
+// Inherit fills the empty method and timeout of a job configuration with the
+// global ones, the URLs identify the check of every job so they are never
+// inherited. An empty configuration is left untouched, the global middleware
+// is used instead.
+func (c *PingConfig) Inherit(g *PingConfig) {
+	if IsEmpty(c) {
+		return
+	}
+
+	if c.PingMethod == "" {
+		c.PingMethod = g.PingMethod
+	}
+
+	if c.PingTimeout == "" {
+		c.PingTimeout = g.PingTimeout
+	}
+}
+
+// NewPing returns a Ping middleware if the given configuration is not empty
+func NewPing(c *PingConfig) core.Middleware {
+	var m core.Middleware
+	if !IsEmpty(c) {
+		m = &Ping{*c}
+	}
+
+	return m
+}
+
+// Ping middleware calls the URLs of a monitoring service when an execution
+// starts and finishes, so the service alerts when a job stops running. The
+// URLs follow healthchecks.io by default: <url>/start, <url> and <url>/fail.
+type Ping struct {
+	PingConfig
+}
+
+// ContinueOnStop Ping is only called if the process is still running, the
+// skipped executions aren't reported
+func (m *Ping) ContinueOnStop() bool {
+	return false
+}
+
+// Run pings the start URL, and the success or fail one after the execution
+func (m *Ping) Run(ctx *core.Context) error {
+	client, cerr := m.client()
+	if cerr != nil {
+		ctx.Logger.Errorf("Ping error: %s", cerr)
+	}
+
+	if u := m.startURL(); u != "" && cerr == nil {
+		if err := m.ping(client, u, nil); err != nil {
+			ctx.Logger.Errorf("Ping error calling %q: %s", u, err)
+		}
+	}
+
+	err := ctx.Next()
+	ctx.Stop(err)
+
+	if ctx.Execution.Skipped || cerr != nil {
+		return err
+	}
+
+	u := m.successURL()
+	if ctx.Execution.Failed {
+		u = m.failURL()
+	}
+
+	if u != "" {
+		if err := m.ping(client, u, pingBody(ctx.Execution)); err != nil {
+			ctx.Logger.Errorf("Ping error calling %q: %s", u, err)
+		}
+	}
+
+	return err
+}
+
+func (m *Ping) startURL() string {
+	if m.PingStartURL != "" || m.PingURL == "" {
+		return m.PingStartURL
+	}
+
+	return strings.TrimSuffix(m.PingURL, "/") + "/start"
+}
+
+func (m *Ping) successURL() string {
+	if m.PingSuccessURL != "" {
+		return m.PingSuccessURL
+	}
+
+	return m.PingURL
+}
+
+func (m *Ping) failURL() string {
+	if m.PingFailURL != "" || m.PingURL == "" {
+		return m.PingFailURL
+	}
+
+	return strings.TrimSuffix(m.PingURL, "/") + "/fail"
+}
+
+func (m *Ping) ping(client *http.Client, url string, body []byte) error {
+	method := strings.ToUpper(m.PingMethod)
+	if method == "" {
+		method = http.MethodPost
+	}
+
+	var r io.Reader
+	if method != http.MethodGet && method != http.MethodHead {
+		r = bytes.NewReader(body)
+	}
+
+	req, err := http.NewRequest(method, url, r)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+func (m *Ping) client() (*http.Client, error) {
+	timeout := pingTimeout
+	if m.PingTimeout != "" {
+		var err error
+		if timeout, err = time.ParseDuration(m.PingTimeout); err != nil {
+			return nil, fmt.Errorf("invalid ping-timeout %q: %s", m.PingTimeout, err)
+		}
+	}
+
+	return &http.Client{Timeout: timeout}, nil
+}
+
+// pingBody returns the exit code, the error and the last bytes of the output
+// of the execution, shown as the log of the ping by the monitoring services
+func pingBody(e *core.Execution) []byte {
+	buf := bytes.NewBuffer(nil)
+	fmt.Fprintf(buf, "exit code: %d\n", e.ExitCode)
+	if e.Error != nil {
+		fmt.Fprintf(buf, "error: %s\n", e.Error)
+	}
+
+	if e.OutputStream.TotalWritten() > 0 {
+		fmt.Fprintf(buf, "\nstdout:\n%s\n", tail(e.OutputStream.Bytes(), pingOutputSize))
+	}
+
+	if e.ErrorStream.TotalWritten() > 0 {
+		fmt.Fprintf(buf, "\nstderr:\n%s\n", tail(e.ErrorStream.Bytes(), pingOutputSize))
+	}
+
+	return buf.Bytes()
+}
@@ -0,0 +1,113 @@
+package middlewares
+
+import (
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+
+	"github.com/vigasin/ofelia/core"
+	. "gopkg.in/check.v1"
+)
+
+type SuitePing struct {
+	BaseSuite
+
+	server   *httptest.Server
+	requests []string
+	bodies   []string
+}
+
+var _ = Suite(&SuitePing{})
+
+func (s *SuitePing) SetUpTest(c *C) {
+	s.BaseSuite.SetUpTest(c)
+	s.requests, s.bodies = nil, nil
+
+	s.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := ioutil.ReadAll(r.Body)
+		s.requests = append(s.requests, r.Method+" "+r.URL.RequestURI())
+		s.bodies = append(s.bodies, string(b))
+	}))
+}
+
+func (s *SuitePing) TearDownTest(c *C) {
+	s.server.Close()
+}
+
+func (s *SuitePing) TestNewPingEmpty(c *C) {
+	c.Assert(NewPing(&PingConfig{}), IsNil)
+}
+
+func (s *SuitePing) TestRunSuccess(c *C) {
+	s.job.Use(NewPing(&PingConfig{PingURL: s.server.URL + "/uuid"}))
+	s.ctx = core.NewContext(s.ctx.Scheduler, s.job, core.NewExecution())
+	s.ctx.Start()
+
+	c.Assert(s.ctx.Next(), IsNil)
+	c.Assert(s.requests, DeepEquals, []string{"POST /uuid/start", "POST /uuid"})
+	c.Assert(s.bodies, DeepEquals, []string{"", "exit code: 0\n"})
+}
+
+func (s *SuitePing) TestRunFailed(c *C) {
+	s.ctx.Start()
+	s.ctx.Execution.OutputStream.Write([]byte("foo"))
+	s.ctx.Stop(errors.New("bar"))
+	s.ctx.Execution.ExitCode = 2
+
+	m := NewPing(&PingConfig{PingURL: s.server.URL + "/uuid/"})
+	c.Assert(m.Run(s.ctx), IsNil)
+	c.Assert(s.requests, DeepEquals, []string{"POST /uuid/start", "POST /uuid/fail"})
+	c.Assert(s.bodies[1], Equals, "exit code: 2\nerror: bar\n\nstdout:\nfoo\n")
+}
+
+func (s *SuitePing) TestRunCustomURLs(c *C) {
+	s.ctx.Start()
+	s.ctx.Stop(errors.New("bar"))
+
+	m := NewPing(&PingConfig{
+		PingSuccessURL: s.server.URL + "/api/push/token?status=up&msg=OK",
+		PingFailURL:    s.server.URL + "/api/push/token?status=down&msg=failed",
+		PingMethod:     "get",
+	})
+
+	c.Assert(m.Run(s.ctx), IsNil)
+	c.Assert(s.requests, DeepEquals, []string{"GET /api/push/token?status=down&msg=failed"})
+	c.Assert(s.bodies, DeepEquals, []string{""})
+}
+
+func (s *SuitePing) TestRunSkipped(c *C) {
+	s.job.Use(
+		NewOverlap(&OverlapConfig{NoOverlap: true}),
+		NewPing(&PingConfig{PingURL: s.server.URL}),
+	)
+
+	s.ctx = core.NewContext(s.ctx.Scheduler, s.job, core.NewExecution())
+	s.job.NotifyStart()
+	s.ctx.Start()
+
+	c.Assert(s.ctx.Next(), IsNil)
+	c.Assert(s.ctx.Execution.Skipped, Equals, true)
+	c.Assert(s.requests, HasLen, 0)
+}
+
+func (s *SuitePing) TestInvalidTimeout(c *C) {
+	s.ctx.Start()
+	s.ctx.Stop(nil)
+
+	m := NewPing(&PingConfig{PingURL: s.server.URL, PingTimeout: "foo"})
+	c.Assert(m.Run(s.ctx), IsNil)
+	c.Assert(s.requests, HasLen, 0)
+}
+
+func (s *SuitePing) TestInherit(c *C) {
+	global := &PingConfig{PingURL: "http://foo", PingMethod: "GET", PingTimeout: "1s"}
+
+	job := &PingConfig{}
+	job.Inherit(global)
+	c.Assert(IsEmpty(job), Equals, true)
+
+	job = &PingConfig{PingURL: "http://bar", PingTimeout: "2s"}
+	job.Inherit(global)
+	c.Assert(job, DeepEquals, &PingConfig{PingURL: "http://bar", PingMethod: "GET", PingTimeout: "2s"})
+}
@@ -2,19 +2,63 @@ package middlewares
 
 import (
 	"bytes"
-	"encoding/json"
+	"compress/gzip"
 	"fmt"
-	"io"
+	"io/ioutil"
 	"os"
+	"os/exec"
 	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
 
 	"github.com/vigasin/ofelia/core"
 )
 
+// Compressions and layouts supported by the Save middleware
+const (
+	SaveCompressionGzip = "gzip"
+	SaveCompressionZstd = "zstd"
+
+	SaveLayoutFlat = "flat"
+	SaveLayoutJob  = "job"
+)
+
+const saveTimeFormat = "20060102_150405"
+
+// saveIDPattern matches the ID of the execution following the date and job in
+// the names of the files
+var saveIDPattern = regexp.MustCompile(`^_[0-9a-f]{12}\.`)
+
+const saveIDLength = len("_") + 12
+
+// saveSuffixes are the files written for every execution, before compression
+var saveSuffixes = []string{".json", ".stdout.log", ".stderr.log"}
+
 // SaveConfig configuration for the Save middleware
 type SaveConfig struct {
 	SaveFolder      string `gcfg:"save-folder" mapstructure:"save-folder"`
 	SaveOnlyOnError bool   `gcfg:"save-only-on-error" mapstructure:"save-only-on-error"`
+	SaveLayout      string `gcfg:"save-layout" mapstructure:"save-layout"`
+	SaveCompression string `gcfg:"save-compression" mapstructure:"save-compression"`
+	SaveCombined    bool   `gcfg:"save-combined" mapstructure:"save-combined"`
+	SaveMaxAge      string `gcfg:"save-max-age" mapstructure:"save-max-age"`
+	SaveMaxFiles    int    `gcfg:"save-max-files" mapstructure:"save-max-files"`
+}
+
+// Validate returns an error if the layout, compression or maximum age are not
+// valid
+func (c *SaveConfig) Validate() error {
+	if _, err := c.extension(); err != nil {
+		return err
+	}
+
+	if _, err := core.ParseAge(c.SaveMaxAge); err != nil {
+		return fmt.Errorf("invalid save-max-age %q: %s", c.SaveMaxAge, err)
+	}
+
+	return nil
 }
 
 // NewSave returns a Save middleware if the given configuration is not empty
@@ -50,53 +94,247 @@ func (m *Save) Run(ctx *core.Context) error {
 		}
 	}
 
+	if err := m.cleanup(ctx.Job.GetName(), time.Now()); err != nil {
+		ctx.Logger.Errorf("Save error deleting old executions: %q", err)
+	}
+
 	return err
 }
 
 func (m *Save) saveToDisk(ctx *core.Context) error {
-	root := filepath.Join(m.SaveFolder, fmt.Sprintf(
-		"%s_%s",
-		ctx.Execution.Date.Format("20060102_150405"), ctx.Job.GetName(),
-	))
+	ext, err := m.extension()
+	if err != nil {
+		return err
+	}
+
+	dir := m.dir(ctx.Job.GetName())
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+
+	root := filepath.Join(dir, m.name(ctx.Job.GetName(), ctx.Execution))
 
 	e := ctx.Execution
-	err := m.saveReaderToDisk(bytes.NewReader(e.ErrorStream.Bytes()), fmt.Sprintf("%s.stderr.log", root))
+	record := map[string]interface{}{
+		"Job":       ctx.Job,
+		"Execution": e,
+		"Schedule":  ctx.Job.GetSchedule(),
+		"Date":      e.Date,
+	}
+
+	if m.SaveCombined {
+		record["Stdout"] = e.OutputStream.String()
+		record["Stderr"] = e.ErrorStream.String()
+	} else {
+		if err := m.writeFile(root+".stderr.log"+ext, e.ErrorStream.Bytes()); err != nil {
+			return err
+		}
+
+		if err := m.writeFile(root+".stdout.log"+ext, e.OutputStream.Bytes()); err != nil {
+			return err
+		}
+	}
+
+	return m.writeFile(root+".json"+ext, marshalRecord(ctx, record))
+}
+
+// dir returns the directory of the files of a job, the files are named
+// "<date>_<job>_<execution>" in the save folder, or "<date>_<execution>" in a
+// directory per job
+func (m *Save) dir(job string) string {
+	if m.SaveLayout == SaveLayoutJob {
+		return filepath.Join(m.SaveFolder, job)
+	}
+
+	return m.SaveFolder
+}
+
+// name returns the name of the files of the execution, without extension. The
+// ID of the execution tells apart the executions started the same second.
+func (m *Save) name(job string, e *core.Execution) string {
+	return e.Date.Format(saveTimeFormat) + m.nameSuffix(job) + "_" + e.ID
+}
+
+func (m *Save) nameSuffix(job string) string {
+	if m.SaveLayout == SaveLayoutJob {
+		return ""
+	}
+
+	return "_" + job
+}
+
+func (c *SaveConfig) extension() (string, error) {
+	switch c.SaveLayout {
+	case "", SaveLayoutFlat, SaveLayoutJob:
+	default:
+		return "", fmt.Errorf("invalid save-layout %q", c.SaveLayout)
+	}
+
+	switch c.SaveCompression {
+	case "":
+		return "", nil
+	case SaveCompressionGzip:
+		return ".gz", nil
+	case SaveCompressionZstd:
+		return ".zst", nil
+	default:
+		return "", fmt.Errorf("invalid save-compression %q", c.SaveCompression)
+	}
+}
+
+// writeFile writes the file compressed if requested, to a temporary file
+// renamed once complete, so a partially written file is never observed
+func (m *Save) writeFile(filename string, b []byte) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(filename), "."+filepath.Base(filename)+".tmp")
 	if err != nil {
 		return err
 	}
 
-	err = m.saveReaderToDisk(bytes.NewReader(e.OutputStream.Bytes()), fmt.Sprintf("%s.stdout.log", root))
-	if err != nil {
+	if err := m.compress(tmp, b); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
 		return err
 	}
 
-	err = m.saveContextToDisk(ctx, fmt.Sprintf("%s.json", root))
-	if err != nil {
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
 		return err
 	}
 
-	return nil
+	return os.Rename(tmp.Name(), filename)
 }
 
-func (m *Save) saveContextToDisk(ctx *core.Context, filename string) error {
-	js, _ := json.MarshalIndent(map[string]interface{}{
-		"Job":       ctx.Job,
-		"Execution": ctx.Execution,
-	}, "", "  ")
+func (m *Save) compress(f *os.File, b []byte) error {
+	switch m.SaveCompression {
+	case SaveCompressionGzip:
+		w := gzip.NewWriter(f)
+		if _, err := w.Write(b); err != nil {
+			return err
+		}
+
+		return w.Close()
+	case SaveCompressionZstd:
+		// there is no zstd encoder in the standard library, the zstd command
+		// is required
+		stderr := bytes.NewBuffer(nil)
+		cmd := exec.Command("zstd", "-q", "-c")
+		cmd.Stdin = bytes.NewReader(b)
+		cmd.Stdout = f
+		cmd.Stderr = stderr
 
-	return m.saveReaderToDisk(bytes.NewBuffer(js), filename)
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("error running zstd: %s %s", err, strings.TrimSpace(stderr.String()))
+		}
+
+		return nil
+	default:
+		_, err := f.Write(b)
+		return err
+	}
 }
 
-func (m *Save) saveReaderToDisk(r io.Reader, filename string) error {
-	f, err := os.Create(filename)
+// cleanup deletes the files of the executions of the job older than
+// save-max-age, or exceeding save-max-files
+func (m *Save) cleanup(job string, now time.Time) error {
+	if m.SaveMaxAge == "" && m.SaveMaxFiles <= 0 {
+		return nil
+	}
+
+	maxAge, err := core.ParseAge(m.SaveMaxAge)
 	if err != nil {
-		return err
+		return fmt.Errorf("invalid save-max-age %q: %s", m.SaveMaxAge, err)
 	}
 
-	defer f.Close()
-	if _, err := io.Copy(f, r); err != nil {
+	dir := m.dir(job)
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+
 		return err
 	}
 
+	type execution struct {
+		date  time.Time
+		files []string
+	}
+
+	executions := make(map[string]*execution)
+	for _, f := range files {
+		date, key, ok := m.parseName(f.Name(), job)
+		if !ok {
+			continue
+		}
+
+		if executions[key] == nil {
+			executions[key] = &execution{date: date}
+		}
+
+		executions[key].files = append(executions[key].files, f.Name())
+	}
+
+	keys := make([]string, 0, len(executions))
+	for key := range executions {
+		keys = append(keys, key)
+	}
+
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := executions[keys[i]], executions[keys[j]]
+		if a.date.Equal(b.date) {
+			return keys[i] > keys[j]
+		}
+
+		return a.date.After(b.date)
+	})
+
+	for i, key := range keys {
+		expired := maxAge > 0 && now.Sub(executions[key].date) > maxAge
+		if !expired && (m.SaveMaxFiles <= 0 || i < m.SaveMaxFiles) {
+			continue
+		}
+
+		for _, name := range executions[key].files {
+			if err := os.Remove(filepath.Join(dir, name)); err != nil && !os.IsNotExist(err) {
+				return err
+			}
+		}
+	}
+
 	return nil
 }
+
+// parseName returns the date of the execution of a file saved for the job, and
+// the name shared by the files of the execution. The files of other jobs, or
+// not saved by the middleware, are ignored. The files saved before the ID of
+// the execution was part of their name are recognized too.
+func (m *Save) parseName(name, job string) (time.Time, string, bool) {
+	if len(name) < len(saveTimeFormat) {
+		return time.Time{}, "", false
+	}
+
+	date, err := time.ParseInLocation(saveTimeFormat, name[:len(saveTimeFormat)], time.Local)
+	if err != nil {
+		return time.Time{}, "", false
+	}
+
+	rest := name[len(saveTimeFormat):]
+	if !strings.HasPrefix(rest, m.nameSuffix(job)) {
+		return time.Time{}, "", false
+	}
+
+	rest = rest[len(m.nameSuffix(job)):]
+	if saveIDPattern.MatchString(rest) {
+		rest = rest[saveIDLength:]
+	}
+
+	for _, suffix := range saveSuffixes {
+		for _, ext := range []string{"", ".gz", ".zst"} {
+			if rest == suffix+ext {
+				return date, strings.TrimSuffix(name, rest), true
+			}
+		}
+	}
+
+	return time.Time{}, "", false
+}
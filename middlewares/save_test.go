@@ -1,8 +1,12 @@
 package middlewares
 
 import (
+	"compress/gzip"
+	"encoding/json"
+	"errors"
 	"io/ioutil"
 	"os"
+	"os/exec"
 	"path/filepath"
 	"time"
 
@@ -32,13 +36,13 @@ func (s *SuiteSave) TestRunSuccess(c *C) {
 	m := NewSave(&SaveConfig{SaveFolder: dir})
 	c.Assert(m.Run(s.ctx), IsNil)
 
-	_, err = os.Stat(filepath.Join(dir, "00010101_000000_foo.json"))
+	_, err = os.Stat(filepath.Join(dir, "00010101_000000_foo_"+s.ctx.Execution.ID+".json"))
 	c.Assert(err, IsNil)
 
-	_, err = os.Stat(filepath.Join(dir, "00010101_000000_foo.stdout.log"))
+	_, err = os.Stat(filepath.Join(dir, "00010101_000000_foo_"+s.ctx.Execution.ID+".stdout.log"))
 	c.Assert(err, IsNil)
 
-	_, err = os.Stat(filepath.Join(dir, "00010101_000000_foo.stderr.log"))
+	_, err = os.Stat(filepath.Join(dir, "00010101_000000_foo_"+s.ctx.Execution.ID+".stderr.log"))
 	c.Assert(err, IsNil)
 }
 
@@ -55,6 +59,213 @@ func (s *SuiteSave) TestRunSuccessOnError(c *C) {
 	m := NewSave(&SaveConfig{SaveFolder: dir, SaveOnlyOnError: true})
 	c.Assert(m.Run(s.ctx), IsNil)
 
-	_, err = os.Stat(filepath.Join(dir, "00010101_000000_foo.json"))
+	_, err = os.Stat(filepath.Join(dir, "00010101_000000_foo_"+s.ctx.Execution.ID+".json"))
 	c.Assert(err, Not(IsNil))
 }
+
+func (s *SuiteSave) TestRunNoSecret(c *C) {
+	dir, err := ioutil.TempDir("", "save")
+	c.Assert(err, IsNil)
+	defer os.RemoveAll(dir)
+
+	s.setSecretTestJob(c)
+	s.ctx.Start()
+	s.ctx.Stop(nil)
+	s.ctx.Execution.Date = time.Time{}
+
+	m := NewSave(&SaveConfig{SaveFolder: dir})
+	c.Assert(m.Run(s.ctx), IsNil)
+
+	js, err := ioutil.ReadFile(filepath.Join(dir, "00010101_000000_foo_"+s.ctx.Execution.ID+".json"))
+	c.Assert(err, IsNil)
+	assertNoSecret(c, js)
+}
+
+func (s *SuiteSave) TestRunCombined(c *C) {
+	dir, err := ioutil.TempDir("/tmp", "save")
+	c.Assert(err, IsNil)
+	defer os.RemoveAll(dir)
+
+	s.ctx.Start()
+	s.ctx.Execution.OutputStream.Write([]byte("bar"))
+	s.ctx.Stop(errors.New("qux"))
+	s.ctx.Execution.ExitCode = 2
+
+	s.job.Name = "foo"
+	s.job.Schedule = "@hourly"
+	s.ctx.Execution.Date = time.Date(2021, 1, 9, 10, 30, 0, 0, time.Local)
+
+	m := NewSave(&SaveConfig{SaveFolder: dir, SaveLayout: SaveLayoutJob, SaveCombined: true})
+	c.Assert(m.Run(s.ctx), IsNil)
+
+	files, err := ioutil.ReadDir(filepath.Join(dir, "foo"))
+	c.Assert(err, IsNil)
+	c.Assert(files, HasLen, 1)
+	c.Assert(files[0].Name(), Equals, "20210109_103000_"+s.ctx.Execution.ID+".json")
+
+	b, err := ioutil.ReadFile(filepath.Join(dir, "foo", files[0].Name()))
+	c.Assert(err, IsNil)
+
+	var record struct {
+		Execution map[string]interface{}
+		Schedule  string
+		Date      time.Time
+		Stdout    string
+	}
+
+	c.Assert(json.Unmarshal(b, &record), IsNil)
+	c.Assert(record.Execution["Error"], Equals, "qux")
+	c.Assert(record.Execution["ExitCode"], Equals, 2.0)
+	c.Assert(record.Schedule, Equals, "@hourly")
+	c.Assert(record.Date.Equal(s.ctx.Execution.Date), Equals, true)
+	c.Assert(record.Stdout, Equals, "bar")
+}
+
+func (s *SuiteSave) TestRunGzip(c *C) {
+	dir, err := ioutil.TempDir("/tmp", "save")
+	c.Assert(err, IsNil)
+	defer os.RemoveAll(dir)
+
+	s.ctx.Start()
+	s.ctx.Execution.OutputStream.Write([]byte("bar"))
+	s.ctx.Stop(nil)
+
+	s.job.Name = "foo"
+	s.ctx.Execution.Date = time.Time{}
+
+	m := NewSave(&SaveConfig{SaveFolder: dir, SaveCompression: SaveCompressionGzip})
+	c.Assert(m.Run(s.ctx), IsNil)
+
+	f, err := os.Open(filepath.Join(dir, "00010101_000000_foo_"+s.ctx.Execution.ID+".stdout.log.gz"))
+	c.Assert(err, IsNil)
+	defer f.Close()
+
+	r, err := gzip.NewReader(f)
+	c.Assert(err, IsNil)
+
+	b, err := ioutil.ReadAll(r)
+	c.Assert(err, IsNil)
+	c.Assert(string(b), Equals, "bar")
+
+	files, err := ioutil.ReadDir(dir)
+	c.Assert(err, IsNil)
+	c.Assert(files, HasLen, 3)
+}
+
+func (s *SuiteSave) TestRunZstd(c *C) {
+	if _, err := exec.LookPath("zstd"); err != nil {
+		c.Skip("zstd not installed")
+	}
+
+	dir, err := ioutil.TempDir("/tmp", "save")
+	c.Assert(err, IsNil)
+	defer os.RemoveAll(dir)
+
+	s.ctx.Start()
+	s.ctx.Stop(nil)
+
+	s.job.Name = "foo"
+	s.ctx.Execution.Date = time.Time{}
+
+	m := NewSave(&SaveConfig{SaveFolder: dir, SaveCompression: SaveCompressionZstd, SaveCombined: true})
+	c.Assert(m.Run(s.ctx), IsNil)
+
+	out, err := exec.Command("zstd", "-d", "-c", filepath.Join(dir, "00010101_000000_foo_"+s.ctx.Execution.ID+".json.zst")).Output()
+	c.Assert(err, IsNil)
+	c.Assert(json.Valid(out), Equals, true)
+}
+
+func (s *SuiteSave) TestCleanup(c *C) {
+	dir, err := ioutil.TempDir("/tmp", "save")
+	c.Assert(err, IsNil)
+	defer os.RemoveAll(dir)
+
+	now := time.Date(2021, 1, 10, 0, 0, 0, 0, time.Local)
+	for _, name := range []string{
+		"20210109_000000_foo_0123456789ab.json", "20210109_000000_foo_0123456789ab.stdout.log",
+		"20210109_000000_foo_ba9876543210.json", "20210109_000000_foo_ba9876543210.stdout.log",
+		"20210108_000000_foo.json.gz", "20210108_000000_foo.stdout.log.gz",
+		"20210107_000000_foo.json",
+		"20210101_000000_foo.json",
+		"20210101_000000_foo.bar.json",
+		"20210101_000000_bar.json",
+		"foo.json",
+	} {
+		c.Assert(ioutil.WriteFile(filepath.Join(dir, name), nil, 0644), IsNil)
+	}
+
+	m := &Save{SaveConfig{SaveFolder: dir, SaveMaxAge: "5d"}}
+	c.Assert(m.cleanup("foo", now), IsNil)
+	c.Assert(s.listFiles(c, dir), DeepEquals, []string{
+		"20210101_000000_bar.json",
+		"20210101_000000_foo.bar.json",
+		"20210107_000000_foo.json",
+		"20210108_000000_foo.json.gz",
+		"20210108_000000_foo.stdout.log.gz",
+		"20210109_000000_foo_0123456789ab.json",
+		"20210109_000000_foo_0123456789ab.stdout.log",
+		"20210109_000000_foo_ba9876543210.json",
+		"20210109_000000_foo_ba9876543210.stdout.log",
+		"foo.json",
+	})
+
+	// the executions started the same second are counted apart
+	m = &Save{SaveConfig{SaveFolder: dir, SaveMaxFiles: 2}}
+	c.Assert(m.cleanup("foo", now), IsNil)
+	c.Assert(s.listFiles(c, dir), DeepEquals, []string{
+		"20210101_000000_bar.json",
+		"20210101_000000_foo.bar.json",
+		"20210109_000000_foo_0123456789ab.json",
+		"20210109_000000_foo_0123456789ab.stdout.log",
+		"20210109_000000_foo_ba9876543210.json",
+		"20210109_000000_foo_ba9876543210.stdout.log",
+		"foo.json",
+	})
+}
+
+func (s *SuiteSave) TestParseName(c *C) {
+	m := &Save{SaveConfig{SaveLayout: SaveLayoutJob}}
+
+	date, key, ok := m.parseName("20210109_103000_0123456789ab.stdout.log.gz", "foo")
+	c.Assert(ok, Equals, true)
+	c.Assert(key, Equals, "20210109_103000_0123456789ab")
+	c.Assert(date.Equal(time.Date(2021, 1, 9, 10, 30, 0, 0, time.Local)), Equals, true)
+
+	_, key, ok = m.parseName("20210109_103000.json", "foo")
+	c.Assert(ok, Equals, true)
+	c.Assert(key, Equals, "20210109_103000")
+
+	_, _, ok = m.parseName("20210109_103000_bar.json", "foo")
+	c.Assert(ok, Equals, false)
+}
+
+func (s *SuiteSave) TestInvalidConfig(c *C) {
+	s.ctx.Start()
+	s.ctx.Stop(nil)
+
+	m := &Save{SaveConfig{SaveCompression: "foo"}}
+	c.Assert(m.saveToDisk(s.ctx), ErrorMatches, `invalid save-compression "foo"`)
+
+	m = &Save{SaveConfig{SaveLayout: "foo"}}
+	c.Assert(m.saveToDisk(s.ctx), ErrorMatches, `invalid save-layout "foo"`)
+
+	m = &Save{SaveConfig{SaveMaxAge: "foo"}}
+	c.Assert(m.cleanup("foo", time.Now()), ErrorMatches, `invalid save-max-age "foo": .*`)
+	c.Assert(m.Validate(), ErrorMatches, `invalid save-max-age "foo": .*`)
+
+	c.Assert((&SaveConfig{SaveLayout: "foo"}).Validate(), ErrorMatches, `invalid save-layout "foo"`)
+	c.Assert((&SaveConfig{SaveCompression: "foo"}).Validate(), ErrorMatches, `invalid save-compression "foo"`)
+	c.Assert((&SaveConfig{SaveLayout: SaveLayoutJob, SaveMaxAge: "7d"}).Validate(), IsNil)
+}
+
+func (s *SuiteSave) listFiles(c *C, dir string) []string {
+	files, err := ioutil.ReadDir(dir)
+	c.Assert(err, IsNil)
+
+	var names []string
+	for _, f := range files {
+		names = append(names, f.Name())
+	}
+
+	return names
+}
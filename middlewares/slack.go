@@ -1,10 +1,16 @@
 package middlewares
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"net/http"
 	"net/url"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/vigasin/ofelia/core"
 )
@@ -13,12 +19,58 @@ var (
 	slackUsername   = "Ofelia"
 	slackAvatarURL  = "https://raw.githubusercontent.com/vigasin/ofelia/master/static/avatar.png"
 	slackPayloadVar = "payload"
+	slackAPIURL     = "https://slack.com/api/"
+	slackTimeout    = 10 * time.Second
+	slackOutputSize = 3000
 )
 
 // SlackConfig configuration for the Slack middleware
 type SlackConfig struct {
-	SlackWebhook     string `gcfg:"slack-webhook" mapstructure:"slack-webhook"`
-	SlackOnlyOnError bool   `gcfg:"slack-only-on-error" mapstructure:"slack-only-on-error"`
+	SlackWebhook     string   `gcfg:"slack-webhook" mapstructure:"slack-webhook" json:"-"`
+	SlackToken       string   `gcfg:"slack-token" mapstructure:"slack-token" json:"-"`
+	SlackChannel     string   `gcfg:"slack-channel" mapstructure:"slack-channel"`
+	SlackUsername    string   `gcfg:"slack-username" mapstructure:"slack-username"`
+	SlackIconURL     string   `gcfg:"slack-icon-url" mapstructure:"slack-icon-url"`
+	SlackIconEmoji   string   `gcfg:"slack-icon-emoji" mapstructure:"slack-icon-emoji"`
+	SlackMentions    []string `gcfg:"slack-mentions" mapstructure:"slack-mentions"`
+	SlackOnlyOnError *bool    `gcfg:"slack-only-on-error" mapstructure:"slack-only-on-error"`
+}
+
+// Inherit fills the empty fields of a job configuration with the global one,
+// so a job only needs to set e.g. its own channel. An empty configuration is
+// left untouched, the global middleware is used instead.
+func (c *SlackConfig) Inherit(g *SlackConfig) {
+	if IsEmpty(c) {
+		return
+	}
+
+	inherit := func(v *string, global string) {
+		if *v == "" {
+			*v = global
+		}
+	}
+
+	// a job posting to its own webhook doesn't use the bot of the global one
+	if c.SlackWebhook == "" {
+		inherit(&c.SlackToken, g.SlackToken)
+		inherit(&c.SlackChannel, g.SlackChannel)
+	}
+
+	if c.SlackToken == "" {
+		inherit(&c.SlackWebhook, g.SlackWebhook)
+	}
+
+	inherit(&c.SlackUsername, g.SlackUsername)
+	inherit(&c.SlackIconURL, g.SlackIconURL)
+	inherit(&c.SlackIconEmoji, g.SlackIconEmoji)
+
+	if len(c.SlackMentions) == 0 {
+		c.SlackMentions = g.SlackMentions
+	}
+
+	if c.SlackOnlyOnError == nil {
+		c.SlackOnlyOnError = g.SlackOnlyOnError
+	}
 }
 
 // NewSlack returns a Slack middleware if the given configuration is not empty
@@ -31,7 +83,10 @@ func NewSlack(c *SlackConfig) core.Middleware {
 	return m
 }
 
-// Slack middleware calls to a Slack input-hook after every execution of a job
+// Slack middleware calls to a Slack input-hook after every execution of a job,
+// or with a bot token posts a message per job and replies to it in a thread
+// after every execution. The threads are kept with the state of the
+// notification policy, saved to its notify-state-file if any.
 type Slack struct {
 	SlackConfig
 }
@@ -47,8 +102,14 @@ func (m *Slack) Run(ctx *core.Context) error {
 	err := ctx.Next()
 	ctx.Stop(err)
 
-	if ctx.Execution.Failed || !m.SlackOnlyOnError {
-		m.pushMessage(ctx)
+	if shouldNotify(ctx, isTrue(m.SlackOnlyOnError)) {
+		if m.SlackToken != "" {
+			if err := m.postMessage(ctx); err != nil {
+				ctx.Logger.Errorf("Slack error posting to %q: %s", m.SlackChannel, err)
+			}
+		} else {
+			m.pushMessage(ctx)
+		}
 	}
 
 	return err
@@ -67,10 +128,171 @@ func (m *Slack) pushMessage(ctx *core.Context) {
 	}
 }
 
+// postMessage posts the message with the Web API, in the thread of the job if
+// any, uploading the output of the failed executions
+func (m *Slack) postMessage(ctx *core.Context) error {
+	if m.SlackChannel == "" {
+		return errors.New("slack-channel is required with slack-token")
+	}
+
+	store, err := jobStore(ctx)
+	if err != nil {
+		return err
+	}
+
+	msg := m.buildMessage(ctx)
+	msg.Channel = m.SlackChannel
+	msg.ThreadTS = m.thread(store, jobKey(ctx.Job))
+
+	var res slackResponse
+	if err := m.call("chat.postMessage", msg, &res); err != nil {
+		return err
+	}
+
+	thread := msg.ThreadTS
+	if thread == "" {
+		thread = res.TS
+		if err := m.setThread(store, jobKey(ctx.Job), thread); err != nil {
+			ctx.Logger.Errorf("Slack error saving the thread of %q: %s", m.SlackChannel, err)
+		}
+	}
+
+	if !ctx.Execution.Failed {
+		return nil
+	}
+
+	base := fmt.Sprintf("%s_%s", ctx.Job.GetName(), ctx.Execution.ID)
+	for _, f := range []struct {
+		name   string
+		output []byte
+	}{
+		{base + ".stdout.log", ctx.Execution.OutputStream.Bytes()},
+		{base + ".stderr.log", ctx.Execution.ErrorStream.Bytes()},
+	} {
+		if len(f.output) == 0 {
+			continue
+		}
+
+		if err := m.upload(res.Channel, thread, f.name, tail(f.output, slackOutputSize)); err != nil {
+			return fmt.Errorf("error uploading %s: %s", f.name, err)
+		}
+	}
+
+	return nil
+}
+
+// thread returns the thread of the job in the channel, if any
+func (m *Slack) thread(store *notifyStore, job string) string {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	return store.get(job).SlackThreads[m.SlackChannel]
+}
+
+func (m *Slack) setThread(store *notifyStore, job, thread string) error {
+	store.mu.Lock()
+	defer store.mu.Unlock()
+
+	s := store.get(job)
+	if s.SlackThreads == nil {
+		s.SlackThreads = make(map[string]string)
+	}
+
+	s.SlackThreads[m.SlackChannel] = thread
+	return store.save()
+}
+
+// upload sends a snippet to the thread, with the external upload flow
+func (m *Slack) upload(channel, thread, name, content string) error {
+	var res slackResponse
+	err := m.call("files.getUploadURLExternal", url.Values{
+		"filename": {name},
+		"length":   {strconv.Itoa(len(content))},
+	}, &res)
+	if err != nil {
+		return err
+	}
+
+	resp, err := m.client().Post(res.UploadURL, "text/plain", strings.NewReader(content))
+	if err != nil {
+		return err
+	}
+
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+
+	files, _ := json.Marshal([]map[string]string{{"id": res.FileID, "title": name}})
+	return m.call("files.completeUploadExternal", url.Values{
+		"files":      {string(files)},
+		"channel_id": {channel},
+		"thread_ts":  {thread},
+	}, &slackResponse{})
+}
+
+// call calls a method of the Web API, with a form or a JSON body
+func (m *Slack) call(method string, body interface{}, res *slackResponse) error {
+	var r io.Reader
+	contentType := "application/json; charset=utf-8"
+	if values, ok := body.(url.Values); ok {
+		r = strings.NewReader(values.Encode())
+		contentType = "application/x-www-form-urlencoded"
+	} else {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+
+		r = bytes.NewReader(b)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, slackAPIURL+method, r)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("Authorization", "Bearer "+m.SlackToken)
+
+	resp, err := m.client().Do(req)
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status code calling %s: %d", method, resp.StatusCode)
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(res); err != nil {
+		return err
+	}
+
+	if !res.OK {
+		return fmt.Errorf("error calling %s: %s", method, res.Error)
+	}
+
+	return nil
+}
+
+func (m *Slack) client() *http.Client {
+	return &http.Client{Timeout: slackTimeout}
+}
+
 func (m *Slack) buildMessage(ctx *core.Context) *slackMessage {
 	msg := &slackMessage{
-		Username: slackUsername,
-		IconURL:  slackAvatarURL,
+		Username:  slackUsername,
+		IconURL:   slackAvatarURL,
+		IconEmoji: m.SlackIconEmoji,
+	}
+
+	if m.SlackUsername != "" {
+		msg.Username = m.SlackUsername
+	}
+
+	if m.SlackIconURL != "" || m.SlackIconEmoji != "" {
+		msg.IconURL = m.SlackIconURL
 	}
 
 	msg.Text = fmt.Sprintf(
@@ -79,11 +301,20 @@ func (m *Slack) buildMessage(ctx *core.Context) *slackMessage {
 	)
 
 	if ctx.Execution.Failed {
+		if mentions := m.mentions(); mentions != "" {
+			msg.Text = mentions + " " + msg.Text
+		}
+
 		msg.Attachments = append(msg.Attachments, slackAttachment{
 			Title: "Execution failed",
 			Text:  ctx.Execution.Error.Error(),
 			Color: "#F35A00",
 		})
+	} else if notifyStatus(ctx) == "recovered" {
+		msg.Attachments = append(msg.Attachments, slackAttachment{
+			Title: "Execution recovered",
+			Color: "#7CD197",
+		})
 	} else if ctx.Execution.Skipped {
 		msg.Attachments = append(msg.Attachments, slackAttachment{
 			Title: "Execution skipped",
@@ -99,11 +330,34 @@ func (m *Slack) buildMessage(ctx *core.Context) *slackMessage {
 	return msg
 }
 
+// mentions formats the users and groups mentioned on failure: user ids as
+// "U123", user groups as "S123", and "here", "channel" or "everyone"
+func (m *Slack) mentions() string {
+	var mentions []string
+	for _, id := range m.SlackMentions {
+		switch {
+		case strings.HasPrefix(id, "<"):
+			mentions = append(mentions, id)
+		case id == "here" || id == "channel" || id == "everyone":
+			mentions = append(mentions, "<!"+id+">")
+		case strings.HasPrefix(id, "S"):
+			mentions = append(mentions, "<!subteam^"+id+">")
+		default:
+			mentions = append(mentions, "<@"+strings.TrimPrefix(id, "@")+">")
+		}
+	}
+
+	return strings.Join(mentions, " ")
+}
+
 type slackMessage struct {
+	Channel     string            `json:"channel,omitempty"`
+	ThreadTS    string            `json:"thread_ts,omitempty"`
 	Text        string            `json:"text"`
 	Username    string            `json:"username"`
 	Attachments []slackAttachment `json:"attachments"`
-	IconURL     string            `json:"icon_url"`
+	IconURL     string            `json:"icon_url,omitempty"`
+	IconEmoji   string            `json:"icon_emoji,omitempty"`
 }
 
 type slackAttachment struct {
@@ -111,3 +365,12 @@ type slackAttachment struct {
 	Title string `json:"title,omitempty"`
 	Text  string `json:"text"`
 }
+
+type slackResponse struct {
+	OK        bool   `json:"ok"`
+	Error     string `json:"error"`
+	Channel   string `json:"channel"`
+	TS        string `json:"ts"`
+	UploadURL string `json:"upload_url"`
+	FileID    string `json:"file_id"`
+}
@@ -3,9 +3,14 @@ package middlewares
 import (
 	"encoding/json"
 	"errors"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
 
+	"github.com/vigasin/ofelia/core"
 	. "gopkg.in/check.v1"
 )
 
@@ -15,6 +20,11 @@ type SuiteSlack struct {
 
 var _ = Suite(&SuiteSlack{})
 
+func (s *SuiteSlack) SetUpTest(c *C) {
+	s.BaseSuite.SetUpTest(c)
+	notifyStores = make(map[string]*notifyStore)
+}
+
 func (s *SuiteSlack) TestNewSlackEmpty(c *C) {
 	c.Assert(NewSlack(&SlackConfig{}), IsNil)
 }
@@ -61,6 +71,150 @@ func (s *SuiteSlack) TestRunSuccessOnError(c *C) {
 	s.ctx.Start()
 	s.ctx.Stop(nil)
 
-	m := NewSlack(&SlackConfig{SlackWebhook: ts.URL, SlackOnlyOnError: true})
+	m := NewSlack(&SlackConfig{SlackWebhook: ts.URL, SlackOnlyOnError: newBool(true)})
+	c.Assert(m.Run(s.ctx), IsNil)
+}
+
+func (s *SuiteSlack) TestRunToken(c *C) {
+	var posts []slackMessage
+	var uploads []string
+	var completed []string
+
+	var ts *httptest.Server
+	ts = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/upload" {
+			c.Check(r.Header.Get("Authorization"), Equals, "Bearer foo")
+		}
+
+		switch r.URL.Path {
+		case "/chat.postMessage":
+			var m slackMessage
+			c.Check(json.NewDecoder(r.Body).Decode(&m), IsNil)
+			posts = append(posts, m)
+			w.Write([]byte(`{"ok": true, "channel": "C1", "ts": "1.1"}`))
+		case "/files.getUploadURLExternal":
+			w.Write([]byte(`{"ok": true, "upload_url": "` + ts.URL + `/upload", "file_id": "F1"}`))
+		case "/upload":
+			b, _ := ioutil.ReadAll(r.Body)
+			uploads = append(uploads, string(b))
+		case "/files.completeUploadExternal":
+			c.Check(r.FormValue("channel_id"), Equals, "C1")
+			c.Check(r.FormValue("thread_ts"), Equals, "1.1")
+			completed = append(completed, r.FormValue("files"))
+			w.Write([]byte(`{"ok": true}`))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+
+	defer ts.Close()
+	slackAPIURL = ts.URL + "/"
+
+	m := NewSlack(&SlackConfig{
+		SlackToken:     "foo",
+		SlackChannel:   "#bar",
+		SlackUsername:  "baz",
+		SlackIconEmoji: ":clock1:",
+		SlackMentions:  []string{"U1", "S2", "here"},
+	})
+
+	s.ctx.Start()
+	s.ctx.Stop(nil)
+	c.Assert(m.Run(s.ctx), IsNil)
+
+	s.ctx.Execution = core.NewExecution()
+	s.ctx.Start()
+	s.ctx.Execution.OutputStream.Write([]byte("qux"))
+	s.ctx.Stop(errors.New("foo"))
 	c.Assert(m.Run(s.ctx), IsNil)
+
+	c.Assert(posts, HasLen, 2)
+	c.Assert(posts[0].Channel, Equals, "#bar")
+	c.Assert(posts[0].ThreadTS, Equals, "")
+	c.Assert(posts[0].Username, Equals, "baz")
+	c.Assert(posts[0].IconEmoji, Equals, ":clock1:")
+	c.Assert(posts[0].IconURL, Equals, "")
+	c.Assert(strings.HasPrefix(posts[0].Text, "<"), Equals, false)
+
+	c.Assert(posts[1].ThreadTS, Equals, "1.1")
+	c.Assert(strings.HasPrefix(posts[1].Text, "<@U1> <!subteam^S2> <!here> Job"), Equals, true)
+
+	c.Assert(uploads, DeepEquals, []string{"qux"})
+	c.Assert(completed, HasLen, 1)
+}
+
+func (s *SuiteSlack) TestRunTokenThreadSaved(c *C) {
+	var threads []string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var m slackMessage
+		c.Check(json.NewDecoder(r.Body).Decode(&m), IsNil)
+		threads = append(threads, m.ThreadTS)
+		w.Write([]byte(`{"ok": true, "channel": "C1", "ts": "1.1"}`))
+	}))
+
+	defer ts.Close()
+	slackAPIURL = ts.URL + "/"
+
+	dir, err := ioutil.TempDir("", "slack")
+	c.Assert(err, IsNil)
+	defer os.RemoveAll(dir)
+
+	s.job.Name = "foo"
+	s.job.Use(NewNotify(&NotifyConfig{NotifyStateFile: filepath.Join(dir, "state.json")}))
+
+	s.ctx.Start()
+	s.ctx.Stop(nil)
+	c.Assert(NewSlack(&SlackConfig{SlackToken: "foo", SlackChannel: "bar"}).Run(s.ctx), IsNil)
+
+	// after a restart the executions are still replied in the thread
+	notifyStores = make(map[string]*notifyStore)
+	s.ctx.Execution = core.NewExecution()
+	s.ctx.Start()
+	s.ctx.Stop(nil)
+	c.Assert(NewSlack(&SlackConfig{SlackToken: "foo", SlackChannel: "bar"}).Run(s.ctx), IsNil)
+
+	c.Assert(threads, DeepEquals, []string{"", "1.1"})
+}
+
+func (s *SuiteSlack) TestRunTokenError(c *C) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"ok": false, "error": "channel_not_found"}`))
+	}))
+
+	defer ts.Close()
+	slackAPIURL = ts.URL + "/"
+
+	s.ctx.Start()
+	s.ctx.Stop(nil)
+
+	m := &Slack{SlackConfig{SlackToken: "foo"}}
+	c.Assert(m.postMessage(s.ctx), ErrorMatches, "slack-channel is required with slack-token")
+
+	m.SlackChannel = "bar"
+	c.Assert(m.postMessage(s.ctx), ErrorMatches, "error calling chat.postMessage: channel_not_found")
+}
+
+func (s *SuiteSlack) TestInherit(c *C) {
+	global := &SlackConfig{SlackToken: "foo", SlackChannel: "bar", SlackMentions: []string{"U1"}}
+
+	job := &SlackConfig{}
+	job.Inherit(global)
+	c.Assert(IsEmpty(job), Equals, true)
+
+	job = &SlackConfig{SlackChannel: "qux", SlackUsername: "baz"}
+	job.Inherit(global)
+	c.Assert(job, DeepEquals, &SlackConfig{
+		SlackToken:    "foo",
+		SlackChannel:  "qux",
+		SlackUsername: "baz",
+		SlackMentions: []string{"U1"},
+	})
+
+	job = &SlackConfig{SlackChannel: "qux", SlackOnlyOnError: newBool(false)}
+	job.Inherit(&SlackConfig{SlackToken: "foo", SlackOnlyOnError: newBool(true)})
+	c.Assert(*job.SlackOnlyOnError, Equals, false)
+
+	job = &SlackConfig{SlackWebhook: "http://foo"}
+	job.Inherit(global)
+	c.Assert(job.SlackToken, Equals, "")
 }
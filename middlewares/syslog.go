@@ -0,0 +1,307 @@
+package middlewares
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/vigasin/ofelia/core"
+)
+
+var (
+	syslogTimeout    = 10 * time.Second
+	syslogOutputSize = 2000
+)
+
+// syslogSDID is the default SD-ID of the structured data, ofelia has no
+// private enterprise number so it uses the one reserved for documentation
+const syslogSDID = "ofelia@32473"
+
+// syslogSDIDPattern matches the SD-IDs of RFC5424 defined by an enterprise, a
+// name and a private enterprise number, optionally followed by sub-numbers
+var syslogSDIDPattern = regexp.MustCompile(`^[!#-<>-?A-\\^-~]+@[0-9]+(\.[0-9]+)*$`)
+
+// syslogFacilities are the codes of the facilities of RFC5424
+var syslogFacilities = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5,
+	"lpr": 6, "news": 7, "uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19,
+	"local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+// SyslogConfig configuration for the Syslog middleware
+type SyslogConfig struct {
+	SyslogAddress  string `gcfg:"syslog-address" mapstructure:"syslog-address"`
+	SyslogFacility string `gcfg:"syslog-facility" mapstructure:"syslog-facility"`
+	SyslogTag      string `gcfg:"syslog-tag" mapstructure:"syslog-tag"`
+	SyslogSDID     string `gcfg:"syslog-sd-id" mapstructure:"syslog-sd-id"`
+}
+
+// Inherit fills the empty fields of a job configuration with the global one,
+// so a job only needs to set e.g. its own facility
+func (c *SyslogConfig) Inherit(g *SyslogConfig) {
+	if IsEmpty(c) {
+		return
+	}
+
+	inherit := func(v *string, global string) {
+		if *v == "" {
+			*v = global
+		}
+	}
+
+	inherit(&c.SyslogAddress, g.SyslogAddress)
+	inherit(&c.SyslogFacility, g.SyslogFacility)
+	inherit(&c.SyslogTag, g.SyslogTag)
+	inherit(&c.SyslogSDID, g.SyslogSDID)
+}
+
+// Validate returns an error if the address is missing or invalid, the
+// facility unknown or the SD-ID invalid
+func (c *SyslogConfig) Validate() error {
+	if c.SyslogAddress == "" {
+		return errors.New("syslog-address is required")
+	}
+
+	if _, _, err := parseAddress(c.SyslogAddress, "udp"); err != nil {
+		return fmt.Errorf("invalid syslog-address %q: %s", c.SyslogAddress, err)
+	}
+
+	if _, err := c.facility(); err != nil {
+		return err
+	}
+
+	if _, err := c.sdID(); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// sdID returns the SD-ID of the structured data, name@number with at most 32
+// characters
+func (c *SyslogConfig) sdID() (string, error) {
+	if c.SyslogSDID == "" {
+		return syslogSDID, nil
+	}
+
+	if len(c.SyslogSDID) > 32 || !syslogSDIDPattern.MatchString(c.SyslogSDID) {
+		return "", fmt.Errorf("invalid syslog-sd-id %q: expected name@number, at most 32 characters", c.SyslogSDID)
+	}
+
+	return c.SyslogSDID, nil
+}
+
+func (c *SyslogConfig) facility() (int, error) {
+	if c.SyslogFacility == "" {
+		return syslogFacilities["daemon"], nil
+	}
+
+	facility, ok := syslogFacilities[strings.ToLower(c.SyslogFacility)]
+	if !ok {
+		return 0, fmt.Errorf("invalid syslog-facility %q", c.SyslogFacility)
+	}
+
+	return facility, nil
+}
+
+// NewSyslog returns a Syslog middleware if the given configuration is not empty
+func NewSyslog(c *SyslogConfig) core.Middleware {
+	var m core.Middleware
+	if !IsEmpty(c) {
+		m = &Syslog{*c}
+	}
+
+	return m
+}
+
+// Syslog middleware sends a RFC5424 message per execution to a syslog server,
+// over UDP, TCP or a unix socket, with the execution as structured data
+type Syslog struct {
+	SyslogConfig
+}
+
+// ContinueOnStop return allways true, we want always report the final status
+func (m *Syslog) ContinueOnStop() bool {
+	return true
+}
+
+// Run sends the message after the execution
+func (m *Syslog) Run(ctx *core.Context) error {
+	err := ctx.Next()
+	ctx.Stop(err)
+
+	if err := m.send(ctx); err != nil {
+		ctx.Logger.Errorf("Syslog error: %s", err)
+	}
+
+	return err
+}
+
+func (m *Syslog) send(ctx *core.Context) error {
+	msg, err := m.message(ctx, time.Now())
+	if err != nil {
+		return err
+	}
+
+	network, addr, err := parseAddress(m.SyslogAddress, "udp")
+	if err != nil {
+		return fmt.Errorf("invalid syslog-address %q: %s", m.SyslogAddress, err)
+	}
+
+	conn, network, err := m.dial(network, addr)
+	if err != nil {
+		return err
+	}
+
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(syslogTimeout))
+
+	// the streams are framed with the octet counting of RFC6587
+	if network == "tcp" || network == "unix" {
+		msg = append([]byte(fmt.Sprintf("%d ", len(msg))), msg...)
+	}
+
+	_, err = conn.Write(msg)
+	return err
+}
+
+// dial connects to the server, returning the network used: a unix socket is
+// a datagram one, usually, or a stream one
+func (m *Syslog) dial(network, addr string) (net.Conn, string, error) {
+	if network == "unix" {
+		if conn, err := net.DialTimeout("unixgram", addr, syslogTimeout); err == nil {
+			return conn, "unixgram", nil
+		}
+	}
+
+	conn, err := net.DialTimeout(network, addr, syslogTimeout)
+	return conn, network, err
+}
+
+// message returns the RFC5424 message of the execution
+func (m *Syslog) message(ctx *core.Context, now time.Time) ([]byte, error) {
+	facility, err := m.facility()
+	if err != nil {
+		return nil, err
+	}
+
+	sdID, err := m.sdID()
+	if err != nil {
+		return nil, err
+	}
+
+	tag := m.SyslogTag
+	if tag == "" {
+		tag = "ofelia"
+	}
+
+	e := ctx.Execution
+	hostname, _ := os.Hostname()
+
+	buf := bytes.NewBuffer(nil)
+	fmt.Fprintf(buf, "<%d>1 %s %s %s %d execution ",
+		facility*8+severity(e),
+		now.Format("2006-01-02T15:04:05.000000Z07:00"),
+		syslogHeader(hostname, 255), syslogHeader(tag, 48), os.Getpid(),
+	)
+
+	fmt.Fprintf(buf, `[%s job="%s" execution="%s" status="%s" duration="%s" exit_code="%d"`,
+		sdID, syslogParam(ctx.Job.GetName()), e.ID, e.Status(), e.Duration, e.ExitCode,
+	)
+
+	if e.Error != nil {
+		fmt.Fprintf(buf, ` error="%s"`, syslogParam(e.Error.Error()))
+	}
+
+	buf.WriteString("] ")
+	buf.WriteString(executionSummary(ctx))
+
+	if output := e.OutputStream.Bytes(); len(output) > 0 {
+		fmt.Fprintf(buf, "\nstdout:\n%s", tail(output, syslogOutputSize))
+	}
+
+	if stderr := e.ErrorStream.Bytes(); len(stderr) > 0 {
+		fmt.Fprintf(buf, "\nstderr:\n%s", tail(stderr, syslogOutputSize))
+	}
+
+	return buf.Bytes(), nil
+}
+
+// syslogHeader returns a header field, printable ASCII without spaces
+func syslogHeader(s string, size int) string {
+	s = strings.Map(func(r rune) rune {
+		if r <= ' ' || r > '~' {
+			return '_'
+		}
+
+		return r
+	}, s)
+
+	if s == "" {
+		return "-"
+	}
+
+	if len(s) > size {
+		s = s[:size]
+	}
+
+	return s
+}
+
+// syslogParam escapes a value of the structured data
+func syslogParam(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`).Replace(s)
+}
+
+// severity returns the syslog severity of the execution: error, warning or
+// notice
+func severity(e *core.Execution) int {
+	switch {
+	case e.Failed:
+		return 3
+	case e.Skipped:
+		return 4
+	default:
+		return 5
+	}
+}
+
+// executionSummary returns a line describing the result of the execution
+func executionSummary(ctx *core.Context) string {
+	e := ctx.Execution
+	msg := fmt.Sprintf("Job %q %s in %s", ctx.Job.GetName(), e.Status(), e.Duration)
+	if e.Error != nil {
+		msg += ": " + e.Error.Error()
+	}
+
+	return msg
+}
+
+// parseAddress parses an address like udp://host:port, tcp://host:port or
+// unix:///path, the network is the default one if missing
+func parseAddress(s, network string) (string, string, error) {
+	if !strings.Contains(s, "://") {
+		return network, s, nil
+	}
+
+	u, err := url.Parse(s)
+	if err != nil {
+		return "", "", err
+	}
+
+	switch u.Scheme {
+	case "udp", "tcp":
+		return u.Scheme, u.Host, nil
+	case "unix":
+		return u.Scheme, u.Path, nil
+	default:
+		return "", "", fmt.Errorf("unsupported scheme %q", u.Scheme)
+	}
+}
@@ -0,0 +1,158 @@
+package middlewares
+
+import (
+	"bufio"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+type SuiteSyslog struct {
+	BaseSuite
+}
+
+var _ = Suite(&SuiteSyslog{})
+
+func (s *SuiteSyslog) SetUpTest(c *C) {
+	s.BaseSuite.SetUpTest(c)
+	s.job.Name = "foo"
+}
+
+func (s *SuiteSyslog) TestNewSyslogEmpty(c *C) {
+	c.Assert(NewSyslog(&SyslogConfig{}), IsNil)
+}
+
+func (s *SuiteSyslog) TestRunUDP(c *C) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	c.Assert(err, IsNil)
+	defer conn.Close()
+
+	s.ctx.Start()
+	s.ctx.Execution.OutputStream.Write([]byte("bar\n"))
+	s.ctx.Stop(errors.New(`"qux"]`))
+	s.ctx.Execution.ExitCode = 2
+
+	m := NewSyslog(&SyslogConfig{
+		SyslogAddress:  conn.LocalAddr().String(),
+		SyslogFacility: "local3",
+		SyslogTag:      "backups",
+		SyslogSDID:     "backups@12345.1",
+	})
+
+	c.Assert(m.Run(s.ctx), IsNil)
+
+	msg := readPacket(c, conn)
+	c.Assert(msg, Matches, `<155>1 \d{4}-\d\d-\d\dT\d\d:\d\d:\d\d\.\d{6}\S+ \S+ backups \d+ execution `+
+		`\[backups@12345\.1 job="foo" execution="`+s.ctx.Execution.ID+`" status="failed" duration="\S+" exit_code="2" error="\\"qux\\"\\]"\] `+
+		`Job "foo" failed in \S+: "qux"\]\nstdout:\nbar\n`)
+}
+
+func (s *SuiteSyslog) TestRunTCP(c *C) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	c.Assert(err, IsNil)
+	defer l.Close()
+
+	s.ctx.Start()
+	s.ctx.Stop(nil)
+
+	m := NewSyslog(&SyslogConfig{SyslogAddress: "tcp://" + l.Addr().String()})
+	c.Assert(m.Run(s.ctx), IsNil)
+
+	msg := readFrame(c, l)
+	c.Assert(msg, Matches, `<29>1 .* ofelia \d+ execution \[ofelia@32473 job="foo" .* status="successful" .*\] Job "foo" successful in .*`)
+}
+
+func (s *SuiteSyslog) TestRunUnix(c *C) {
+	dir, err := ioutil.TempDir("", "syslog")
+	c.Assert(err, IsNil)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "log")
+	conn, err := net.ListenPacket("unixgram", path)
+	c.Assert(err, IsNil)
+	defer conn.Close()
+
+	s.ctx.Start()
+	s.ctx.Stop(nil)
+
+	m := NewSyslog(&SyslogConfig{SyslogAddress: "unix://" + path, SyslogFacility: "cron"})
+	c.Assert(m.Run(s.ctx), IsNil)
+	c.Assert(readPacket(c, conn), Matches, `<77>1 .*`)
+}
+
+func (s *SuiteSyslog) TestInvalidConfig(c *C) {
+	s.ctx.Start()
+	s.ctx.Stop(nil)
+
+	m := &Syslog{SyslogConfig{SyslogAddress: "127.0.0.1:514", SyslogFacility: "foo"}}
+	c.Assert(m.send(s.ctx), ErrorMatches, `invalid syslog-facility "foo"`)
+
+	m = &Syslog{SyslogConfig{SyslogAddress: "http://127.0.0.1:514"}}
+	c.Assert(m.send(s.ctx), ErrorMatches, `invalid syslog-address .*: unsupported scheme "http"`)
+
+	m = &Syslog{SyslogConfig{SyslogAddress: "127.0.0.1:514", SyslogSDID: "ofelia"}}
+	c.Assert(m.send(s.ctx), ErrorMatches, `invalid syslog-sd-id "ofelia": .*`)
+}
+
+func (s *SuiteSyslog) TestValidate(c *C) {
+	c.Assert((&SyslogConfig{SyslogFacility: "cron"}).Validate(), ErrorMatches, "syslog-address is required")
+	c.Assert((&SyslogConfig{SyslogAddress: "http://foo"}).Validate(), ErrorMatches, `invalid syslog-address .*`)
+	c.Assert((&SyslogConfig{SyslogAddress: "foo:514", SyslogFacility: "foo"}).Validate(), ErrorMatches, `invalid syslog-facility "foo"`)
+	c.Assert((&SyslogConfig{SyslogAddress: "unix:///dev/log", SyslogFacility: "LOCAL0"}).Validate(), IsNil)
+	c.Assert((&SyslogConfig{SyslogAddress: "foo:514", SyslogSDID: "backups@12345.1"}).Validate(), IsNil)
+
+	for _, id := range []string{"ofelia", "ofelia@", "@12345", "ofelia@foo", "of elia@12345", `of"elia@12345`, "of=elia@12345", "ofelia@example@12345", "ofelia@123456789012345678901234567"} {
+		c.Assert((&SyslogConfig{SyslogAddress: "foo:514", SyslogSDID: id}).Validate(), ErrorMatches, `invalid syslog-sd-id .*`, Commentf("sd-id %q", id))
+	}
+}
+
+func (s *SuiteSyslog) TestInherit(c *C) {
+	global := &SyslogConfig{SyslogAddress: "udp://localhost:514", SyslogFacility: "local0"}
+
+	job := &SyslogConfig{}
+	job.Inherit(global)
+	c.Assert(IsEmpty(job), Equals, true)
+
+	global.SyslogSDID = "backups@12345"
+	job = &SyslogConfig{SyslogTag: "foo"}
+	job.Inherit(global)
+	c.Assert(job, DeepEquals, &SyslogConfig{SyslogAddress: "udp://localhost:514", SyslogFacility: "local0", SyslogTag: "foo", SyslogSDID: "backups@12345"})
+}
+
+// readPacket returns the next datagram received
+func readPacket(c *C, conn net.PacketConn) string {
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+
+	b := make([]byte, 65536)
+	n, _, err := conn.ReadFrom(b)
+	c.Assert(err, IsNil)
+	return string(b[:n])
+}
+
+// readFrame returns the first message, framed with octet counting, of the
+// next connection
+func readFrame(c *C, l net.Listener) string {
+	conn, err := l.Accept()
+	c.Assert(err, IsNil)
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+	size, err := r.ReadString(' ')
+	c.Assert(err, IsNil)
+
+	n, err := strconv.Atoi(strings.TrimSpace(size))
+	c.Assert(err, IsNil)
+
+	b := make([]byte, n)
+	_, err = io.ReadFull(r, b)
+	c.Assert(err, IsNil)
+	return string(b)
+}
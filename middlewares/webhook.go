@@ -0,0 +1,362 @@
+package middlewares
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/vigasin/ofelia/core"
+)
+
+var (
+	webhookTimeout    = 10 * time.Second
+	webhookRetryDelay = time.Second
+	webhookOutputSize = 1000
+)
+
+// WebhookConfig configuration for the Webhook middleware
+type WebhookConfig struct {
+	WebhookURL         string   `gcfg:"webhook-url" mapstructure:"webhook-url" json:"-"`
+	WebhookMethod      string   `gcfg:"webhook-method" mapstructure:"webhook-method"`
+	WebhookHeaders     []string `gcfg:"webhook-headers" mapstructure:"webhook-headers" json:"-"`
+	WebhookPreset      string   `gcfg:"webhook-preset" mapstructure:"webhook-preset"`
+	WebhookTemplate    string   `gcfg:"webhook-template" mapstructure:"webhook-template"`
+	WebhookOnlyOnError *bool    `gcfg:"webhook-only-on-error" mapstructure:"webhook-only-on-error"`
+	WebhookRetries     int      `gcfg:"webhook-retries" mapstructure:"webhook-retries"`
+	WebhookTimeout     string   `gcfg:"webhook-timeout" mapstructure:"webhook-timeout"`
+}
+
+// Inherit fills the empty fields of a job configuration with the global one,
+// so a job only needs to set e.g. its own template. An empty configuration is
+// left untouched, the global middleware is used instead.
+func (c *WebhookConfig) Inherit(g *WebhookConfig) {
+	if IsEmpty(c) {
+		return
+	}
+
+	inherit := func(v *string, global string) {
+		if *v == "" {
+			*v = global
+		}
+	}
+
+	inherit(&c.WebhookURL, g.WebhookURL)
+	inherit(&c.WebhookMethod, g.WebhookMethod)
+	inherit(&c.WebhookPreset, g.WebhookPreset)
+	inherit(&c.WebhookTemplate, g.WebhookTemplate)
+	inherit(&c.WebhookTimeout, g.WebhookTimeout)
+
+	if len(c.WebhookHeaders) == 0 {
+		c.WebhookHeaders = g.WebhookHeaders
+	}
+
+	if c.WebhookRetries == 0 {
+		c.WebhookRetries = g.WebhookRetries
+	}
+
+	if c.WebhookOnlyOnError == nil {
+		c.WebhookOnlyOnError = g.WebhookOnlyOnError
+	}
+}
+
+// Validate returns an error if the preset, method, headers, timeout or
+// template are invalid
+func (c *WebhookConfig) Validate() error {
+	m := &Webhook{*c}
+	if _, err := m.template(); err != nil {
+		return err
+	}
+
+	if _, err := m.client(); err != nil {
+		return err
+	}
+
+	if _, err := http.NewRequest(m.method(), "", nil); err != nil {
+		return fmt.Errorf("invalid webhook-method %q", c.WebhookMethod)
+	}
+
+	for _, h := range c.WebhookHeaders {
+		if _, _, err := parseHeader(h); err != nil {
+			return fmt.Errorf("invalid webhook-headers: %s", err)
+		}
+	}
+
+	return nil
+}
+
+// NewWebhook returns a Webhook middleware if the given configuration is not empty
+func NewWebhook(c *WebhookConfig) core.Middleware {
+	var m core.Middleware
+	if !IsEmpty(c) {
+		m = &Webhook{*c}
+	}
+
+	return m
+}
+
+// Webhook middleware calls an HTTP endpoint after every execution of a job,
+// with a body built from a template
+type Webhook struct {
+	WebhookConfig
+}
+
+// ContinueOnStop return allways true, we want alloways report the final status
+func (m *Webhook) ContinueOnStop() bool {
+	return true
+}
+
+// Run calls the webhook, its close stop the exection to collect the metrics
+func (m *Webhook) Run(ctx *core.Context) error {
+	err := ctx.Next()
+	ctx.Stop(err)
+
+	if shouldNotify(ctx, isTrue(m.WebhookOnlyOnError)) {
+		if err := m.call(ctx); err != nil {
+			ctx.Logger.Errorf("Webhook error calling %q: %s", m.WebhookURL, err)
+		}
+	}
+
+	return err
+}
+
+// call sends the request, retrying with an exponential backoff on failure
+func (m *Webhook) call(ctx *core.Context) error {
+	preset, err := m.preset()
+	if err != nil {
+		return err
+	}
+
+	body, err := m.body(ctx)
+	if err != nil {
+		return err
+	}
+
+	client, err := m.client()
+	if err != nil {
+		return err
+	}
+
+	delay := webhookRetryDelay
+	for attempt := 0; ; attempt++ {
+		err = m.send(client, preset, body)
+		if err == nil || attempt >= m.WebhookRetries {
+			return err
+		}
+
+		ctx.Logger.Warningf("Webhook error calling %q, retrying in %s: %s", m.WebhookURL, delay, err)
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+func (m *Webhook) send(client *http.Client, preset *webhookPreset, body []byte) error {
+	req, err := http.NewRequest(m.method(), m.WebhookURL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", preset.contentType)
+	for _, h := range m.WebhookHeaders {
+		name, value, err := parseHeader(h)
+		if err != nil {
+			return err
+		}
+
+		req.Header.Set(name, value)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+func (m *Webhook) method() string {
+	if m.WebhookMethod == "" {
+		return http.MethodPost
+	}
+
+	return strings.ToUpper(m.WebhookMethod)
+}
+
+// parseHeader parses a `Name: value` header
+func parseHeader(h string) (string, string, error) {
+	parts := strings.SplitN(h, ":", 2)
+	if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+		return "", "", fmt.Errorf("invalid header %q", h)
+	}
+
+	return strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1]), nil
+}
+
+func (m *Webhook) client() (*http.Client, error) {
+	timeout := webhookTimeout
+	if m.WebhookTimeout != "" {
+		var err error
+		if timeout, err = time.ParseDuration(m.WebhookTimeout); err != nil {
+			return nil, fmt.Errorf("invalid webhook-timeout %q: %s", m.WebhookTimeout, err)
+		}
+	}
+
+	return &http.Client{Timeout: timeout}, nil
+}
+
+func (m *Webhook) preset() (*webhookPreset, error) {
+	name := m.WebhookPreset
+	if name == "" {
+		name = "default"
+	}
+
+	p, ok := webhookPresets[name]
+	if !ok {
+		return nil, fmt.Errorf("invalid webhook-preset %q", m.WebhookPreset)
+	}
+
+	return p, nil
+}
+
+// template parses the configured template, or the one of the preset
+func (m *Webhook) template() (*template.Template, error) {
+	preset, err := m.preset()
+	if err != nil {
+		return nil, err
+	}
+
+	text := preset.template
+	if m.WebhookTemplate != "" {
+		text = m.WebhookTemplate
+	}
+
+	t, err := template.New("webhook").Funcs(webhookFuncs).Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("invalid webhook-template: %s", err)
+	}
+
+	return t, nil
+}
+
+func (m *Webhook) body(ctx *core.Context) ([]byte, error) {
+	t, err := m.template()
+	if err != nil {
+		return nil, err
+	}
+
+	buf := bytes.NewBuffer(nil)
+	if err := t.Execute(buf, newWebhookData(ctx)); err != nil {
+		return nil, fmt.Errorf("error executing webhook-template: %s", err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// webhookData is the data given to the templates, the output is truncated to
+// its last bytes
+type webhookData struct {
+	Job       core.Job
+	Execution *core.Execution
+	Status    string
+	Message   string
+	Error     string
+	Output    string
+	Stderr    string
+}
+
+func newWebhookData(ctx *core.Context) *webhookData {
+	d := &webhookData{
+		Job:       ctx.Job,
+		Execution: ctx.Execution,
+		Status:    notifyStatus(ctx),
+		Output:    tail(ctx.Execution.OutputStream.Bytes(), webhookOutputSize),
+		Stderr:    tail(ctx.Execution.ErrorStream.Bytes(), webhookOutputSize),
+	}
+
+	if ctx.Execution.Error != nil {
+		d.Error = ctx.Execution.Error.Error()
+	}
+
+	d.Message = fmt.Sprintf(
+		"Job %q %s in %s, command `%s`",
+		ctx.Job.GetName(), d.Status, ctx.Execution.Duration, ctx.Job.GetCommand(),
+	)
+
+	if d.Error != "" {
+		d.Message += ": " + d.Error
+	}
+
+	return d
+}
+
+// tail returns the last bytes of b, at most size
+func tail(b []byte, size int) string {
+	if len(b) <= size {
+		return string(b)
+	}
+
+	return "..." + string(b[len(b)-size:])
+}
+
+var webhookFuncs = template.FuncMap{
+	"json": func(v interface{}) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+	"color": func(e *core.Execution) string {
+		switch {
+		case e.Failed:
+			return "#F35A00"
+		case e.Skipped:
+			return "#FFA500"
+		default:
+			return "#7CD197"
+		}
+	},
+}
+
+type webhookPreset struct {
+	contentType string
+	template    string
+}
+
+var webhookPresets = map[string]*webhookPreset{
+	"default": {
+		contentType: "application/json",
+		template: `{"job": {{json .Job.GetName}}, "command": {{json .Job.GetCommand}}, ` +
+			`"status": {{json .Status}}, "execution": {{json .Execution.ID}}, ` +
+			`"duration": {{json .Execution.Duration.String}}, "exit_code": {{.Execution.ExitCode}}, ` +
+			`"error": {{json .Error}}, "output": {{json .Output}}, "stderr": {{json .Stderr}}}`,
+	},
+	"slack": {
+		contentType: "application/json",
+		template: `{"attachments": [{"color": {{json (color .Execution)}}, ` +
+			`"text": {{if .Output}}{{json (printf "%s\n` + "```%s```" + `" .Message .Output)}}{{else}}{{json .Message}}{{end}}}]}`,
+	},
+	"mattermost": {
+		contentType: "application/json",
+		template:    `{"text": {{if .Output}}{{json (printf "%s\n` + "```\\n%s\\n```" + `" .Message .Output)}}{{else}}{{json .Message}}{{end}}}`,
+	},
+	"discord": {
+		contentType: "application/json",
+		template:    `{"content": {{if .Output}}{{json (printf "%s\n` + "```\\n%s\\n```" + `" .Message .Output)}}{{else}}{{json .Message}}{{end}}}`,
+	},
+	"teams": {
+		contentType: "application/json",
+		template: `{"@type": "MessageCard", "@context": "https://schema.org/extensions", ` +
+			`"themeColor": {{json (color .Execution)}}, "title": {{json (printf "Job %s %s" .Job.GetName .Status)}}, ` +
+			`"text": {{if .Output}}{{json (printf "%s\n\n<pre>%s</pre>" .Message .Output)}}{{else}}{{json .Message}}{{end}}}`,
+	},
+	"ntfy": {
+		contentType: "text/plain",
+		template:    `{{.Message}}{{if .Output}}` + "\n\n" + `{{.Output}}{{end}}`,
+	},
+}
@@ -0,0 +1,180 @@
+package middlewares
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"time"
+
+	. "gopkg.in/check.v1"
+)
+
+type SuiteWebhook struct {
+	BaseSuite
+}
+
+var _ = Suite(&SuiteWebhook{})
+
+func (s *SuiteWebhook) SetUpTest(c *C) {
+	s.BaseSuite.SetUpTest(c)
+	webhookRetryDelay = time.Millisecond
+}
+
+func (s *SuiteWebhook) TestNewWebhookEmpty(c *C) {
+	c.Assert(NewWebhook(&WebhookConfig{}), IsNil)
+}
+
+func (s *SuiteWebhook) TestRunDefault(c *C) {
+	var called int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called++
+		c.Assert(r.Method, Equals, http.MethodPost)
+		c.Assert(r.Header.Get("Content-Type"), Equals, "application/json")
+		c.Assert(r.Header.Get("X-Token"), Equals, "foo")
+
+		var m map[string]interface{}
+		c.Assert(json.NewDecoder(r.Body).Decode(&m), IsNil)
+		c.Assert(m["status"], Equals, "failed")
+		c.Assert(m["error"], Equals, "foo")
+		c.Assert(m["output"], Equals, "bar")
+	}))
+
+	defer ts.Close()
+
+	s.ctx.Start()
+	s.ctx.Execution.OutputStream.Write([]byte("bar"))
+	s.ctx.Stop(errors.New("foo"))
+
+	m := NewWebhook(&WebhookConfig{WebhookURL: ts.URL, WebhookHeaders: []string{"X-Token: foo"}})
+	c.Assert(m.Run(s.ctx), IsNil)
+	c.Assert(called, Equals, 1)
+}
+
+func (s *SuiteWebhook) TestRunTemplate(c *C) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c.Assert(r.Method, Equals, http.MethodPut)
+
+		body, _ := ioutil.ReadAll(r.Body)
+		c.Assert(string(body), Equals, "successful 0")
+	}))
+
+	defer ts.Close()
+
+	s.ctx.Start()
+	s.ctx.Stop(nil)
+
+	m := NewWebhook(&WebhookConfig{
+		WebhookURL:      ts.URL,
+		WebhookMethod:   "put",
+		WebhookTemplate: "{{.Status}} {{.Execution.ExitCode}}",
+	})
+	c.Assert(m.Run(s.ctx), IsNil)
+}
+
+func (s *SuiteWebhook) TestRunOnlyOnError(c *C) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c.Assert(true, Equals, false)
+	}))
+
+	defer ts.Close()
+
+	s.ctx.Start()
+	s.ctx.Stop(nil)
+
+	m := NewWebhook(&WebhookConfig{WebhookURL: ts.URL, WebhookOnlyOnError: newBool(true)})
+	c.Assert(m.Run(s.ctx), IsNil)
+}
+
+func (s *SuiteWebhook) TestRunRetries(c *C) {
+	var called int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called++
+		if called < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+
+	defer ts.Close()
+
+	s.ctx.Start()
+	s.ctx.Stop(nil)
+
+	m := &Webhook{WebhookConfig{WebhookURL: ts.URL, WebhookRetries: 1}}
+	c.Assert(m.call(s.ctx), ErrorMatches, "unexpected status code: 503")
+	c.Assert(called, Equals, 2)
+
+	m.WebhookRetries = 2
+	c.Assert(m.call(s.ctx), IsNil)
+	c.Assert(called, Equals, 3)
+}
+
+func (s *SuiteWebhook) TestPresets(c *C) {
+	s.ctx.Start()
+	s.ctx.Execution.OutputStream.Write([]byte("foo \"bar\"\n"))
+	s.ctx.Stop(errors.New("qux"))
+
+	for name, p := range webhookPresets {
+		m := &Webhook{WebhookConfig{WebhookPreset: name}}
+		body, err := m.body(s.ctx)
+		c.Assert(err, IsNil)
+
+		if p.contentType == "application/json" {
+			var v map[string]interface{}
+			c.Assert(json.Unmarshal(body, &v), IsNil, Commentf("preset %s: %s", name, body))
+		}
+
+		c.Assert(strings.Contains(string(body), "qux"), Equals, true, Commentf("preset %s", name))
+	}
+}
+
+func (s *SuiteWebhook) TestInvalidConfig(c *C) {
+	m := &Webhook{WebhookConfig{WebhookPreset: "foo"}}
+	c.Assert(m.call(s.ctx), ErrorMatches, `invalid webhook-preset "foo"`)
+
+	m = &Webhook{WebhookConfig{WebhookTemplate: "{{"}}
+	c.Assert(m.call(s.ctx), ErrorMatches, "invalid webhook-template: .*")
+
+	m = &Webhook{WebhookConfig{WebhookTimeout: "foo"}}
+	c.Assert(m.call(s.ctx), ErrorMatches, `invalid webhook-timeout "foo": .*`)
+}
+
+func (s *SuiteWebhook) TestValidate(c *C) {
+	c.Assert((&WebhookConfig{WebhookURL: "http://foo", WebhookMethod: "put"}).Validate(), IsNil)
+
+	c.Assert((&WebhookConfig{WebhookPreset: "foo"}).Validate(), ErrorMatches, `invalid webhook-preset "foo"`)
+	c.Assert((&WebhookConfig{WebhookTemplate: "{{"}).Validate(), ErrorMatches, "invalid webhook-template: .*")
+	c.Assert((&WebhookConfig{WebhookTimeout: "foo"}).Validate(), ErrorMatches, `invalid webhook-timeout "foo": .*`)
+	c.Assert((&WebhookConfig{WebhookMethod: "foo bar"}).Validate(), ErrorMatches, `invalid webhook-method "foo bar"`)
+	c.Assert((&WebhookConfig{WebhookHeaders: []string{"foo"}}).Validate(), ErrorMatches, `invalid webhook-headers: invalid header "foo"`)
+}
+
+func (s *SuiteWebhook) TestInherit(c *C) {
+	global := &WebhookConfig{
+		WebhookURL:         "http://foo",
+		WebhookHeaders:     []string{"X-Foo: bar"},
+		WebhookRetries:     3,
+		WebhookOnlyOnError: newBool(true),
+	}
+
+	job := &WebhookConfig{}
+	job.Inherit(global)
+	c.Assert(IsEmpty(job), Equals, true)
+
+	job = &WebhookConfig{WebhookPreset: "slack", WebhookOnlyOnError: newBool(false)}
+	job.Inherit(global)
+	c.Assert(job, DeepEquals, &WebhookConfig{
+		WebhookURL:         "http://foo",
+		WebhookHeaders:     []string{"X-Foo: bar"},
+		WebhookPreset:      "slack",
+		WebhookRetries:     3,
+		WebhookOnlyOnError: newBool(false),
+	})
+}
+
+func (s *SuiteWebhook) TestTail(c *C) {
+	c.Assert(tail([]byte("foo"), 5), Equals, "foo")
+	c.Assert(tail([]byte("foobar"), 3), Equals, "...bar")
+}
@@ -15,6 +15,7 @@ func main() {
 	parser := flags.NewNamedParser("ofelia", flags.Default)
 	parser.AddCommand("daemon", "daemon process", "", &cli.DaemonCommand{})
 	parser.AddCommand("validate", "validates the config file", "", &cli.ValidateCommand{})
+	parser.AddCommand("run", "runs a job now, printing its output", "", &cli.RunCommand{})
 
 	if _, err := parser.Parse(); err != nil {
 		if _, ok := err.(*flags.Error); ok {